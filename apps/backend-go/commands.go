@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	app "github.com/fahmialfareza/family-tree/backend/internal"
+)
+
+// command is a subcommand of the backend binary, run instead of the server once the
+// environment is loaded and MongoDB is connected.
+type command struct {
+	usage string
+	run   func(ctx context.Context, stores *app.Stores, args []string) error
+}
+
+var commands = map[string]command{
+	"migrate":        {"migrate [status]", runMigrate},
+	"create-admin":   {"create-admin [-password pw] <username> <name>", runCreateAdmin},
+	"reset-password": {"reset-password [-password pw] <username>", runResetPassword},
+	"ensure-indexes": {"ensure-indexes", runEnsureIndexes},
+	"cache-flush":    {"cache-flush", runCacheFlush},
+	"export":         {"export <familyId> [file]", runExport},
+	"import":         {"import [-owner username] [file]", runImport},
+	"audit":          {"audit [-fix] [-codes code,...]", runAudit},
+}
+
+// usage prints the subcommands to stderr.
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: backend [command]")
+	fmt.Fprintln(os.Stderr, "without a command, runs the HTTP server. commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// runMigrate applies the pending migrations, or with "status" lists every migration and
+// whether it has been applied.
+func runMigrate(ctx context.Context, stores *app.Stores, args []string) error {
+	if len(args) > 0 && args[0] == "status" {
+		statuses, err := app.ListMigrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			state := "pending"
+			if st.AppliedAt != nil {
+				state = fmt.Sprintf("applied %s, %d documents changed", st.AppliedAt.Format("2006-01-02 15:04:05"), st.Changed)
+			}
+			fmt.Printf("%3d  %-24s %s\n", st.Version, st.Name, state)
+		}
+		return nil
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown argument %q", args[0])
+	}
+	ran := 0
+	err := app.RunMigrations(ctx, func(st app.MigrationStatus) {
+		ran++
+		fmt.Printf("applied %d (%s): %d documents changed\n", st.Version, st.Name, st.Changed)
+	})
+	if err != nil {
+		return err
+	}
+	if ran == 0 {
+		fmt.Println("no pending migrations")
+	}
+	return nil
+}
+
+// readPassword returns the -password flag value or, without it, the first line of stdin,
+// so passwords can be piped in instead of showing up in the shell history.
+func readPassword(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runCreateAdmin creates an admin account.
+func runCreateAdmin(ctx context.Context, stores *app.Stores, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	password := fs.String("password", "", "password (read from stdin when omitted)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("want <username> <name>")
+	}
+	pw, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	u, err := app.CreateAdmin(ctx, stores, fs.Arg(0), fs.Arg(1), pw)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created admin %s (%s)\n", u.Username, u.ID)
+	return nil
+}
+
+// runResetPassword sets a new password for an existing user.
+func runResetPassword(ctx context.Context, stores *app.Stores, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "new password (read from stdin when omitted)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("want <username>")
+	}
+	pw, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+	if err := app.ResetPassword(ctx, stores, fs.Arg(0), pw); err != nil {
+		return err
+	}
+	fmt.Printf("password of %s reset\n", fs.Arg(0))
+	return nil
+}
+
+// runEnsureIndexes creates the MongoDB indexes, as the server does on startup.
+func runEnsureIndexes(ctx context.Context, stores *app.Stores, args []string) error {
+	return app.InitIndexes(ctx)
+}
+
+// runCacheFlush drops every cached entry from Redis.
+func runCacheFlush(ctx context.Context, stores *app.Stores, args []string) error {
+	if !app.FlushCache(ctx) {
+		return errors.New("redis is not configured")
+	}
+	fmt.Println("cache flushed")
+	return nil
+}
+
+// runExport writes a family, its members and their relationships as JSON to file, or to
+// stdout without one.
+func runExport(ctx context.Context, stores *app.Stores, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("want <familyId> [file]")
+	}
+	data, err := app.ExportFamily(ctx, stores, args[0])
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if len(args) == 2 {
+		f, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d people and %d relationships\n", len(data.People), len(data.Relationships))
+	return nil
+}
+
+// runImport creates a family from an export read from file, or from stdin without one.
+// -owner gives the imported documents to that user instead of the exported owners.
+func runImport(ctx context.Context, stores *app.Stores, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	owner := fs.String("owner", "", "username to own the imported family and people")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("want [file]")
+	}
+	in := io.Reader(os.Stdin)
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var data app.FamilyExport
+	if err := json.NewDecoder(in).Decode(&data); err != nil {
+		return fmt.Errorf("read export: %w", err)
+	}
+	f, err := app.ImportFamily(ctx, stores, &data, *owner)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported family %q as %s: %d people, %d relationships\n", f.Name, f.ID, len(data.People), len(data.Relationships))
+	return nil
+}
+
+// runAudit prints the data audit findings; with -fix it applies the auto-fixes, limited
+// to -codes when given.
+func runAudit(ctx context.Context, stores *app.Stores, args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "apply the auto-fixes")
+	codes := fs.String("codes", "", "comma-separated finding codes to fix (default: all fixable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *fix {
+		var only []string
+		if *codes != "" {
+			only = strings.Split(*codes, ",")
+		}
+		fixed, err := app.FixAudit(ctx, stores, only)
+		if err != nil {
+			return err
+		}
+		for _, f := range fixed {
+			fmt.Printf("fixed %-28s %s %s\n", f.Code, f.Collection, f.DocumentID)
+		}
+		fmt.Printf("%d findings fixed\n", len(fixed))
+		return nil
+	}
+	report, err := app.RunAudit(ctx, stores)
+	if err != nil {
+		return err
+	}
+	for _, f := range report.Findings {
+		fix := ""
+		if f.Fix != "" {
+			fix = " [fix: " + f.Fix + "]"
+		}
+		fmt.Printf("%-28s %s %s: %s%s\n", f.Code, f.Collection, f.DocumentID, f.Message, fix)
+	}
+	fmt.Printf("scanned %d people, %d relationships, %d families: %d findings\n", report.People, report.Relationships, report.Families, len(report.Findings))
+	return nil
+}
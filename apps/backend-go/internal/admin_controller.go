@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getAudit(c *gin.Context) {
+	defer startSpan(c, "handler/getAudit").End()
+	report, err := runDataAudit(c)
+	if err != nil {
+		responseError(c, "Failed to run audit", 500)
+		return
+	}
+	responseSuccess(c, report, 200)
+}
+
+func fixAudit(c *gin.Context) {
+	defer startSpan(c, "handler/fixAudit").End()
+	var body auditFixRequest
+	if c.Request.ContentLength != 0 {
+		if errs := bindRequest(c, &body); errs != nil {
+			responseInvalid(c, errs)
+			return
+		}
+	}
+	fixed, err := applyAuditFixes(c, body.Codes)
+	if err != nil {
+		responseError(c, "Failed to apply audit fixes", 500)
+		return
+	}
+	responseSuccess(c, fixed, 200)
+}
+
+// getConfig shows the configuration the server runs with, secrets redacted.
+func getConfig(c *gin.Context) {
+	defer startSpan(c, "handler/getConfig").End()
+	responseSuccess(c, configFrom(c).Redacted(), 200)
+}
+
+// getSecurityLog lists security log entries, newest first. Filters: actor, action, target,
+// ip, outcome, from, to; limit defaults to 100 (max 1000).
+func getSecurityLog(c *gin.Context) {
+	defer startSpan(c, "handler/getSecurityLog").End()
+	filter, err := parseSecurityLogFilter(c)
+	if err != nil {
+		responseError(c, err.Error(), 400)
+		return
+	}
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			responseError(c, "Invalid limit", 400)
+			return
+		}
+		limit = min(n, 1000)
+	}
+	events := []*SecurityEvent{}
+	err = storesFrom(c).SecurityLog.Each(c, filter, limit, func(ev *SecurityEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		responseError(c, "Failed to fetch security log", 500)
+		return
+	}
+	responseSuccess(c, events, 200)
+}
+
+// exportSecurityLog streams every matching security log entry as JSON lines.
+func exportSecurityLog(c *gin.Context) {
+	defer startSpan(c, "handler/exportSecurityLog").End()
+	filter, err := parseSecurityLogFilter(c)
+	if err != nil {
+		responseError(c, err.Error(), 400)
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="security-log.jsonl"`)
+	c.Status(200)
+	enc := json.NewEncoder(c.Writer)
+	err = storesFrom(c).SecurityLog.Each(c, filter, 0, func(ev *SecurityEvent) error {
+		return enc.Encode(ev)
+	})
+	if err != nil {
+		// headers are already sent; the truncated body is all we can signal
+		_ = c.Error(err)
+	}
+}
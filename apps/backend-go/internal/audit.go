@@ -0,0 +1,231 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Data-quality audit finding codes.
+const (
+	auditMissingInverse       = "missing_inverse"
+	auditDanglingEdge         = "dangling_edge"
+	auditDuplicateEdge        = "duplicate_edge"
+	auditOrphanPerson         = "orphan_person"
+	auditFamilyDeletedPerson  = "family_deleted_person"
+	auditLegacyStringID       = "legacy_string_id"
+	auditFutureBirthDate      = "future_birth_date"
+	auditParentBornAfterChild = "parent_born_after_child"
+	auditMarriageEndedBefore  = "marriage_end_before_start"
+)
+
+// Auto-fix actions attached to findings that can be repaired without a human decision.
+const (
+	auditFixInsertInverse      = "insert_inverse"
+	auditFixDeleteRelationship = "soft_delete_relationship"
+	auditFixConvertIDs         = "convert_ids"
+)
+
+// AuditFinding is a single data-quality problem found in the family graph.
+type AuditFinding struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Collection string `json:"collection"`
+	DocumentID string `json:"documentId"`
+	Fix        string `json:"fix,omitempty"` // empty when the problem needs a human decision
+
+	// relationship is the edge the fix acts on (the missing inverse for insert_inverse)
+	relationship *Relationship
+}
+
+// AuditReport is the result of a full scan of people, relationships and families.
+type AuditReport struct {
+	ScannedAt     time.Time      `json:"scannedAt"`
+	People        int            `json:"people"`
+	Relationships int            `json:"relationships"`
+	Families      int            `json:"families"`
+	Summary       map[string]int `json:"summary"`
+	Findings      []AuditFinding `json:"findings"`
+}
+
+// inverseRelationshipType returns the type of the edge expected in the opposite direction.
+func inverseRelationshipType(typ string) string {
+	switch typ {
+	case "parent":
+		return "child"
+	case "child":
+		return "parent"
+	case "spouse":
+		return "spouse"
+	}
+	return ""
+}
+
+// runDataAudit scans the people, relationships and families collections and reports
+// integrity problems.
+func runDataAudit(ctx context.Context) (*AuditReport, error) {
+	defer startSpan(ctx, "service/runDataAudit").End()
+	people, rels, families, err := storesFrom(ctx).Audit.Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &AuditReport{
+		ScannedAt:     time.Now(),
+		People:        len(people),
+		Relationships: len(rels),
+		Families:      len(families),
+		Summary:       map[string]int{},
+		Findings:      []AuditFinding{},
+	}
+	add := func(f AuditFinding) {
+		report.Summary[f.Code]++
+		report.Findings = append(report.Findings, f)
+	}
+
+	byID := map[string]auditPerson{}
+	for _, p := range people {
+		byID[p.ID] = p
+	}
+	live := func(id string) bool {
+		p, ok := byID[id]
+		return ok && !p.Deleted
+	}
+
+	now := time.Now()
+	for _, p := range people {
+		if !p.Deleted && p.BirthDate.After(now) {
+			add(AuditFinding{Code: auditFutureBirthDate, Message: fmt.Sprintf("%s has a birth date in the future", p.Name), Collection: "people", DocumentID: p.ID})
+		}
+	}
+
+	// relationships: legacy IDs, dangling edges and duplicates first; only the surviving
+	// edges take part in the inverse and date checks
+	edges := map[string]auditRelationship{}
+	connected := map[string]bool{}
+	for _, r := range rels {
+		edge := r.Relationship
+		if r.LegacyIDs {
+			// strings that aren't ObjectID hex can't be converted; they show up as dangling too
+			fix := ""
+			if isObjectIDHex(r.From) && isObjectIDHex(r.To) {
+				fix = auditFixConvertIDs
+			}
+			add(AuditFinding{Code: auditLegacyStringID, Message: "Relationship stores from/to as strings instead of ObjectIDs", Collection: "relationships", DocumentID: r.ID, Fix: fix, relationship: &edge})
+		}
+		if !live(r.From) || !live(r.To) {
+			add(AuditFinding{Code: auditDanglingEdge, Message: fmt.Sprintf("Relationship %s -> %s points to a deleted or missing person", r.From, r.To), Collection: "relationships", DocumentID: r.ID, Fix: auditFixDeleteRelationship, relationship: &edge})
+			continue
+		}
+		key := r.From + "_" + r.To + "_" + r.Type
+		if first, ok := edges[key]; ok {
+			add(AuditFinding{Code: auditDuplicateEdge, Message: fmt.Sprintf("Duplicate of relationship %s", first.ID), Collection: "relationships", DocumentID: r.ID, Fix: auditFixDeleteRelationship, relationship: &edge})
+			continue
+		}
+		edges[key] = r
+		connected[r.From] = true
+		connected[r.To] = true
+	}
+
+	datesChecked := map[string]bool{}
+	for _, r := range rels {
+		if edges[r.From+"_"+r.To+"_"+r.Type].ID != r.ID {
+			continue
+		}
+		if invType := inverseRelationshipType(r.Type); invType != "" {
+			if _, ok := edges[r.To+"_"+r.From+"_"+invType]; !ok {
+				inverse := Relationship{From: r.To, To: r.From, Type: invType, Subtype: r.Subtype, Order: r.Order, Marriage: r.Marriage}
+				add(AuditFinding{Code: auditMissingInverse, Message: fmt.Sprintf("Relationship %s has no %s edge back", r.Type, invType), Collection: "relationships", DocumentID: r.ID, Fix: auditFixInsertInverse, relationship: &inverse})
+			}
+		}
+		// date checks are reported once per pair, not once per direction
+		if parentID, childID, ok := parentChildPair(r.Relationship); ok && !datesChecked[parentID+"_"+childID] {
+			datesChecked[parentID+"_"+childID] = true
+			parent, child := byID[parentID], byID[childID]
+			if !parent.BirthDate.IsZero() && !child.BirthDate.IsZero() && parent.BirthDate.After(child.BirthDate) {
+				add(AuditFinding{Code: auditParentBornAfterChild, Message: fmt.Sprintf("%s was born after their child %s", parent.Name, child.Name), Collection: "relationships", DocumentID: r.ID})
+			}
+		}
+		if m := r.Marriage; m != nil && r.Type == "spouse" && !datesChecked[r.To+"_"+r.From] {
+			datesChecked[r.From+"_"+r.To] = true
+			if m.StartDate != nil && m.EndDate != nil && m.EndDate.Before(*m.StartDate) {
+				add(AuditFinding{Code: auditMarriageEndedBefore, Message: "Marriage end date is before its start date", Collection: "relationships", DocumentID: r.ID})
+			}
+		}
+	}
+
+	// founders and founding spouses must be live; explicit members only have to exist,
+	// since deleting a person leaves their memberships for a restore to bring back
+	inFamily := map[string]bool{}
+	for _, f := range families {
+		for _, c := range f.Founders {
+			for _, id := range []string{c.Person, c.Spouse} {
+				if id == "" {
+					continue
+				}
+				inFamily[id] = true
+				if !live(id) {
+					add(AuditFinding{Code: auditFamilyDeletedPerson, Message: fmt.Sprintf("Family %q is founded by deleted or missing person %s", f.Name, id), Collection: "families", DocumentID: f.ID})
+				}
+			}
+		}
+		for _, id := range f.Members {
+			inFamily[id] = true
+			if _, ok := byID[id]; !ok {
+				add(AuditFinding{Code: auditFamilyDeletedPerson, Message: fmt.Sprintf("Family %q lists missing person %s as a member", f.Name, id), Collection: "families", DocumentID: f.ID})
+			}
+		}
+	}
+
+	for _, p := range people {
+		if !p.Deleted && !connected[p.ID] && !inFamily[p.ID] {
+			add(AuditFinding{Code: auditOrphanPerson, Message: fmt.Sprintf("%s has no relationships and no family", p.Name), Collection: "people", DocumentID: p.ID})
+		}
+	}
+
+	return report, nil
+}
+
+// applyAuditFixes runs the audit and applies the auto-fix of every finding whose code is
+// in codes (all fixable findings when codes is empty). It returns the findings it fixed.
+func applyAuditFixes(ctx context.Context, codes []string) ([]AuditFinding, error) {
+	defer startSpan(ctx, "service/applyAuditFixes").End()
+	report, err := runDataAudit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, code := range codes {
+		wanted[code] = true
+	}
+
+	fixed := []AuditFinding{}
+	inserts, deletes, converts := []Relationship{}, []Relationship{}, []Relationship{}
+	deleting := map[string]bool{}
+	for _, f := range report.Findings {
+		if f.Fix == "" || (len(wanted) > 0 && !wanted[f.Code]) {
+			continue
+		}
+		switch f.Fix {
+		case auditFixInsertInverse:
+			inserts = append(inserts, *f.relationship)
+		case auditFixDeleteRelationship:
+			if deleting[f.DocumentID] {
+				continue
+			}
+			deleting[f.DocumentID] = true
+			deletes = append(deletes, *f.relationship)
+		case auditFixConvertIDs:
+			converts = append(converts, *f.relationship)
+		}
+		fixed = append(fixed, f)
+	}
+
+	if err := storesFrom(ctx).Audit.ConvertIDs(ctx, converts); err != nil {
+		return nil, err
+	}
+	if _, err := storesFrom(ctx).Relationships.Apply(ctx, inserts, nil, deletes); err != nil {
+		return nil, err
+	}
+	return fixed, nil
+}
@@ -1,38 +1,31 @@
 package app
 
 import (
-	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/newrelic/go-agent/v3/newrelic"
-	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// jwtSecret is resolved once at package init to avoid os.Getenv on every request.
-var jwtSecret = func() []byte {
-	s := os.Getenv("JWT_SECRET")
-	if s == "" {
-		s = "family-tree-secret"
-	}
-	return []byte(s)
-}()
-
 func signIn(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/signIn").End()
-	var body struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+	defer startSpan(c, "handler/signIn").End()
+	var body signInRequest
+	defer func() { securityLog(c, "auth.signin", body.Username, "") }()
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
 	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		responseError(c, "Missing username or password", 400)
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
 		return
 	}
 
-	user, err := findUserByUsername(c, body.Username)
+	user, err := storesFrom(c).Users.GetByUsername(c, body.Username)
 	if err != nil {
 		responseError(c, "Invalid username or password", 401)
 		return
@@ -47,7 +40,7 @@ func signIn(c *gin.Context) {
 		"id":  user.ID,
 		"exp": time.Now().Add(24 * time.Hour).Unix(),
 	})
-	s, err := token.SignedString(jwtSecret)
+	s, err := token.SignedString([]byte(configFrom(c).JWTSecret))
 	if err != nil {
 		responseError(c, "Failed to sign token", 500)
 		return
@@ -74,7 +67,8 @@ func authenticate(roles []string) gin.HandlerFunc {
 		}
 		tokenStr := parts[1]
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) { return jwtSecret, nil })
+		secret := []byte(configFrom(c).JWTSecret)
+		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) { return secret, nil })
 		if err != nil || !token.Valid {
 			responseError(c, "Invalid token", 401)
 			c.Abort()
@@ -104,7 +98,7 @@ func authenticate(roles []string) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		user, err := findUserById(c, id)
+		user, err := storesFrom(c).Users.Get(c, id)
 		if err != nil {
 			responseError(c, "User not found", 401)
 			c.Abort()
@@ -122,6 +116,8 @@ func authenticate(roles []string) gin.HandlerFunc {
 			}
 			if !allowed {
 				responseError(c, "Forbidden", 403)
+				c.Set("user", user)
+				securityLog(c, "auth.authorize", c.Request.Method+" "+c.FullPath(), "")
 				c.Abort()
 				return
 			}
@@ -133,19 +129,22 @@ func authenticate(roles []string) gin.HandlerFunc {
 }
 
 func createUser(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/createUser").End()
-	var body struct {
-		Name     string `json:"name"`
-		Username string `json:"username"`
-		Password string `json:"password"`
+	defer startSpan(c, "handler/createUser").End()
+	var body createUserRequest
+	defer func() { securityLog(c, "user.create", body.Username, "") }()
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
 	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		responseError(c, "Invalid request body", 400)
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
 		return
 	}
 	hashed, _ := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
 	u := &User{ID: "", Name: body.Name, Username: body.Username, Password: string(hashed), Role: RoleUser}
-	newUser, err := addUser(c, u)
+	newUser, err := storesFrom(c).Users.Create(c, u)
 	if err != nil {
 		responseError(c, "Failed to create user", 500)
 		return
@@ -155,7 +154,7 @@ func createUser(c *gin.Context) {
 }
 
 func profile(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/profile").End()
+	defer startSpan(c, "handler/profile").End()
 	u, _ := c.Get("user")
 	t, _ := c.Get("token")
 	user := u.(*User)
@@ -165,12 +164,50 @@ func profile(c *gin.Context) {
 }
 
 func users(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/users").End()
+	defer startSpan(c, "handler/users").End()
 	// only admin allowed by middleware
-	us, err := repoFindUsers(c, bson.M{"role": RoleUser})
+	if isListRequest(c, userListFilters...) {
+		listUsers(c)
+		return
+	}
+	us, err := storesFrom(c).Users.ListByRole(c, RoleUser)
 	if err != nil {
 		responseError(c, "Failed to fetch users", 500)
 		return
 	}
 	responseSuccess(c, us, 200)
 }
+
+var userListSpec = listSpec{
+	sorts:  []string{"name", "username", "_id"},
+	fields: []string{"name", "username", "role"},
+}
+
+var userListFilters = []string{"role"}
+
+// listUsers is the paged form of users. Like the unpaged list it shows only role "user"
+// unless a role filter says otherwise.
+func listUsers(c *gin.Context) {
+	v := &validator{}
+	q := parseListQuery(c, userListSpec, v)
+	q.filterIn(c, v, "role", string(RoleAdmin), string(RoleUser))
+	if !v.valid() {
+		responseInvalidQuery(c, v.errs)
+		return
+	}
+	if _, ok := q.Match["role"]; !ok {
+		q.Match["role"] = RoleUser
+	}
+	us, next, err := storesFrom(c).Users.Page(c, q)
+	if err != nil {
+		responseError(c, "Failed to fetch users", 500)
+		return
+	}
+	respondPage(c, q, us, next)
+}
+
+// canEditPerson reports whether user may make destructive changes to p: admins always,
+// users only for people they own.
+func canEditPerson(user *User, p *Person) bool {
+	return user.Role == RoleAdmin || slices.Contains(p.OwnedBy, user.ID)
+}
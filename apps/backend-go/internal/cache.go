@@ -6,8 +6,6 @@ import (
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 const (
@@ -59,25 +57,28 @@ func cacheKeyFamily(id string) string { return "ft:family:" + id }
 
 // cacheGet deserializes a cached value. Returns (value, true) on hit, zero+false on miss.
 func cacheGet[T any](ctx context.Context, key string) (T, bool) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "cache/get").End()
+	defer startSpan(ctx, "cache/get").End()
 	var zero T
 	if RedisClient == nil {
 		return zero, false
 	}
 	data, err := RedisClient.Get(ctx, key).Bytes()
 	if err != nil {
+		observeCache(key, false)
 		return zero, false
 	}
 	var result T
 	if err := json.Unmarshal(data, &result); err != nil {
+		observeCache(key, false)
 		return zero, false
 	}
+	observeCache(key, true)
 	return result, true
 }
 
 // cacheSet serializes and stores a value with the given TTL. Silently skips if Redis is unavailable.
 func cacheSet(ctx context.Context, key string, value any, ttl time.Duration) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "cache/set").End()
+	defer startSpan(ctx, "cache/set").End()
 	if RedisClient == nil {
 		return
 	}
@@ -90,7 +91,7 @@ func cacheSet(ctx context.Context, key string, value any, ttl time.Duration) {
 
 // cacheDel removes one or more exact keys.
 func cacheDel(ctx context.Context, keys ...string) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "cache/del").End()
+	defer startSpan(ctx, "cache/del").End()
 	if RedisClient == nil || len(keys) == 0 {
 		return
 	}
@@ -99,7 +100,7 @@ func cacheDel(ctx context.Context, keys ...string) {
 
 // cacheDelPattern removes all keys matching the given glob pattern via SCAN+DEL.
 func cacheDelPattern(ctx context.Context, pattern string) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "cache/del-pattern").End()
+	defer startSpan(ctx, "cache/del-pattern").End()
 	if RedisClient == nil {
 		return
 	}
@@ -0,0 +1,403 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Config is everything the backend is configured with. LoadConfig builds it once at
+// startup and it is handed to the subsystems from there; nothing else reads the
+// environment.
+type Config struct {
+	Port      int             `json:"port" yaml:"port" toml:"port"`
+	Server    ServerConfig    `json:"server" yaml:"server" toml:"server"`
+	Mongo     MongoConfig     `json:"mongo" yaml:"mongo" toml:"mongo"`
+	Redis     RedisConfig     `json:"redis" yaml:"redis" toml:"redis"`
+	JWTSecret string          `json:"jwtSecret" yaml:"jwtSecret" toml:"jwtSecret"`
+	Uploads   UploadConfig    `json:"uploads" yaml:"uploads" toml:"uploads"`
+	NewRelic  NewRelicConfig  `json:"newRelic" yaml:"newRelic" toml:"newRelic"`
+	Telemetry TelemetryConfig `json:"telemetry" yaml:"telemetry" toml:"telemetry"`
+	Trash     TrashConfig     `json:"trash" yaml:"trash" toml:"trash"`
+	Log       LogConfig       `json:"log" yaml:"log" toml:"log"`
+}
+
+// ServerConfig holds the HTTP server timeouts. ShutdownTimeout is how long in-flight
+// requests get to finish after SIGINT or SIGTERM before the server closes them.
+type ServerConfig struct {
+	ReadTimeout     Duration `json:"readTimeout" yaml:"readTimeout" toml:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout" yaml:"writeTimeout" toml:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout" yaml:"idleTimeout" toml:"idleTimeout"`
+	ShutdownTimeout Duration `json:"shutdownTimeout" yaml:"shutdownTimeout" toml:"shutdownTimeout"`
+}
+
+// Duration is a time.Duration written as a string like "30s" in config files, the
+// environment and the config dump.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+type MongoConfig struct {
+	URI      string `json:"uri" yaml:"uri" toml:"uri"`
+	Database string `json:"database" yaml:"database" toml:"database"`
+}
+
+// RedisConfig enables the cache and the rate limiter; both are off without a URL.
+type RedisConfig struct {
+	URL string `json:"url" yaml:"url" toml:"url"`
+}
+
+// UploadConfig chooses where uploaded images go: Cloudinary when CloudinaryURL is set,
+// otherwise files in Dir.
+type UploadConfig struct {
+	Dir           string `json:"dir" yaml:"dir" toml:"dir"`
+	CloudinaryURL string `json:"cloudinaryUrl" yaml:"cloudinaryUrl" toml:"cloudinaryUrl"`
+}
+
+// NewRelicConfig turns the agent on when both the app name and the license key are set
+// and Enabled isn't switched off.
+type NewRelicConfig struct {
+	AppName    string `json:"appName" yaml:"appName" toml:"appName"`
+	LicenseKey string `json:"licenseKey" yaml:"licenseKey" toml:"licenseKey"`
+	Enabled    bool   `json:"enabled" yaml:"enabled" toml:"enabled"`
+}
+
+// TelemetryConfig picks the tracing and metrics backends. Tracing is "newrelic", which
+// only records anything when NewRelic is configured, "otlp" to export OpenTelemetry
+// traces to OTLPEndpoint over HTTP, or "none". Metrics are "prometheus", served on
+// /metrics, or "none".
+type TelemetryConfig struct {
+	Tracing      string `json:"tracing" yaml:"tracing" toml:"tracing"`
+	Metrics      string `json:"metrics" yaml:"metrics" toml:"metrics"`
+	ServiceName  string `json:"serviceName" yaml:"serviceName" toml:"serviceName"`
+	OTLPEndpoint string `json:"otlpEndpoint" yaml:"otlpEndpoint" toml:"otlpEndpoint"`
+}
+
+type TrashConfig struct {
+	// RetentionDays is how long soft-deleted documents are kept; 0, the default, keeps
+	// them forever.
+	RetentionDays int `json:"retentionDays" yaml:"retentionDays" toml:"retentionDays"`
+}
+
+// LogConfig sets the log format and levels. Level applies to every subsystem not listed
+// in Levels.
+type LogConfig struct {
+	Level  string            `json:"level" yaml:"level" toml:"level"`
+	Format string            `json:"format" yaml:"format" toml:"format"`
+	Levels map[string]string `json:"levels,omitempty" yaml:"levels" toml:"levels"`
+}
+
+// DefaultConfig is the configuration with nothing set: a local MongoDB, no Redis, local
+// uploads, no New Relic, no metrics and no purging of the trash.
+func DefaultConfig() *Config {
+	return &Config{
+		Port: 4000,
+		Server: ServerConfig{
+			// uploads of up to 100 MB have to fit in the read timeout
+			ReadTimeout:     Duration(time.Minute),
+			WriteTimeout:    Duration(time.Minute),
+			IdleTimeout:     Duration(2 * time.Minute),
+			ShutdownTimeout: Duration(20 * time.Second),
+		},
+		Mongo:     MongoConfig{URI: "mongodb://localhost:27017", Database: "family-tree"},
+		JWTSecret: "family-tree-secret",
+		Uploads:   UploadConfig{Dir: "./tmp"},
+		NewRelic:  NewRelicConfig{Enabled: true},
+		Telemetry: TelemetryConfig{
+			Tracing:      "newrelic",
+			Metrics:      "none",
+			ServiceName:  "family-tree-backend",
+			OTLPEndpoint: "http://localhost:4318",
+		},
+		Log: LogConfig{Level: "info", Format: "json"},
+	}
+}
+
+// ConfigError lists every problem found while loading the configuration.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// LoadConfig starts from DefaultConfig, applies the YAML or TOML file named by CONFIG_FILE
+// if set, then the environment variables, which win over the file. It reports all
+// problems at once as a ConfigError.
+func LoadConfig() (*Config, error) {
+	cfg := DefaultConfig()
+	var problems ConfigError
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			problems = append(problems, fmt.Sprintf("CONFIG_FILE %s: %v", path, err))
+		}
+	}
+	problems = append(problems, cfg.loadEnv()...)
+	problems = append(problems, cfg.validate()...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return cfg, nil
+}
+
+// loadFile decodes a config file over cfg. Keys the file doesn't set keep their value;
+// unknown keys are an error, so typos don't go unnoticed.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yaml.UnmarshalWithOptions(data, cfg, yaml.DisallowUnknownField())
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(cfg)
+	}
+	return errors.New("unknown format, want .yaml, .yml or .toml")
+}
+
+// loadEnv applies the environment variables that are set and returns the ones that
+// don't parse. An empty variable counts as unset, as it always has.
+func (cfg *Config) loadEnv() ConfigError {
+	var problems ConfigError
+	str := func(name string, dst *string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if v := os.Getenv(name); v != "" {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a duration like 30s", name, v))
+			}
+		}
+	}
+	flag := func(name string, dst *bool) {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not true or false", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	num("PORT", &cfg.Port)
+	duration("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	duration("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	duration("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	str("MONGO_URI", &cfg.Mongo.URI)
+	str("MONGO_DB", &cfg.Mongo.Database)
+	str("REDIS_URL", &cfg.Redis.URL)
+	str("JWT_SECRET", &cfg.JWTSecret)
+	str("UPLOAD_DIR", &cfg.Uploads.Dir)
+	str("CLOUDINARY_URL", &cfg.Uploads.CloudinaryURL)
+	str("NEW_RELIC_APP_NAME", &cfg.NewRelic.AppName)
+	str("NEW_RELIC_LICENSE_KEY", &cfg.NewRelic.LicenseKey)
+	flag("NEW_RELIC_ENABLED", &cfg.NewRelic.Enabled)
+	str("TRACING_BACKEND", &cfg.Telemetry.Tracing)
+	str("METRICS_BACKEND", &cfg.Telemetry.Metrics)
+	str("OTEL_SERVICE_NAME", &cfg.Telemetry.ServiceName)
+	str("OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.Telemetry.OTLPEndpoint)
+	num("TRASH_RETENTION_DAYS", &cfg.Trash.RetentionDays)
+	str("LOG_LEVEL", &cfg.Log.Level)
+	str("LOG_FORMAT", &cfg.Log.Format)
+	// LOG_LEVELS is a list like "tree=debug,store=debug"; it adds to the file's levels
+	if v := os.Getenv("LOG_LEVELS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			name, level, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				problems = append(problems, fmt.Sprintf("LOG_LEVELS: %q is not subsystem=level", pair))
+				continue
+			}
+			cfg.setLogLevel(name, level)
+		}
+	}
+	// TREE_DEBUG predates log levels; it still turns on the tree and store debug logs
+	var treeDebug bool
+	flag("TREE_DEBUG", &treeDebug)
+	if treeDebug {
+		for _, name := range []string{logTree, logStore} {
+			if _, ok := cfg.Log.Levels[name]; !ok {
+				cfg.setLogLevel(name, "debug")
+			}
+		}
+	}
+	return problems
+}
+
+func (cfg *Config) setLogLevel(subsystem, level string) {
+	if cfg.Log.Levels == nil {
+		cfg.Log.Levels = map[string]string{}
+	}
+	cfg.Log.Levels[subsystem] = level
+}
+
+// validate checks the values that would otherwise only fail when first used.
+func (cfg *Config) validate() ConfigError {
+	var problems ConfigError
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port: %d is not between 1 and 65535", cfg.Port))
+	}
+	positive := func(name string, d Duration) {
+		if d <= 0 {
+			problems = append(problems, name+": must be positive")
+		}
+	}
+	positive("server.readTimeout", cfg.Server.ReadTimeout)
+	positive("server.writeTimeout", cfg.Server.WriteTimeout)
+	positive("server.idleTimeout", cfg.Server.IdleTimeout)
+	positive("server.shutdownTimeout", cfg.Server.ShutdownTimeout)
+	if !strings.HasPrefix(cfg.Mongo.URI, "mongodb://") && !strings.HasPrefix(cfg.Mongo.URI, "mongodb+srv://") {
+		problems = append(problems, "mongo.uri: must start with mongodb:// or mongodb+srv://")
+	}
+	if cfg.Mongo.Database == "" {
+		problems = append(problems, "mongo.database: required")
+	}
+	if cfg.Redis.URL != "" {
+		if _, err := redis.ParseURL(cfg.Redis.URL); err != nil {
+			problems = append(problems, fmt.Sprintf("redis.url: %v", err))
+		}
+	}
+	if cfg.JWTSecret == "" {
+		problems = append(problems, "jwtSecret: required")
+	}
+	if cfg.Uploads.Dir == "" {
+		problems = append(problems, "uploads.dir: required")
+	}
+	if cfg.Uploads.CloudinaryURL != "" {
+		if u, err := url.Parse(cfg.Uploads.CloudinaryURL); err != nil || u.Scheme != "cloudinary" || u.Host == "" {
+			problems = append(problems, "uploads.cloudinaryUrl: want cloudinary://<key>:<secret>@<cloud name>")
+		}
+	}
+	if cfg.NewRelic.LicenseKey != "" && cfg.NewRelic.AppName == "" {
+		problems = append(problems, "newRelic.appName: required with a license key")
+	}
+	if !slices.Contains(tracingBackends, cfg.Telemetry.Tracing) {
+		problems = append(problems, fmt.Sprintf("telemetry.tracing: %q is not one of %s", cfg.Telemetry.Tracing, strings.Join(tracingBackends, ", ")))
+	}
+	if !slices.Contains(metricsBackends, cfg.Telemetry.Metrics) {
+		problems = append(problems, fmt.Sprintf("telemetry.metrics: %q is not one of %s", cfg.Telemetry.Metrics, strings.Join(metricsBackends, ", ")))
+	}
+	if cfg.Telemetry.Tracing == "otlp" {
+		if cfg.Telemetry.ServiceName == "" {
+			problems = append(problems, "telemetry.serviceName: required for otlp tracing")
+		}
+		if u, err := url.Parse(cfg.Telemetry.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "telemetry.otlpEndpoint: want an http:// or https:// URL")
+		}
+	}
+	if cfg.Trash.RetentionDays < 0 {
+		problems = append(problems, "trash.retentionDays: must not be negative")
+	}
+	if _, err := parseLogLevel(cfg.Log.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level: %q is not debug, info, warn or error", cfg.Log.Level))
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		problems = append(problems, fmt.Sprintf("log.format: %q is not json or text", cfg.Log.Format))
+	}
+	names := make([]string, 0, len(cfg.Log.Levels))
+	for name := range cfg.Log.Levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !slices.Contains(logSubsystems, name) {
+			problems = append(problems, fmt.Sprintf("log.levels: unknown subsystem %q, want one of %s", name, strings.Join(logSubsystems, ", ")))
+		} else if _, err := parseLogLevel(cfg.Log.Levels[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("log.levels.%s: %q is not debug, info, warn or error", name, cfg.Log.Levels[name]))
+		}
+	}
+	return problems
+}
+
+// redactedValue replaces secrets in Redacted.
+const redactedValue = "[redacted]"
+
+// Redacted is a copy of cfg that is safe to show: secrets are replaced and credentials
+// are removed from connection URLs.
+func (cfg *Config) Redacted() *Config {
+	out := *cfg
+	secret := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return redactedValue
+	}
+	out.Mongo.URI = redactURL(cfg.Mongo.URI)
+	out.Redis.URL = redactURL(cfg.Redis.URL)
+	out.JWTSecret = secret(cfg.JWTSecret)
+	out.Uploads.CloudinaryURL = redactURL(cfg.Uploads.CloudinaryURL)
+	out.NewRelic.LicenseKey = secret(cfg.NewRelic.LicenseKey)
+	out.Telemetry.OTLPEndpoint = redactURL(cfg.Telemetry.OTLPEndpoint)
+	return &out
+}
+
+// redactURL hides the password of a URL's user info. A URL that doesn't parse is hidden
+// entirely, since there's no telling where its secret is.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redactedValue
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// useConfig makes cfg available to the handlers of a request.
+func useConfig(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("config", cfg)
+		c.Next()
+	}
+}
+
+// configFrom returns the configuration useConfig attached to the request. It only takes a
+// request, and panics when the request has no configuration: falling back to the defaults
+// would quietly sign tokens with the default JWT secret. Code outside a request is handed
+// the part of the *Config it needs instead.
+func configFrom(c *gin.Context) *Config {
+	return c.MustGet("config").(*Config)
+}
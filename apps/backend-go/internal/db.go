@@ -2,11 +2,11 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"sync/atomic"
 	"time"
 
-	nrmongo "github.com/newrelic/go-agent/v3/integrations/nrmongo"
 	nrredis "github.com/newrelic/go-agent/v3/integrations/nrredis-v9"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson"
@@ -20,6 +20,46 @@ var (
 	RedisClient *redis.Client
 )
 
+// indexesEnsured is set once InitIndexes has succeeded; the server isn't ready before.
+var indexesEnsured atomic.Bool
+
+// transactionsUnsupported is flipped the first time the server rejects a transaction
+// (standalone mongod), so later writes skip straight to the non-transactional path.
+var transactionsUnsupported atomic.Bool
+
+// withTransaction runs fn inside a MongoDB session transaction. On standalone servers,
+// which reject transactions, fn is run once more without a session.
+func withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if MongoClient == nil || transactionsUnsupported.Load() {
+		return fn(ctx)
+	}
+	sess, err := MongoClient.StartSession()
+	if err != nil {
+		return fn(ctx)
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	if err != nil && isTransactionUnsupported(err) {
+		transactionsUnsupported.Store(true)
+		logger(logDB).WarnContext(ctx, "mongo transactions unsupported, falling back to non-transactional writes")
+		return fn(ctx)
+	}
+	return err
+}
+
+// isTransactionUnsupported reports whether err is the IllegalOperation (code 20) error a
+// standalone server returns when asked to start a transaction.
+func isTransactionUnsupported(err error) bool {
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		return se.HasErrorCode(20)
+	}
+	return false
+}
+
 // InitIndexes creates all necessary indexes on startup. Safe to call multiple times (uses CreateIfNotExists semantics).
 func InitIndexes(ctx context.Context) error {
 	type indexDef struct {
@@ -38,7 +78,33 @@ func InitIndexes(ctx context.Context) error {
 			"people",
 			mongo.IndexModel{Keys: bson.D{{Key: "ownedBy", Value: 1}, {Key: "deleted", Value: 1}}},
 		},
-		// families: ownership filter + person foreign key used in $lookup
+		// people: keyset pagination on the sortable fields
+		{
+			"people",
+			mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		{
+			"people",
+			mongo.IndexModel{Keys: bson.D{{Key: "birthDate", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		// people: person search (text index plus fuzzy keys)
+		{
+			"people",
+			mongo.IndexModel{
+				Keys: bson.D{{Key: "name", Value: "text"}, {Key: "nickname", Value: "text"}, {Key: "address", Value: "text"}},
+				// language "none" skips English stemming and stop words, which mangle Indonesian names
+				Options: options.Index().
+					SetName("people_search_text").
+					SetWeights(bson.D{{Key: "name", Value: 10}, {Key: "nickname", Value: 5}, {Key: "address", Value: 1}}).
+					SetDefaultLanguage("none"),
+			},
+		},
+		{
+			"people",
+			mongo.IndexModel{Keys: bson.D{{Key: "searchKeys", Value: 1}}},
+		},
+		// families: ownership filter, person foreign key used in $lookup, and the founder and
+		// member lookups of ListByPerson
 		{
 			"families",
 			mongo.IndexModel{Keys: bson.D{{Key: "ownedBy", Value: 1}, {Key: "deleted", Value: 1}}},
@@ -47,6 +113,22 @@ func InitIndexes(ctx context.Context) error {
 			"families",
 			mongo.IndexModel{Keys: bson.D{{Key: "person", Value: 1}}},
 		},
+		{
+			"families",
+			mongo.IndexModel{Keys: bson.D{{Key: "founders.person", Value: 1}}},
+		},
+		{
+			"families",
+			mongo.IndexModel{Keys: bson.D{{Key: "founders.spouse", Value: 1}}},
+		},
+		{
+			"families",
+			mongo.IndexModel{Keys: bson.D{{Key: "members", Value: 1}}},
+		},
+		{
+			"families",
+			mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}},
+		},
 		// relationships: graph traversal queries filter by from/to + deleted
 		{
 			"relationships",
@@ -56,6 +138,24 @@ func InitIndexes(ctx context.Context) error {
 			"relationships",
 			mongo.IndexModel{Keys: bson.D{{Key: "to", Value: 1}, {Key: "deleted", Value: 1}}},
 		},
+		// securityLog: admin queries filter by actor and time
+		{
+			"securityLog",
+			mongo.IndexModel{Keys: bson.D{{Key: "at", Value: -1}}},
+		},
+		{
+			"securityLog",
+			mongo.IndexModel{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "at", Value: -1}}},
+		},
+		// revisions: per-document history and as-of reconstruction
+		{
+			"revisions",
+			mongo.IndexModel{Keys: bson.D{{Key: "collection", Value: 1}, {Key: "documentId", Value: 1}, {Key: "changedAt", Value: -1}}},
+		},
+		{
+			"revisions",
+			mongo.IndexModel{Keys: bson.D{{Key: "collection", Value: 1}, {Key: "changedAt", Value: 1}}},
+		},
 	}
 
 	for _, idx := range indexes {
@@ -64,18 +164,15 @@ func InitIndexes(ctx context.Context) error {
 			return fmt.Errorf("create index on %s: %w", idx.collection, err)
 		}
 	}
-	fmt.Println("MongoDB indexes ensured")
+	logger(logDB).InfoContext(ctx, "indexes ensured")
+	indexesEnsured.Store(true)
 	return nil
 }
 
-func InitMongo(ctx context.Context) error {
-	uri := os.Getenv("MONGO_URI")
-	if uri == "" {
-		uri = "mongodb://localhost:27017"
-	}
-	// nrmongo command monitor instruments all MongoDB operations with New Relic segments
-	monitor := nrmongo.NewCommandMonitor(nil)
-	clientOpts := options.Client().ApplyURI(uri).SetServerSelectionTimeout(5 * time.Second).SetMonitor(monitor)
+// InitMongo connects to MongoDB and, when configured, Redis.
+func InitMongo(ctx context.Context, cfg *Config) error {
+	// the command monitor times and traces every MongoDB operation
+	clientOpts := options.Client().ApplyURI(cfg.Mongo.URI).SetServerSelectionTimeout(5 * time.Second).SetMonitor(mongoMonitor())
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
 		return err
@@ -84,27 +181,38 @@ func InitMongo(ctx context.Context) error {
 		return err
 	}
 	MongoClient = client
-	dbName := os.Getenv("MONGO_DB")
-	if dbName == "" {
-		dbName = "family-tree"
-	}
-	MongoDB = client.Database(dbName)
-	fmt.Println("Connected to MongoDB", uri, "db", dbName)
-	// init redis if provided
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL != "" {
-		if opt, err := redis.ParseURL(redisURL); err == nil {
-			RedisClient = redis.NewClient(opt)
-			// nrredis hook instruments all Redis commands with New Relic datastore segments
-			RedisClient.AddHook(nrredis.NewHook(opt))
-			if err := RedisClient.Ping(ctx).Err(); err != nil {
-				fmt.Println("warning: redis ping failed:", err)
-			} else {
-				fmt.Println("Connected to Redis", redisURL)
-			}
+	MongoDB = client.Database(cfg.Mongo.Database)
+	logger(logDB).InfoContext(ctx, "connected to mongo", "uri", redactURL(cfg.Mongo.URI), "database", cfg.Mongo.Database)
+	// init redis if provided; LoadConfig has already checked the URL
+	if cfg.Redis.URL != "" {
+		opt, err := redis.ParseURL(cfg.Redis.URL)
+		if err != nil {
+			return err
+		}
+		RedisClient = redis.NewClient(opt)
+		// nrredis hook instruments all Redis commands with New Relic datastore segments
+		RedisClient.AddHook(nrredis.NewHook(opt))
+		if err := RedisClient.Ping(ctx).Err(); err != nil {
+			logger(logDB).WarnContext(ctx, "redis ping failed", "error", err)
 		} else {
-			fmt.Println("warning: invalid REDIS_URL", err)
+			logger(logDB).InfoContext(ctx, "connected to redis", "url", redactURL(cfg.Redis.URL))
 		}
 	}
 	return nil
 }
+
+// Disconnect closes the MongoDB and Redis connections opened by InitMongo.
+func Disconnect(ctx context.Context) error {
+	var errs []error
+	if RedisClient != nil {
+		if err := RedisClient.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("redis: %w", err))
+		}
+	}
+	if MongoClient != nil {
+		if err := MongoClient.Disconnect(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("mongo: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
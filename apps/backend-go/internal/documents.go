@@ -0,0 +1,262 @@
+package app
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The document types below are how users, people, families and relationships are stored.
+// They are only used for decoding: writes build their documents with personFields,
+// familyFields and relationshipDoc. Every read path decodes into them and converts with
+// their user, person, family and relationship methods, so the API models are built in one
+// place.
+//
+// Data written by the Node backend is loose about types, so references, dates and numbers
+// use the lenient types below: a value they can't read decodes to the zero value instead
+// of failing the whole document.
+
+// docID is a reference stored as an ObjectID or, in older documents, as its hex string.
+// It holds the hex form.
+type docID string
+
+func (id *docID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bson.RawValue{Type: t, Value: data}
+	*id = ""
+	if oid, ok := v.ObjectIDOK(); ok {
+		*id = docID(oid.Hex())
+	} else if s, ok := v.StringValueOK(); ok {
+		*id = docID(s)
+	}
+	return nil
+}
+
+// docTime is a date stored as a BSON DateTime or, in older documents, as an RFC 3339
+// string.
+type docTime struct{ time.Time }
+
+func (d *docTime) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bson.RawValue{Type: t, Value: data}
+	d.Time = time.Time{}
+	if dt, ok := v.DateTimeOK(); ok {
+		d.Time = primitive.DateTime(dt).Time()
+	} else if s, ok := v.StringValueOK(); ok {
+		if parsed, err := time.Parse(time.RFC3339, s); err == nil {
+			d.Time = parsed
+		}
+	}
+	return nil
+}
+
+// timePtr returns the date, or nil when it is missing or unreadable.
+func (d *docTime) timePtr() *time.Time {
+	if d == nil || d.IsZero() {
+		return nil
+	}
+	t := d.Time
+	return &t
+}
+
+// docInt is a number stored as any BSON numeric type.
+type docInt int
+
+func (n *docInt) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bson.RawValue{Type: t, Value: data}
+	*n = 0
+	if i, ok := v.AsInt64OK(); ok {
+		*n = docInt(i)
+	}
+	return nil
+}
+
+// docIDs converts a reference array, keeping a missing array nil.
+func docIDs(ids []docID) []string {
+	if ids == nil {
+		return nil
+	}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, string(id))
+	}
+	return out
+}
+
+type userDocument struct {
+	ID       docID  `bson:"_id"`
+	Name     string `bson:"name"`
+	Username string `bson:"username"`
+	Password string `bson:"password"`
+	Role     string `bson:"role"`
+}
+
+// user converts the document, password hash included.
+func (d *userDocument) user() User {
+	return User{ID: string(d.ID), Name: d.Name, Username: d.Username, Password: d.Password, Role: UserRole(d.Role)}
+}
+
+// personDocument is a people document. Owners and Relationships are only present when a
+// pipeline looked them up.
+type personDocument struct {
+	ID            docID                  `bson:"_id"`
+	Version       docInt                 `bson:"version"`
+	Name          string                 `bson:"name"`
+	Nickname      string                 `bson:"nickname"`
+	Address       string                 `bson:"address"`
+	Status        string                 `bson:"status"`
+	Gender        string                 `bson:"gender"`
+	BirthDate     docTime                `bson:"birthDate"`
+	Phone         string                 `bson:"phone"`
+	PhotoURL      string                 `bson:"photoUrl"`
+	OwnedBy       []docID                `bson:"ownedBy"`
+	Deleted       bool                   `bson:"deleted"`
+	DeletedAt     *docTime               `bson:"deletedAt"`
+	Owners        []userDocument         `bson:"owners"`
+	Relationships []relationshipDocument `bson:"relationships"`
+}
+
+func (d *personDocument) person() *Person {
+	p := &Person{
+		ID:        string(d.ID),
+		Version:   int(d.Version),
+		Name:      d.Name,
+		Nickname:  d.Nickname,
+		Address:   d.Address,
+		Status:    d.Status,
+		Gender:    d.Gender,
+		BirthDate: d.BirthDate.Time,
+		Phone:     d.Phone,
+		PhotoURL:  d.PhotoURL,
+		OwnedBy:   docIDs(d.OwnedBy),
+		DeletedAt: d.DeletedAt.timePtr(),
+	}
+	if d.Owners != nil {
+		p.Owners = make([]User, 0, len(d.Owners))
+		for i := range d.Owners {
+			u := d.Owners[i].user()
+			u.Password = ""
+			p.Owners = append(p.Owners, u)
+		}
+	}
+	if d.Relationships != nil {
+		p.Relationships = make([]*Relationship, 0, len(d.Relationships))
+		for i := range d.Relationships {
+			p.Relationships = append(p.Relationships, d.Relationships[i].relationship())
+		}
+	}
+	return p
+}
+
+// relationshipDocument is a relationships document. FromDetails and ToDetails are the
+// $lookup arrays of the two ends, when a pipeline looked them up.
+type relationshipDocument struct {
+	ID          docID             `bson:"_id"`
+	Version     docInt            `bson:"version"`
+	From        docID             `bson:"from"`
+	To          docID             `bson:"to"`
+	Type        string            `bson:"type"`
+	Subtype     string            `bson:"subtype"`
+	Order       docInt            `bson:"order"`
+	Marriage    *marriageDocument `bson:"marriage"`
+	DeletedAt   *docTime          `bson:"deletedAt"`
+	FromDetails []personDocument  `bson:"fromDetails"`
+	ToDetails   []personDocument  `bson:"toDetails"`
+}
+
+func (d *relationshipDocument) relationship() *Relationship {
+	r := &Relationship{
+		ID:        string(d.ID),
+		Version:   int(d.Version),
+		From:      string(d.From),
+		To:        string(d.To),
+		Type:      d.Type,
+		Subtype:   normalizeRelationshipSubtype(d.Type, d.Subtype),
+		Order:     int(d.Order),
+		DeletedAt: d.DeletedAt.timePtr(),
+	}
+	if d.Marriage != nil {
+		r.Marriage = d.Marriage.marriage()
+	}
+	if len(d.FromDetails) > 0 {
+		r.FromDetails = d.FromDetails[0].person()
+	}
+	if len(d.ToDetails) > 0 {
+		r.ToDetails = d.ToDetails[0].person()
+	}
+	return r
+}
+
+type marriageDocument struct {
+	Status    string   `bson:"status"`
+	StartDate *docTime `bson:"startDate"`
+	EndDate   *docTime `bson:"endDate"`
+	Place     string   `bson:"place"`
+}
+
+func (d *marriageDocument) marriage() *Marriage {
+	return normalizeMarriage(&Marriage{Status: d.Status, StartDate: d.StartDate.timePtr(), EndDate: d.EndDate.timePtr(), Place: d.Place})
+}
+
+// familyDocument is a families document. Person is the legacy root reference, which
+// mirrors the first founder; PersonDetails is its lookup, when a pipeline did one.
+type familyDocument struct {
+	ID            docID            `bson:"_id"`
+	Version       docInt           `bson:"version"`
+	Name          string           `bson:"name"`
+	Description   string           `bson:"description"`
+	CoverURL      string           `bson:"coverUrl"`
+	Person        docID            `bson:"person"`
+	Founders      []coupleDocument `bson:"founders"`
+	Membership    string           `bson:"membership"`
+	Members       []docID          `bson:"members"`
+	OwnedBy       []docID          `bson:"ownedBy"`
+	DeletedAt     *docTime         `bson:"deletedAt"`
+	PersonDetails *personDocument  `bson:"personDetails"`
+}
+
+type coupleDocument struct {
+	Person docID `bson:"person"`
+	Spouse docID `bson:"spouse"`
+}
+
+// family converts the document. Families from before founding couples get their person
+// as the only founder and the descendants membership rule.
+func (d *familyDocument) family() *Family {
+	f := &Family{
+		ID:          string(d.ID),
+		Version:     int(d.Version),
+		Name:        d.Name,
+		Description: d.Description,
+		CoverURL:    d.CoverURL,
+		Founders:    []FamilyCouple{},
+		Membership:  d.Membership,
+		Members:     docIDs(d.Members),
+		OwnedBy:     docIDs(d.OwnedBy),
+		DeletedAt:   d.DeletedAt.timePtr(),
+	}
+	for _, c := range d.Founders {
+		f.Founders = append(f.Founders, FamilyCouple{Person: string(c.Person), Spouse: string(c.Spouse)})
+	}
+	if len(f.Founders) == 0 && d.Person != "" {
+		f.Founders = []FamilyCouple{{Person: string(d.Person)}}
+	}
+	if f.Membership == "" {
+		f.Membership = MembershipDescendants
+	}
+	if d.PersonDetails != nil {
+		f.Person = d.PersonDetails.person()
+	}
+	return f
+}
+
+// decodeM decodes a bson.M, as aggregateDocs returns it, into a document type. The list
+// repos keep raw documents because paging needs their sort values.
+func decodeM[T any](doc bson.M) *T {
+	out := new(T)
+	raw, err := bson.Marshal(doc)
+	if err == nil {
+		_ = bson.Unmarshal(raw, out)
+	}
+	return out
+}
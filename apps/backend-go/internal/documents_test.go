@@ -0,0 +1,309 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	testOID  = primitive.NewObjectIDFromTimestamp(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC))
+	testOID2 = primitive.NewObjectIDFromTimestamp(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	testDate = time.Date(1980, 5, 17, 8, 30, 0, 0, time.UTC)
+)
+
+// decodeValue decodes v the way a field of a stored document is decoded.
+func decodeValue[T any](t *testing.T, v interface{}) T {
+	t.Helper()
+	doc := bson.M{}
+	if v != nil {
+		doc["v"] = v
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		V T `bson:"v"`
+	}
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		t.Fatalf("decoding %#v: %v", v, err)
+	}
+	return out.V
+}
+
+func TestDocID(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  docID
+	}{
+		{"ObjectID", testOID, docID(testOID.Hex())},
+		{"string", testOID.Hex(), docID(testOID.Hex())},
+		{"number", int32(7), ""},
+		{"null", primitive.Null{}, ""},
+		{"missing", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeValue[docID](t, tt.value); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  time.Time
+	}{
+		{"DateTime", primitive.NewDateTimeFromTime(testDate), testDate},
+		{"RFC 3339 string", "1980-05-17T08:30:00Z", testDate},
+		{"RFC 3339 string with offset", "1980-05-17T15:30:00+07:00", testDate},
+		{"plain date string", "1980-05-17", time.Time{}},
+		{"number", int64(327400200000), time.Time{}},
+		{"missing", nil, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeValue[docTime](t, tt.value); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got.Time, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocTimePtr(t *testing.T) {
+	if got := decodeValue[*docTime](t, nil).timePtr(); got != nil {
+		t.Errorf("missing date: got %v, want nil", got)
+	}
+	if got := decodeValue[*docTime](t, "not a date").timePtr(); got != nil {
+		t.Errorf("unreadable date: got %v, want nil", got)
+	}
+	if got := decodeValue[*docTime](t, primitive.NewDateTimeFromTime(testDate)).timePtr(); got == nil || !got.Equal(testDate) {
+		t.Errorf("got %v, want %v", got, testDate)
+	}
+}
+
+func TestDocInt(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  docInt
+	}{
+		{"int32", int32(3), 3},
+		{"int64", int64(3), 3},
+		{"double", 3.0, 3},
+		{"negative double", -2.0, -2},
+		{"string", "3", 0},
+		{"missing", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeValue[docInt](t, tt.value); got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// utc puts the times of a decoded model in UTC, so models compare with reflect.DeepEqual
+// whatever the local time zone.
+func utc(t *time.Time) {
+	if t != nil {
+		*t = t.UTC()
+	}
+}
+
+func TestPersonDocument(t *testing.T) {
+	deletedAt := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		doc  bson.M
+		want *Person
+	}{
+		{
+			name: "current types",
+			doc: bson.M{
+				"_id": testOID, "version": int32(4), "name": "Budi", "nickname": "Bud", "address": "Jakarta",
+				"status": "alive", "gender": "male", "birthDate": primitive.NewDateTimeFromTime(testDate),
+				"phone": "0812", "ownedBy": bson.A{testOID2}, "deleted": true,
+				"deletedAt": primitive.NewDateTimeFromTime(deletedAt),
+			},
+			want: &Person{
+				ID: testOID.Hex(), Version: 4, Name: "Budi", Nickname: "Bud", Address: "Jakarta", Status: "alive",
+				Gender: "male", BirthDate: testDate, Phone: "0812", OwnedBy: []string{testOID2.Hex()}, DeletedAt: &deletedAt,
+			},
+		},
+		{
+			name: "Node types",
+			doc: bson.M{
+				"_id": testOID.Hex(), "version": 4.0, "name": "Budi", "birthDate": "1980-05-17T08:30:00Z",
+				"ownedBy": bson.A{testOID2.Hex(), testOID}, "deletedAt": "2024-02-01T00:00:00Z",
+			},
+			want: &Person{
+				ID: testOID.Hex(), Version: 4, Name: "Budi", BirthDate: testDate,
+				OwnedBy: []string{testOID2.Hex(), testOID.Hex()}, DeletedAt: &deletedAt,
+			},
+		},
+		{
+			name: "unreadable and missing fields",
+			doc:  bson.M{"_id": testOID, "version": "4", "birthDate": "17/05/1980", "deletedAt": nil},
+			want: &Person{ID: testOID.Hex()},
+		},
+		{
+			name: "looked-up owners and relationships",
+			doc: bson.M{
+				"_id":           testOID,
+				"owners":        bson.A{bson.M{"_id": testOID2, "name": "Admin", "username": "admin", "password": "hash", "role": "admin"}},
+				"relationships": bson.A{bson.M{"_id": testOID2, "from": testOID, "to": testOID2.Hex(), "type": "spouse"}},
+			},
+			want: &Person{
+				ID:            testOID.Hex(),
+				Owners:        []User{{ID: testOID2.Hex(), Name: "Admin", Username: "admin", Role: RoleAdmin}},
+				Relationships: []*Relationship{{ID: testOID2.Hex(), From: testOID.Hex(), To: testOID2.Hex(), Type: "spouse"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeM[personDocument](tt.doc).person()
+			utc(&got.BirthDate)
+			utc(got.DeletedAt)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelationshipDocument(t *testing.T) {
+	start := time.Date(2005, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		doc  bson.M
+		want *Relationship
+	}{
+		{
+			name: "current types",
+			doc: bson.M{
+				"_id": testOID, "version": int64(2), "from": testOID, "to": testOID2, "type": "spouse", "order": int32(1),
+				"marriage": bson.M{"status": MarriageMarried, "startDate": primitive.NewDateTimeFromTime(start), "place": "Bandung"},
+			},
+			want: &Relationship{
+				ID: testOID.Hex(), Version: 2, From: testOID.Hex(), To: testOID2.Hex(), Type: "spouse", Order: 1,
+				Marriage: &Marriage{Status: MarriageMarried, StartDate: &start, Place: "Bandung"},
+			},
+		},
+		{
+			name: "Node types",
+			doc: bson.M{
+				"_id": testOID.Hex(), "version": 2.0, "from": testOID.Hex(), "to": testOID2.Hex(), "type": "spouse", "order": int64(1),
+				"marriage": bson.M{"status": MarriageMarried, "startDate": "2005-06-01T00:00:00Z", "place": "Bandung"},
+			},
+			want: &Relationship{
+				ID: testOID.Hex(), Version: 2, From: testOID.Hex(), To: testOID2.Hex(), Type: "spouse", Order: 1,
+				Marriage: &Marriage{Status: MarriageMarried, StartDate: &start, Place: "Bandung"},
+			},
+		},
+		{
+			name: "order as a double",
+			doc:  bson.M{"_id": testOID, "from": testOID, "to": testOID2, "type": "parent", "subtype": SubtypeAdoptive, "order": 2.0},
+			want: &Relationship{ID: testOID.Hex(), From: testOID.Hex(), To: testOID2.Hex(), Type: "parent", Subtype: SubtypeAdoptive, Order: 2},
+		},
+		{
+			name: "legacy parent edge without subtype",
+			doc:  bson.M{"_id": testOID, "from": testOID, "to": testOID2, "type": "parent"},
+			want: &Relationship{ID: testOID.Hex(), From: testOID.Hex(), To: testOID2.Hex(), Type: "parent", Subtype: SubtypeBiological},
+		},
+		{
+			name: "looked-up ends",
+			doc: bson.M{
+				"_id": testOID, "from": testOID, "to": testOID2, "type": "spouse",
+				"fromDetails": bson.A{bson.M{"_id": testOID, "name": "Budi"}},
+				"toDetails":   bson.A{},
+			},
+			want: &Relationship{ID: testOID.Hex(), From: testOID.Hex(), To: testOID2.Hex(), Type: "spouse", FromDetails: &Person{ID: testOID.Hex(), Name: "Budi"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeM[relationshipDocument](tt.doc).relationship()
+			if got.Marriage != nil {
+				utc(got.Marriage.StartDate)
+				utc(got.Marriage.EndDate)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFamilyDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  bson.M
+		want *Family
+	}{
+		{
+			name: "founding couples",
+			doc: bson.M{
+				"_id": testOID, "version": int32(3), "name": "Keluarga Budi", "person": testOID,
+				"founders":   bson.A{bson.M{"person": testOID, "spouse": testOID2}},
+				"membership": MembershipExplicit, "members": bson.A{testOID, testOID2.Hex()}, "ownedBy": bson.A{testOID2},
+			},
+			want: &Family{
+				ID: testOID.Hex(), Version: 3, Name: "Keluarga Budi",
+				Founders:   []FamilyCouple{{Person: testOID.Hex(), Spouse: testOID2.Hex()}},
+				Membership: MembershipExplicit, Members: []string{testOID.Hex(), testOID2.Hex()}, OwnedBy: []string{testOID2.Hex()},
+			},
+		},
+		{
+			name: "founders stored as strings",
+			doc: bson.M{
+				"_id": testOID.Hex(), "version": 3.0, "name": "Keluarga Budi",
+				"founders": bson.A{bson.M{"person": testOID.Hex()}, bson.M{"person": testOID2.Hex()}},
+			},
+			want: &Family{
+				ID: testOID.Hex(), Version: 3, Name: "Keluarga Budi",
+				Founders:   []FamilyCouple{{Person: testOID.Hex()}, {Person: testOID2.Hex()}},
+				Membership: MembershipDescendants,
+			},
+		},
+		{
+			name: "legacy root person",
+			doc:  bson.M{"_id": testOID, "version": int64(1), "name": "Keluarga Budi", "person": testOID2.Hex()},
+			want: &Family{
+				ID: testOID.Hex(), Version: 1, Name: "Keluarga Budi",
+				Founders: []FamilyCouple{{Person: testOID2.Hex()}}, Membership: MembershipDescendants,
+			},
+		},
+		{
+			name: "no founders",
+			doc:  bson.M{"_id": testOID, "name": "Keluarga Budi"},
+			want: &Family{ID: testOID.Hex(), Name: "Keluarga Budi", Founders: []FamilyCouple{}, Membership: MembershipDescendants},
+		},
+		{
+			name: "looked-up person",
+			doc:  bson.M{"_id": testOID, "person": testOID2, "personDetails": bson.M{"_id": testOID2, "name": "Budi"}},
+			want: &Family{
+				ID: testOID.Hex(), Person: &Person{ID: testOID2.Hex(), Name: "Budi"},
+				Founders: []FamilyCouple{{Person: testOID2.Hex()}}, Membership: MembershipDescendants,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeM[familyDocument](tt.doc).family()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// canViewFamily reports whether user may see or edit f: admins always, users only
+// families they own.
+func canViewFamily(user *User, f *Family) bool {
+	return user.Role == RoleAdmin || slices.Contains(f.OwnedBy, user.ID)
+}
+
+// foundedBy reports whether id is one of f's founders or founding spouses.
+func (f *Family) foundedBy(id string) bool {
+	return slices.ContainsFunc(f.Founders, func(c FamilyCouple) bool { return c.Person == id || c.Spouse == id })
+}
+
+// founderIDs lists the founders and founding spouses of f.
+func (f *Family) founderIDs() []string {
+	ids := []string{}
+	for _, c := range f.Founders {
+		ids = append(ids, c.Person)
+		if c.Spouse != "" {
+			ids = append(ids, c.Spouse)
+		}
+	}
+	return ids
+}
+
+// checkFamilyPeople reports every founder, spouse and member of f that doesn't exist.
+func checkFamilyPeople(ctx context.Context, f *Family) []FieldError {
+	v := &validator{}
+	exists := func(field, id string) {
+		if _, err := storesFrom(ctx).People.Get(ctx, id); err != nil {
+			v.add(field, "not found")
+		}
+	}
+	for i, c := range f.Founders {
+		exists(fmt.Sprintf("founders.%d.person", i), c.Person)
+		if c.Spouse != "" {
+			exists(fmt.Sprintf("founders.%d.spouse", i), c.Spouse)
+		}
+	}
+	for i, m := range f.Members {
+		exists(fmt.Sprintf("members.%d", i), m)
+	}
+	return v.errs
+}
+
+// familyLinks returns the children and spouses of a person.
+func familyLinks(ctx context.Context, src treeSource, id string) (children, spouses []string, err error) {
+	rels, err := src.relationships(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, rel := range rels {
+		switch {
+		case rel.Type == "parent" && rel.From == id:
+			children = append(children, rel.To)
+		case rel.Type == "spouse" && rel.From == id:
+			spouses = append(spouses, rel.To)
+		case rel.Type == "spouse" && rel.To == id:
+			spouses = append(spouses, rel.From)
+		}
+	}
+	return children, spouses, nil
+}
+
+// familyMemberIDs resolves who belongs to f. An explicit family is its member list. A
+// descendants family is its founders, their descendants and the spouses of all of them;
+// a founder with a named spouse brings only that spouse and the children of that couple.
+func familyMemberIDs(ctx context.Context, src treeSource, f *Family) ([]string, error) {
+	defer startSpan(ctx, "service/familyMemberIDs").End()
+	if f.Membership == MembershipExplicit {
+		return f.Members, nil
+	}
+	seen := map[string]struct{}{}
+	members := []string{}
+	add := func(id string) bool {
+		if _, ok := seen[id]; ok {
+			return false
+		}
+		seen[id] = struct{}{}
+		members = append(members, id)
+		return true
+	}
+	queue := []string{}
+	for _, c := range f.Founders {
+		add(c.Person)
+		children, spouses, err := familyLinks(ctx, src, c.Person)
+		if err != nil {
+			return nil, err
+		}
+		if c.Spouse == "" {
+			for _, s := range spouses {
+				add(s)
+			}
+		} else {
+			add(c.Spouse)
+			spouseChildren, _, err := familyLinks(ctx, src, c.Spouse)
+			if err != nil {
+				return nil, err
+			}
+			children = slices.DeleteFunc(children, func(id string) bool { return !slices.Contains(spouseChildren, id) })
+		}
+		for _, ch := range children {
+			if add(ch) {
+				queue = append(queue, ch)
+			}
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		children, spouses, err := familyLinks(ctx, src, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range spouses {
+			add(s)
+		}
+		for _, ch := range children {
+			if add(ch) {
+				queue = append(queue, ch)
+			}
+		}
+	}
+	return members, nil
+}
+
+// familyMembers loads the people who belong to f, skipping any that no longer exist.
+func familyMembers(ctx context.Context, src treeSource, f *Family) ([]*Person, error) {
+	ids, err := familyMemberIDs(ctx, src, f)
+	if err != nil {
+		return nil, err
+	}
+	people := []*Person{}
+	for _, id := range ids {
+		p, err := src.person(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		people = append(people, personSummary(p))
+	}
+	return people, nil
+}
+
+// buildFamilyForest renders the descendant tree of every founding couple of f, in couple
+// tree form, one after another. A founder with a named spouse shows only that couple. An
+// explicit family drops descendants (and their subtrees) outside its member list. Founders
+// that no longer exist are skipped.
+func buildFamilyForest(ctx context.Context, src treeSource, f *Family) ([]FamilyTreeNode, error) {
+	defer startSpan(ctx, "service/buildFamilyForest").End()
+	var allowed map[string]struct{}
+	if f.Membership == MembershipExplicit {
+		allowed = map[string]struct{}{}
+		for _, id := range f.Members {
+			allowed[id] = struct{}{}
+		}
+	}
+	forest := []FamilyTreeNode{}
+	for _, c := range f.Founders {
+		inode, err := buildFamilyTree(ctx, src, c.Person, true, false)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c.Spouse != "" {
+			inode.Children = []internalNode{}
+			inode.Spouses = slices.DeleteFunc(inode.Spouses, func(sp internalNode) bool { return sp.ID != c.Spouse })
+		}
+		if allowed != nil {
+			pruneFamilyTree(&inode, allowed)
+		}
+		forest = append(forest, transformToCoupleTree(transformToD3Tree(inode))...)
+	}
+	return forest, nil
+}
+
+// pruneFamilyTree removes descendants outside allowed from n. Spouses stay, since a couple
+// is drawn as one node, but their children are pruned too.
+func pruneFamilyTree(n *internalNode, allowed map[string]struct{}) {
+	keep := func(children []internalNode) []internalNode {
+		out := []internalNode{}
+		for _, ch := range children {
+			if _, ok := allowed[ch.ID]; ok {
+				pruneFamilyTree(&ch, allowed)
+				out = append(out, ch)
+			}
+		}
+		return out
+	}
+	n.Children = keep(n.Children)
+	for i := range n.Spouses {
+		n.Spouses[i].Children = keep(n.Spouses[i].Children)
+	}
+}
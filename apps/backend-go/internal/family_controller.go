@@ -1,50 +1,247 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 func getFamilies(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/getFamilies").End()
+	defer startSpan(c, "handler/getFamilies").End()
 	u, _ := c.Get("user")
 	user := u.(*User)
+	if isListRequest(c, familyListFilters...) {
+		listFamilies(c, user)
+		return
+	}
 	if user.Role == RoleUser {
-		families, _ := getAllFamiliesRepo(c, []string{user.ID})
+		families, _ := storesFrom(c).Families.List(c, []string{user.ID})
 		responseSuccess(c, families, 200)
 		return
 	}
-	families, _ := getAllFamiliesRepo(c, nil)
+	families, _ := storesFrom(c).Families.List(c, nil)
 	responseSuccess(c, families, 200)
 }
 
-func createFamily(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/createFamily").End()
-	var body struct {
-		Name   string `json:"name"`
-		Person string `json:"person"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		responseError(c, "Invalid request body", 400)
+var familyListSpec = listSpec{
+	sorts:    []string{"name", "_id"},
+	includes: []string{"person"},
+	fields:   []string{"name", "description", "coverUrl", "person", "founders", "membership", "members", "ownedBy", "version"},
+}
+
+var familyListFilters = []string{"owner", "person"}
+
+// listFamilies is the paged form of getFamilies, used once any list parameter is present.
+func listFamilies(c *gin.Context, user *User) {
+	v := &validator{}
+	q := parseListQuery(c, familyListSpec, v)
+	q.filterID(c, v, "owner", "ownedBy")
+	q.filterID(c, v, "person", "person")
+	if !v.valid() {
+		responseInvalidQuery(c, v.errs)
 		return
 	}
-	// ensure person exists
-	person, err := getPersonByIdRepo(c, body.Person)
+	var scope []string
+	if user.Role == RoleUser {
+		scope = []string{user.ID}
+	}
+	families, next, err := storesFrom(c).Families.Page(c, scope, q)
 	if err != nil {
-		responseError(c, "Person not found", 400)
+		responseError(c, "Failed to fetch families", 500)
+		return
+	}
+	respondPage(c, q, families, next)
+}
+
+// getFamilyById returns a family the caller can see, with its version as the ETag.
+func getFamilyById(c *gin.Context) {
+	defer startSpan(c, "handler/getFamilyById").End()
+	f, ok := loadVisibleFamily(c)
+	if !ok {
+		return
+	}
+	c.Header("ETag", etag(f.Version))
+	responseSuccess(c, f, 200)
+}
+
+// loadVisibleFamily loads the family in the :id route parameter, responding 404 when it
+// doesn't exist or belongs to someone else.
+func loadVisibleFamily(c *gin.Context) (*Family, bool) {
+	u, _ := c.Get("user")
+	user := u.(*User)
+	f, err := storesFrom(c).Families.Get(c, c.Param("id"))
+	if err != nil || !canViewFamily(user, f) {
+		responseError(c, "Family not found", 404)
+		return nil, false
+	}
+	return f, true
+}
+
+func createFamily(c *gin.Context) {
+	defer startSpan(c, "handler/createFamily").End()
+	var body familyRequest
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
+	}
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
 		return
 	}
 	u, _ := c.Get("user")
 	user := u.(*User)
-	f := &Family{Name: body.Name, Person: person, OwnedBy: []string{user.ID}}
-	newF, _ := createFamilyRepo(c, f)
+	f := &Family{OwnedBy: []string{user.ID}}
+	body.apply(f)
+	if errs := checkFamilyPeople(c, f); errs != nil {
+		responseInvalid(c, legacyFamilyFields(&body, errs))
+		return
+	}
+	newF, err := storesFrom(c).Families.Create(c, f)
+	if err != nil {
+		responseError(c, "Failed to create family", 500)
+		return
+	}
 	responseSuccess(c, newF, 201)
 }
 
+// updateFamily replaces a family's name, description, founders and membership. The cover
+// image is kept; it has its own endpoints.
+func updateFamily(c *gin.Context) {
+	defer startSpan(c, "handler/updateFamily").End()
+	var body familyRequest
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
+	}
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
+		return
+	}
+	f, ok := loadVisibleFamily(c)
+	if !ok || !checkIfMatch(c, f.Version) {
+		return
+	}
+	body.apply(f)
+	if errs := checkFamilyPeople(c, f); errs != nil {
+		responseInvalid(c, legacyFamilyFields(&body, errs))
+		return
+	}
+	saveFamily(c, f)
+}
+
+// legacyFamilyFields reports errors about a lone founder under "person" when the request
+// used that shorthand.
+func legacyFamilyFields(body *familyRequest, errs []FieldError) []FieldError {
+	if len(body.Founders) == 0 {
+		for i := range errs {
+			if errs[i].Field == "founders.0.person" {
+				errs[i].Field = "person"
+			}
+		}
+	}
+	return errs
+}
+
+// saveFamily writes f and responds with the stored family, or 409 on a lost race.
+func saveFamily(c *gin.Context, f *Family) {
+	updated, err := storesFrom(c).Families.Update(c, f)
+	if err != nil {
+		if errors.Is(err, errVersionConflict) {
+			responseError(c, "Family was changed by someone else; reload it and retry", 409)
+			return
+		}
+		responseError(c, "Failed to update family", 500)
+		return
+	}
+	c.Header("ETag", etag(updated.Version))
+	responseSuccess(c, updated, 200)
+}
+
+// uploadFamilyCover sets the family's cover image from the multipart "cover" file.
+func uploadFamilyCover(c *gin.Context) {
+	defer startSpan(c, "handler/uploadFamilyCover").End()
+	f, ok := loadVisibleFamily(c)
+	if !ok || !checkIfMatch(c, f.Version) {
+		return
+	}
+	if file, err := c.FormFile("cover"); err != nil || file == nil {
+		responseInvalid(c, []FieldError{{Field: "cover", Message: "is required"}})
+		return
+	}
+	url, err := uploadImage(c, "cover")
+	if err != nil {
+		logger(logUpload).ErrorContext(c, "cover upload failed", "handler", "uploadFamilyCover", "error", err)
+		responseError(c, fmt.Sprintf("Failed to upload cover: %v", err), 500)
+		return
+	}
+	f.CoverURL = url
+	saveFamily(c, f)
+}
+
+// deleteFamilyCover removes the family's cover image.
+func deleteFamilyCover(c *gin.Context) {
+	defer startSpan(c, "handler/deleteFamilyCover").End()
+	defer securityLog(c, "family.cover.delete", c.Param("id"), "")
+	f, ok := loadVisibleFamily(c)
+	if !ok || !checkIfMatch(c, f.Version) {
+		return
+	}
+	f.CoverURL = ""
+	saveFamily(c, f)
+}
+
+// getFamilyMembers lists the people who belong to the family under its membership rule.
+func getFamilyMembers(c *gin.Context) {
+	defer startSpan(c, "handler/getFamilyMembers").End()
+	f, ok := loadVisibleFamily(c)
+	if !ok {
+		return
+	}
+	src, ok := treeSourceFor(c)
+	if !ok {
+		return
+	}
+	members, err := familyMembers(c, src, f)
+	if err != nil {
+		responseError(c, "Failed to resolve family members", 500)
+		return
+	}
+	responseSuccess(c, members, 200)
+}
+
+// getFamilyTreeById renders the family from its founding couples down, in the same couple
+// tree shape as /api/tree/:personId?mode=parent. It accepts the same asOf parameter.
+func getFamilyTreeById(c *gin.Context) {
+	defer startSpan(c, "handler/getFamilyTreeById").End()
+	f, ok := loadVisibleFamily(c)
+	if !ok {
+		return
+	}
+	src, ok := treeSourceFor(c)
+	if !ok {
+		return
+	}
+	forest, err := buildFamilyForest(c, src, f)
+	if err != nil {
+		responseError(c, "Failed to build family tree", 500)
+		return
+	}
+	responseSuccess(c, forest, 200)
+}
+
 func deleteFamily(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/deleteFamily").End()
-	id := c.Param("id")
-	f, err := deleteFamilyRepo(c, id)
+	defer startSpan(c, "handler/deleteFamily").End()
+	defer securityLog(c, "family.delete", c.Param("id"), "")
+	f, ok := loadVisibleFamily(c)
+	if !ok {
+		return
+	}
+	f, err := storesFrom(c).Families.Delete(c, f.ID)
 	if err != nil {
 		responseError(c, "Family not found", 404)
 		return
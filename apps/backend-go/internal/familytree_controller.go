@@ -3,11 +3,12 @@ package app
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 type FamilyTreeNode struct {
@@ -44,21 +45,39 @@ type internalNode struct {
 	Parents    []internalNode         `json:"parents,omitempty"`
 }
 
+// treeSource loads the people and relationships a tree is built from.
+type treeSource struct {
+	person        func(ctx context.Context, id string) (*Person, error)
+	relationships func(ctx context.Context, id string) ([]*Relationship, error)
+}
+
+// liveTreeSource reads the current graph from the request's stores.
+var liveTreeSource = treeSource{
+	person: func(ctx context.Context, id string) (*Person, error) {
+		return storesFrom(ctx).People.Get(ctx, id)
+	},
+	relationships: func(ctx context.Context, id string) ([]*Relationship, error) {
+		return storesFrom(ctx).Relationships.ListByPerson(ctx, id)
+	},
+}
+
 func getFamilyTree(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/getFamilyTree").End()
+	defer startSpan(c, "handler/getFamilyTree").End()
 	personId := c.Param("personId")
 	mode := c.Query("mode")
 	// Default to "parent" mode if not specified (matching Node.js)
 	if mode == "" {
 		mode = "parent"
 	}
-	// unconditional handler-level log to confirm invocation
-	fmt.Printf("[TREE] handler invoked person=%s mode=%s\n", personId, mode)
-	ctx := c.Request.Context()
+	logger(logTree).DebugContext(c, "building tree", "person_id", personId, "mode", mode)
 	// Match Node.js logic: mode=="parent" shows children, mode=="child" shows parents
 	withChildren := mode == "parent"
 	withParent := mode == "child"
-	inode, err := buildFamilyTree(ctx, personId, withChildren, withParent)
+	src, ok := treeSourceFor(c)
+	if !ok {
+		return
+	}
+	inode, err := buildFamilyTree(c, src, personId, withChildren, withParent)
 	if err != nil {
 		responseError(c, "Failed to build family tree", 500)
 		return
@@ -78,24 +97,57 @@ func getFamilyTree(c *gin.Context) {
 	responseSuccess(c, result, 200)
 }
 
-func buildFamilyTree(ctx context.Context, personId string, withChildren bool, withParent bool) (internalNode, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "service/buildFamilyTree").End()
+// treeSourceFor picks the graph a tree request renders: the live one, or with ?asOf= the
+// graph as it was at a past date, from the revision history. It responds with an error
+// and returns false when it can't.
+func treeSourceFor(c *gin.Context) (treeSource, bool) {
+	asOf := c.Query("asOf")
+	if asOf == "" {
+		return liveTreeSource, true
+	}
+	at, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		d, err2 := time.Parse("2006-01-02", asOf)
+		if err2 != nil {
+			responseError(c, "Invalid asOf date", 400)
+			return treeSource{}, false
+		}
+		// a bare date means the end of that day
+		at = d.Add(24*time.Hour - time.Nanosecond)
+	}
+	src, err := snapshotTreeSource(c, at)
+	if err != nil {
+		responseError(c, "Failed to load tree history", 500)
+		return treeSource{}, false
+	}
+	return src, true
+}
+
+func buildFamilyTree(ctx context.Context, src treeSource, personId string, withChildren bool, withParent bool) (tree internalNode, err error) {
+	defer startSpan(ctx, "service/buildFamilyTree").End()
+	start := time.Now()
+	defer func() {
+		if err == nil {
+			observeTree(tree, time.Since(start))
+		}
+	}()
 
+	log := logger(logTree)
+	debug := log.Enabled(ctx, slog.LevelDebug)
 	// helper to recursively build node
 	var build func(id string, wc bool, wp bool) (internalNode, error)
 	build = func(id string, wc bool, wp bool) (internalNode, error) {
-		p, err := getPersonByIdRepo(ctx, id)
+		p, err := src.person(ctx, id)
 		if err != nil {
 			return internalNode{}, err
 		}
-		debug := os.Getenv("TREE_DEBUG") == "1"
 		// fetch relationships for this node (important: per-node relationships)
-		rels, err := getRelationshipsByPersonIdRepo(ctx, id)
+		rels, err := src.relationships(ctx, id)
 		if err != nil {
 			rels = []*Relationship{}
 		}
 		if debug {
-			fmt.Printf("[TREE] node=%s rels=%d wc=%v wp=%v\n", id, len(rels), wc, wp)
+			log.DebugContext(ctx, "tree node", "node", id, "relationships", len(rels), "with_children", wc, "with_parents", wp)
 		}
 		node := internalNode{
 			ID:     p.ID,
@@ -110,51 +162,68 @@ func buildFamilyTree(ctx context.Context, personId string, withChildren bool, wi
 		}
 
 		if wc {
-			// find spouse ids
+			// find spouse edges, one per spouse, in chronological marriage order
+			spouseOf := func(rel *Relationship) string {
+				if rel.From == id {
+					return rel.To
+				}
+				return rel.From
+			}
 			spouseSet := map[string]struct{}{}
+			spouseEdges := []*Relationship{}
 			for _, rel := range rels {
-				if rel.Type == "spouse" {
-					if rel.From == id {
-						spouseSet[rel.To] = struct{}{}
-					} else if rel.To == id {
-						spouseSet[rel.From] = struct{}{}
+				if rel.Type == "spouse" && (rel.From == id || rel.To == id) {
+					if _, ok := spouseSet[spouseOf(rel)]; !ok {
+						spouseSet[spouseOf(rel)] = struct{}{}
+						spouseEdges = append(spouseEdges, rel)
 					}
 				}
 			}
+			sortSpouseEdges(spouseEdges, spouseOf)
 
 			// get my children ids
 			myChildrenSet := map[string]struct{}{}
-			childOrderMap := map[string]int{} // Track order for each child
+			childOrderMap := map[string]int{}      // Track order for each child
+			childSubtypeMap := map[string]string{} // Track how each child is linked to me
 			for _, rel := range rels {
 				if rel.Type == "parent" && rel.From == id {
 					myChildrenSet[rel.To] = struct{}{}
 					childOrderMap[rel.To] = rel.Order
+					childSubtypeMap[rel.To] = normalizeRelationshipSubtype(rel.Type, rel.Subtype)
 				}
 			}
 
 			// For each spouse, find shared children and build spouse nodes
 			sharedChildrenSet := map[string]struct{}{}
 			spouseNodes := []internalNode{}
-			for spouseId := range spouseSet {
-				spouseRels, _ := getRelationshipsByPersonIdRepo(ctx, spouseId)
-				spouseChildrenSet := map[string]struct{}{}
+			for _, spouseEdge := range spouseEdges {
+				spouseId := spouseOf(spouseEdge)
+				spouseRels, _ := src.relationships(ctx, spouseId)
+				spouseChildrenSet := map[string]string{} // child id -> spouse's subtype
 				for _, srel := range spouseRels {
 					if srel.Type == "parent" && srel.From == spouseId {
-						spouseChildrenSet[srel.To] = struct{}{}
+						spouseChildrenSet[srel.To] = normalizeRelationshipSubtype(srel.Type, srel.Subtype)
 					}
 				}
 
 				// Create a list of shared children with their orders
 				type childWithOrder struct {
-					id    string
-					order int
+					id      string
+					order   int
+					subtype string
 				}
 				sharedChildrenList := []childWithOrder{}
 				for cid := range myChildrenSet {
-					if _, ok := spouseChildrenSet[cid]; ok {
+					if spouseSubtype, ok := spouseChildrenSet[cid]; ok {
+						// a couple's child is drawn as non-biological when either link is
+						subtype := childSubtypeMap[cid]
+						if subtype == SubtypeBiological {
+							subtype = spouseSubtype
+						}
 						sharedChildrenList = append(sharedChildrenList, childWithOrder{
-							id:    cid,
-							order: childOrderMap[cid],
+							id:      cid,
+							order:   childOrderMap[cid],
+							subtype: subtype,
 						})
 						sharedChildrenSet[cid] = struct{}{}
 					}
@@ -169,38 +238,42 @@ func buildFamilyTree(ctx context.Context, personId string, withChildren bool, wi
 				for _, child := range sharedChildrenList {
 					childNode, err := build(child.id, true, false)
 					if err == nil {
+						childNode.Attributes["qualifier"] = child.subtype
 						if debug {
-							fmt.Printf("[TREE]  node=%s spouse=%s child=%s order=%d found\n", id, spouseId, child.id, child.order)
+							log.DebugContext(ctx, "couple child found", "node", id, "spouse", spouseId, "child", child.id, "order", child.order)
 						}
 						children = append(children, childNode)
 					}
 				}
 
-				sp, err := getPersonByIdRepo(ctx, spouseId)
+				sp, err := src.person(ctx, spouseId)
 				if err == nil {
 					if debug {
-						fmt.Printf("[TREE]  spouse node=%s has %d children\n", spouseId, len(children))
+						log.DebugContext(ctx, "spouse children", "spouse", spouseId, "children", len(children))
 					}
 					spouseNodes = append(spouseNodes, internalNode{
-						ID:       sp.ID,
-						Name:     sp.Nickname,
-						Gender:   sp.Gender,
-						Children: children,
+						ID:         sp.ID,
+						Name:       sp.Nickname,
+						Gender:     sp.Gender,
+						Attributes: marriageAttributes(spouseEdge.Marriage),
+						Children:   children,
 					})
 				}
 			}
 
 			// children not associated with any spouse
 			type childWithOrder struct {
-				id    string
-				order int
+				id      string
+				order   int
+				subtype string
 			}
 			singleChildrenList := []childWithOrder{}
 			for cid := range myChildrenSet {
 				if _, ok := sharedChildrenSet[cid]; !ok {
 					singleChildrenList = append(singleChildrenList, childWithOrder{
-						id:    cid,
-						order: childOrderMap[cid],
+						id:      cid,
+						order:   childOrderMap[cid],
+						subtype: childSubtypeMap[cid],
 					})
 				}
 			}
@@ -214,8 +287,9 @@ func buildFamilyTree(ctx context.Context, personId string, withChildren bool, wi
 			for _, child := range singleChildrenList {
 				childNode, err := build(child.id, true, false)
 				if err == nil {
+					childNode.Attributes["qualifier"] = child.subtype
 					if debug {
-						fmt.Printf("[TREE]  node=%s singleChild=%s order=%d found\n", id, child.id, child.order)
+						log.DebugContext(ctx, "single parent child found", "node", id, "child", child.id, "order", child.order)
 					}
 					singleChildren = append(singleChildren, childNode)
 				}
@@ -232,19 +306,20 @@ func buildFamilyTree(ctx context.Context, personId string, withChildren bool, wi
 
 		if wp {
 			// find parents (relationships where type==parent and rel.to == id)
-			parentIdsSet := map[string]struct{}{}
+			parentIdsSet := map[string]string{} // parent id -> subtype
 			// find parents using relationships for this node
 			for _, rel := range rels {
 				if rel.Type == "parent" && rel.To == id {
-					parentIdsSet[rel.From] = struct{}{}
+					parentIdsSet[rel.From] = normalizeRelationshipSubtype(rel.Type, rel.Subtype)
 				}
 			}
 			parents := []internalNode{}
-			for pid := range parentIdsSet {
+			for pid, subtype := range parentIdsSet {
 				parentNode, err := build(pid, false, true)
 				if err == nil {
+					parentNode.Attributes["qualifier"] = subtype
 					if debug {
-						fmt.Printf("[TREE]  node=%s parent=%s found\n", id, pid)
+						log.DebugContext(ctx, "parent found", "node", id, "parent", pid)
 					}
 					parents = append(parents, parentNode)
 				}
@@ -263,7 +338,7 @@ func buildFamilyTree(ctx context.Context, personId string, withChildren bool, wi
 		return build(personId, false, true)
 	}
 	// default: return basic internal node (no flags set)
-	person, err := getPersonByIdRepo(ctx, personId)
+	person, err := src.person(ctx, personId)
 	if err != nil {
 		return internalNode{}, err
 	}
@@ -292,6 +367,13 @@ func transformToD3Tree(person internalNode) FamilyTreeNode {
 
 	// spouses -> push nodes; when spouse has children, gender set to "male" in original code when children exist
 	for _, sp := range person.Spouses {
+		// spouse attributes carry the marriage metadata alongside relation and gender
+		spouseAttrs := map[string]interface{}{}
+		for k, v := range sp.Attributes {
+			spouseAttrs[k] = v
+		}
+		spouseAttrs["relation"] = "spouse"
+		spouseAttrs["gender"] = sp.Gender
 		if len(sp.Children) > 0 {
 			// spouse node with children
 			var children []FamilyTreeNode
@@ -299,25 +381,19 @@ func transformToD3Tree(person internalNode) FamilyTreeNode {
 				children = append(children, transformToD3Tree(c))
 			}
 			node.Children = append(node.Children, FamilyTreeNode{
-				Name: sp.Name,
-				Attributes: filterAttributes(map[string]interface{}{
-					"relation": "spouse",
-					"gender":   sp.Gender,
-				}),
-				ID:       sp.ID,
-				Children: children,
-				Gender:   sp.Gender,
+				Name:       sp.Name,
+				Attributes: filterAttributes(spouseAttrs),
+				ID:         sp.ID,
+				Children:   children,
+				Gender:     sp.Gender,
 			})
 		} else {
 			node.Children = append(node.Children, FamilyTreeNode{
-				Name: sp.Name,
-				Attributes: filterAttributes(map[string]interface{}{
-					"relation": "spouse",
-					"gender":   sp.Gender,
-				}),
-				ID:       sp.ID,
-				Children: []FamilyTreeNode{},
-				Gender:   sp.Gender,
+				Name:       sp.Name,
+				Attributes: filterAttributes(spouseAttrs),
+				ID:         sp.ID,
+				Children:   []FamilyTreeNode{},
+				Gender:     sp.Gender,
 			})
 		}
 	}
@@ -336,8 +412,9 @@ func transformToD3Tree(person internalNode) FamilyTreeNode {
 		node.Children = append(node.Children, FamilyTreeNode{
 			Name: p.Name,
 			Attributes: filterAttributes(map[string]interface{}{
-				"relation": "parent",
-				"gender":   p.Gender,
+				"relation":  "parent",
+				"gender":    p.Gender,
+				"qualifier": p.Attributes["qualifier"],
 			}),
 			ID:       p.ID,
 			Children: children,
@@ -438,6 +515,12 @@ func transformToCoupleTree(node FamilyTreeNode) []FamilyTreeNode {
 				}
 			}
 		}
+		// Carry the couple's marriage metadata from the spouse node
+		for k, v := range spouse.Attributes {
+			if strings.HasPrefix(k, "marriage") {
+				attrs[k] = v
+			}
+		}
 
 		result = append(result, FamilyTreeNode{
 			Name:       coupleName,
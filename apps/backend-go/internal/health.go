@@ -0,0 +1,149 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/gin-gonic/gin"
+)
+
+// Dependency check states.
+const (
+	healthOK       = "ok"
+	healthFailing  = "failing"
+	healthDisabled = "disabled" // not configured, so not required
+)
+
+// healthCheckTimeout bounds each readiness check, so a hung dependency fails the probe
+// instead of stalling it.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthCheck is the state of one dependency in a readiness report.
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Backend   string  `json:"backend,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthz reports that the process is up and serving. It checks nothing else, so a
+// failing dependency never gets the process restarted.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": healthOK})
+}
+
+// readyz reports whether the server can handle requests: MongoDB answers, Redis answers
+// when configured, the indexes have been created and uploads can be stored. It responds
+// 503 when any check fails.
+func readyz(c *gin.Context) {
+	cfg := configFrom(c)
+	checks := map[string]HealthCheck{
+		"mongo": runHealthCheck(c, func(ctx context.Context) error {
+			if MongoClient == nil {
+				return errors.New("not connected")
+			}
+			return MongoClient.Ping(ctx, nil)
+		}),
+		"indexes": runHealthCheck(c, func(ctx context.Context) error {
+			if !indexesEnsured.Load() {
+				return errors.New("indexes not created yet")
+			}
+			return nil
+		}),
+	}
+	if RedisClient == nil {
+		checks["redis"] = HealthCheck{Status: healthDisabled}
+	} else {
+		checks["redis"] = runHealthCheck(c, func(ctx context.Context) error {
+			return RedisClient.Ping(ctx).Err()
+		})
+	}
+	if cfg.Uploads.CloudinaryURL != "" {
+		check := runHealthCheck(c, func(ctx context.Context) error {
+			return pingCloudinary(ctx, cfg.Uploads.CloudinaryURL)
+		})
+		check.Backend = "cloudinary"
+		checks["storage"] = check
+	} else {
+		check := runHealthCheck(c, func(ctx context.Context) error {
+			return checkUploadDir(cfg.Uploads.Dir)
+		})
+		check.Backend = "local"
+		checks["storage"] = check
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Status == healthFailing {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+// runHealthCheck times fn under healthCheckTimeout.
+func runHealthCheck(ctx context.Context, fn func(ctx context.Context) error) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	err := fn(ctx)
+	check := HealthCheck{Status: healthOK, LatencyMs: math.Round(float64(time.Since(start).Microseconds())) / 1000}
+	if err != nil {
+		check.Status = healthFailing
+		check.Error = err.Error()
+	}
+	return check
+}
+
+// checkUploadDir makes sure local uploads can be saved, by writing and removing a file
+// in dir.
+func checkUploadDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("ok"))
+	f.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// cloudinaryPingTTL is how long a Cloudinary ping result is reused. The Admin API is
+// rate limited per hour, and readiness probes would use it up.
+const cloudinaryPingTTL = 5 * time.Minute
+
+var cloudinaryPing struct {
+	sync.Mutex
+	at  time.Time
+	err error
+}
+
+// pingCloudinary checks that the Cloudinary credentials work. Cloudinary has no cheap
+// write check, so valid credentials stand in for writable storage.
+func pingCloudinary(ctx context.Context, cloudURL string) error {
+	cloudinaryPing.Lock()
+	defer cloudinaryPing.Unlock()
+	if time.Since(cloudinaryPing.at) < cloudinaryPingTTL {
+		return cloudinaryPing.err
+	}
+	cld, err := cloudinary.NewFromURL(cloudURL)
+	if err == nil {
+		var res *admin.PingResult
+		// API errors like bad credentials come back in the result, not as err
+		if res, err = cld.Admin.Ping(ctx); err == nil && res.Error.Message != "" {
+			err = errors.New(res.Error.Message)
+		}
+	}
+	cloudinaryPing.at, cloudinaryPing.err = time.Now(), err
+	return err
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Revision actions, derived from the before/after snapshots.
+const (
+	RevisionCreate  = "create"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+	RevisionPurge   = "purge"
+)
+
+// historyCollections are the collections whose mutations are recorded as revisions.
+var historyCollections = []string{"people", "families", "relationships"}
+
+var (
+	errRevisionMismatch = errors.New("revision belongs to another document")
+	errRevisionNoState  = errors.New("revision has no document state to revert to")
+	errRevisionDeleted  = errors.New("document is deleted; restore it from the trash first")
+)
+
+// revertKeptFields keep their current values when a document is reverted: ownership and
+// deletion have endpoints of their own, and the creation time never changes.
+var revertKeptFields = []string{"ownedBy", "deleted", "deletedAt", "createdAt"}
+
+// Revision is one recorded change of a person, family or relationship document. Before
+// and After are full snapshots of the stored document; Before is nil for a create and
+// After is nil for a purge.
+type Revision struct {
+	ID         string    `json:"_id"`
+	Collection string    `json:"collection"`
+	DocumentID string    `json:"documentId"`
+	Action     string    `json:"action"`
+	Reason     string    `json:"reason,omitempty"`
+	Fields     []string  `json:"fields"`
+	Before     bson.M    `json:"before"`
+	After      bson.M    `json:"after"`
+	ChangedBy  string    `json:"changedBy,omitempty"`
+	ChangedAt  time.Time `json:"changedAt"`
+}
+
+// state returns the document as the revision left it, falling back to what it was before
+// when the revision purged it.
+func (r *Revision) state() bson.M {
+	if r.After != nil {
+		return r.After
+	}
+	return r.Before
+}
+
+// historyVisible reports whether user may see the history of the document the revisions
+// belong to (newest first): admins always, users when they own the person or family, or
+// one end of the relationship.
+func historyVisible(ctx context.Context, user *User, collection string, revs []*Revision) (bool, error) {
+	if user.Role == RoleAdmin {
+		return true, nil
+	}
+	if len(revs) == 0 {
+		return false, nil
+	}
+	doc := revs[0].state()
+	if collection != "relationships" {
+		if ob, ok := doc["ownedBy"].(primitive.A); ok {
+			for _, it := range ob {
+				if hexID(it) == user.ID {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	owned, err := storesFrom(ctx).People.OwnedIDs(ctx, []string{user.ID})
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(owned, hexID(doc["from"])) || slices.Contains(owned, hexID(doc["to"])), nil
+}
+
+// checkRevertable reports why current, the stored document, can't be reverted: it is
+// deleted or purged, or it is no longer at version.
+func checkRevertable(current bson.M, version int) error {
+	if deleted, _ := current["deleted"].(bool); current == nil || deleted {
+		return errRevisionDeleted
+	}
+	if versionOf(current) != version {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// revertedDoc is current put back into state, keeping revertKeptFields, with a fresh
+// version so stale copies of either state conflict.
+func revertedDoc(state, current bson.M) bson.M {
+	doc := bson.M{}
+	for k, v := range state {
+		doc[k] = v
+	}
+	for _, k := range revertKeptFields {
+		if v, ok := current[k]; ok {
+			doc[k] = v
+		} else {
+			delete(doc, k)
+		}
+	}
+	doc["_id"] = current["_id"]
+	doc["version"] = versionOf(current) + 1
+	return doc
+}
+
+// inverseRevertFields returns what the inverse of a reverted relationship takes from the
+// state it was reverted to: the order, subtype and marriage both sides share.
+func inverseRevertFields(state bson.M) (set bson.M, unset []string) {
+	set = bson.M{"order": state["order"]}
+	for _, k := range []string{"subtype", "marriage"} {
+		if v, ok := state[k]; ok {
+			set[k] = v
+		} else {
+			unset = append(unset, k)
+		}
+	}
+	return set, unset
+}
+
+// revertToRevision puts a document back into the state recorded by a revision, provided
+// it is live and still at version. The revert is itself recorded as a new revision.
+func revertToRevision(ctx context.Context, collection, documentID, revisionID string, version int) (*Revision, error) {
+	defer startSpan(ctx, "service/revertToRevision").End()
+	rev, err := storesFrom(ctx).History.Get(ctx, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if rev.Collection != collection || rev.DocumentID != documentID {
+		return nil, errRevisionMismatch
+	}
+	if rev.After == nil {
+		return nil, errRevisionNoState
+	}
+	if err := storesFrom(ctx).History.Revert(ctx, rev, version); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// statesBefore decodes the states stateBeforeChangesRepo finds, with nil for a document
+// that didn't exist yet or was deleted at the time.
+func statesBefore[T any](docs map[string]bson.M, decode func(bson.M) *T) map[string]*T {
+	out := make(map[string]*T, len(docs))
+	for id, doc := range docs {
+		if deleted, _ := doc["deleted"].(bool); doc == nil || deleted {
+			out[id] = nil
+			continue
+		}
+		out[id] = decode(doc)
+	}
+	return out
+}
+
+// existedAt reports whether the document with id was created by at, going by its
+// ObjectID. Legacy IDs that aren't ObjectIDs are taken to have always existed.
+func existedAt(id string, at time.Time) bool {
+	oid, err := primitive.ObjectIDFromHex(id)
+	return err != nil || !oid.Timestamp().After(at)
+}
+
+// snapshotTreeSource returns a tree source serving people and relationships as they were
+// at the given time. A document changed since then is what the first of those revisions
+// found; any other document is as it is now, provided its ObjectID shows it already
+// existed. Only the revisions since at and the documents the tree reaches are loaded.
+func snapshotTreeSource(ctx context.Context, at time.Time) (treeSource, error) {
+	defer startSpan(ctx, "service/snapshotTreeSource").End()
+	changedPeople, err := storesFrom(ctx).History.PeopleBefore(ctx, at)
+	if err != nil {
+		return treeSource{}, err
+	}
+	changedRels, err := storesFrom(ctx).History.RelationshipsBefore(ctx, at)
+	if err != nil {
+		return treeSource{}, err
+	}
+	changedByPerson := map[string][]*Relationship{}
+	for _, r := range changedRels {
+		if r == nil {
+			continue
+		}
+		changedByPerson[r.From] = append(changedByPerson[r.From], r)
+		if r.To != r.From {
+			changedByPerson[r.To] = append(changedByPerson[r.To], r)
+		}
+	}
+
+	return treeSource{
+		person: func(ctx context.Context, id string) (*Person, error) {
+			if p, changed := changedPeople[id]; changed {
+				if p == nil {
+					return nil, mongo.ErrNoDocuments
+				}
+				return p, nil
+			}
+			if !existedAt(id, at) {
+				return nil, mongo.ErrNoDocuments
+			}
+			return storesFrom(ctx).People.Get(ctx, id)
+		},
+		relationships: func(ctx context.Context, id string) ([]*Relationship, error) {
+			rels := append([]*Relationship{}, changedByPerson[id]...)
+			current, err := storesFrom(ctx).Relationships.ListByPerson(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range current {
+				if _, changed := changedRels[r.ID]; changed || !existedAt(r.ID, at) {
+					continue
+				}
+				rels = append(rels, r)
+			}
+			return rels, nil
+		},
+	}, nil
+}
@@ -0,0 +1,78 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// loadVisibleHistory validates the kind and ID route params and returns the document's
+// revisions, writing the error response itself when it can't.
+func loadVisibleHistory(c *gin.Context) ([]*Revision, bool) {
+	kind, id := c.Param("kind"), c.Param("id")
+	if !slices.Contains(historyCollections, kind) {
+		responseError(c, "Unknown history kind", 400)
+		return nil, false
+	}
+	if !isObjectIDHex(id) {
+		responseError(c, "Invalid ID", 400)
+		return nil, false
+	}
+	revs, err := storesFrom(c).History.List(c, kind, id)
+	if err != nil {
+		responseError(c, "Failed to fetch history", 500)
+		return nil, false
+	}
+	u, _ := c.Get("user")
+	visible, err := historyVisible(c, u.(*User), kind, revs)
+	if err != nil {
+		responseError(c, "Failed to fetch history", 500)
+		return nil, false
+	}
+	if !visible {
+		responseError(c, "History not found", 404)
+		return nil, false
+	}
+	return revs, true
+}
+
+func getHistory(c *gin.Context) {
+	defer startSpan(c, "handler/getHistory").End()
+	revs, ok := loadVisibleHistory(c)
+	if !ok {
+		return
+	}
+	responseSuccess(c, revs, 200)
+}
+
+func revertHistory(c *gin.Context) {
+	defer startSpan(c, "handler/revertHistory").End()
+	defer securityLog(c, "history.revert", c.Param("kind")+"/"+c.Param("id"), "revision="+c.Param("revisionId"))
+	if _, ok := loadVisibleHistory(c); !ok {
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+	rev, err := revertToRevision(c, c.Param("kind"), c.Param("id"), c.Param("revisionId"), version)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRevisionMismatch), errors.Is(err, mongo.ErrNoDocuments), !isObjectIDHex(c.Param("revisionId")):
+			responseError(c, "Revision not found", 404)
+		case errors.Is(err, errRevisionNoState):
+			responseError(c, "Revision has no state to revert to", 409)
+		case errors.Is(err, errRevisionDeleted):
+			responseError(c, "Document is deleted; restore it from the trash first", 409)
+		case errors.Is(err, errVersionConflict):
+			responseError(c, "Document has changed; reload it and retry", http.StatusPreconditionFailed)
+		default:
+			responseError(c, "Failed to revert", 500)
+		}
+		return
+	}
+	responseSuccess(c, rev, 200)
+}
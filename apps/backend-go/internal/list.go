@@ -0,0 +1,319 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// listParams switch a list endpoint from the legacy "everything, fully populated" response
+// to a page. Each endpoint adds its own filter names.
+var listParams = []string{"limit", "cursor", "sort", "fields", "include"}
+
+var errInvalidCursor = errors.New("is not a valid cursor for this sort")
+
+// listSpec describes what a list endpoint lets clients sort, include and project.
+type listSpec struct {
+	sorts    []string // sortable fields; the first is the default
+	includes []string // optional lookups
+	fields   []string // projectable fields besides _id
+}
+
+// listQuery is a parsed page request. Match holds the endpoint's filters and is ANDed with
+// the soft-delete and ownership scope by the repo.
+type listQuery struct {
+	Limit   int
+	Sort    string
+	Desc    bool
+	After   *listCursor
+	Match   bson.M
+	Include map[string]bool
+	Fields  []string
+}
+
+// listCursor is the keyset position after the last item of a page: its sort value and ID.
+// It travels as base64url-encoded BSON so dates and strings keep their types.
+type listCursor struct {
+	Sort  string             `bson:"s"`
+	Value interface{}        `bson:"v"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+// Page is one page of a list endpoint. NextCursor is empty on the last page.
+type Page struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// isListRequest reports whether the request uses any paging, projection or filter parameter.
+func isListRequest(c *gin.Context, filters ...string) bool {
+	q := c.Request.URL.Query()
+	for _, name := range slices.Concat(listParams, filters) {
+		if q.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseListQuery reads limit, cursor, sort, include and fields. sort is a field name,
+// prefixed with "-" for descending order.
+func parseListQuery(c *gin.Context, spec listSpec, v *validator) *listQuery {
+	q := &listQuery{Limit: defaultPageSize, Sort: spec.sorts[0], Match: bson.M{}, Include: map[string]bool{}}
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			v.add("limit", "must be a positive integer")
+		} else {
+			q.Limit = min(n, maxPageSize)
+		}
+	}
+	if s := c.Query("sort"); s != "" {
+		q.Desc = strings.HasPrefix(s, "-")
+		q.Sort = strings.TrimPrefix(s, "-")
+		v.oneOf("sort", q.Sort, spec.sorts...)
+	}
+	for _, name := range listValues(c, "include") {
+		v.oneOf("include", name, spec.includes...)
+		q.Include[name] = true
+	}
+	for _, name := range listValues(c, "fields") {
+		v.oneOf("fields", name, spec.fields...)
+		q.Fields = append(q.Fields, name)
+	}
+	if s := c.Query("cursor"); s != "" {
+		cur, err := decodeListCursor(s)
+		if err != nil || cur.Sort != c.DefaultQuery("sort", spec.sorts[0]) {
+			v.add("cursor", errInvalidCursor.Error())
+		} else {
+			q.After = cur
+		}
+	}
+	return q
+}
+
+// listValues splits a comma-separated query parameter, dropping blanks.
+func listValues(c *gin.Context, name string) []string {
+	out := []string{}
+	for _, s := range strings.Split(c.Query(name), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// filterIn adds field ∈ values to the match, after checking each value against allowed.
+func (q *listQuery) filterIn(c *gin.Context, v *validator, field string, allowed ...string) {
+	values := listValues(c, field)
+	if len(values) == 0 {
+		return
+	}
+	for _, s := range values {
+		v.oneOf(field, s, allowed...)
+	}
+	q.Match[field] = bson.M{"$in": values}
+}
+
+// filterID adds a match on an ObjectID-valued document field from the named parameter.
+func (q *listQuery) filterID(c *gin.Context, v *validator, param, field string) {
+	s := c.Query(param)
+	if s == "" {
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		v.add(param, "must be a valid ID")
+		return
+	}
+	q.Match[field] = oid
+}
+
+// filterYears adds a birthDate range covering the whole of the from and to years.
+func (q *listQuery) filterYears(c *gin.Context, v *validator, fromParam, toParam, field string) {
+	r := bson.M{}
+	year := func(param string) (int, bool) {
+		s := c.Query(param)
+		if s == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 9999 {
+			v.add(param, "must be a year")
+			return 0, false
+		}
+		return n, true
+	}
+	if y, ok := year(fromParam); ok {
+		r["$gte"] = time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if y, ok := year(toParam); ok {
+		r["$lt"] = time.Date(y+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if len(r) > 0 {
+		q.Match[field] = r
+	}
+}
+
+// pipeline starts an aggregation for the page: scope, filters and cursor, then sort and
+// limit. It fetches one extra document so page can tell whether another page follows.
+func (q *listQuery) pipeline(scope bson.M) mongo.Pipeline {
+	conds := bson.A{scope}
+	if len(q.Match) > 0 {
+		conds = append(conds, q.Match)
+	}
+	if q.After != nil {
+		conds = append(conds, q.cursorFilter())
+	}
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	sort := bson.D{{Key: "_id", Value: dir}}
+	if q.Sort != "_id" {
+		sort = append(bson.D{{Key: q.Sort, Value: dir}}, sort...)
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": conds}}},
+		{{Key: "$sort", Value: sort}},
+		{{Key: "$limit", Value: q.Limit + 1}},
+	}
+}
+
+// cursorFilter matches documents after the cursor in sort order. Missing sort values sort
+// before every other value, so they come first ascending and last descending.
+func (q *listQuery) cursorFilter() bson.M {
+	op := "$gt"
+	if q.Desc {
+		op = "$lt"
+	}
+	afterID := bson.M{"_id": bson.M{op: q.After.ID}}
+	if q.Sort == "_id" {
+		return afterID
+	}
+	tie := bson.M{q.Sort: q.After.Value, "_id": bson.M{op: q.After.ID}}
+	if q.After.Value == nil {
+		if q.Desc {
+			return tie
+		}
+		return bson.M{"$or": bson.A{bson.M{q.Sort: bson.M{"$ne": nil}}, tie}}
+	}
+	or := bson.A{bson.M{q.Sort: bson.M{op: q.After.Value}}, tie}
+	if q.Desc {
+		or = append(or, bson.M{q.Sort: nil})
+	}
+	return bson.M{"$or": or}
+}
+
+// projection keeps the requested fields plus what the repo needs: _id, the sort field and
+// any lookups in extra. It is nil when the client didn't ask for specific fields.
+func (q *listQuery) projection(extra ...string) bson.D {
+	if len(q.Fields) == 0 {
+		return nil
+	}
+	proj := bson.D{}
+	for _, f := range slices.Concat(q.Fields, []string{q.Sort}, extra) {
+		if f != "_id" && !slices.ContainsFunc(proj, func(e bson.E) bool { return e.Key == f }) {
+			proj = append(proj, bson.E{Key: f, Value: 1})
+		}
+	}
+	return proj
+}
+
+// page trims the extra document fetched by pipeline and returns the cursor for the next page.
+func (q *listQuery) page(docs []bson.M) ([]bson.M, string) {
+	if len(docs) <= q.Limit {
+		return docs, ""
+	}
+	docs = docs[:q.Limit]
+	last := docs[len(docs)-1]
+	id, _ := last["_id"].(primitive.ObjectID)
+	var value interface{}
+	if q.Sort != "_id" {
+		value = last[q.Sort]
+	}
+	return docs, encodeListCursor(&listCursor{Sort: q.sortParam(), Value: value, ID: id})
+}
+
+// sortParam is the sort as written in the query string.
+func (q *listQuery) sortParam() string {
+	if q.Desc {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+func encodeListCursor(cur *listCursor) string {
+	raw, err := bson.Marshal(cur)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cur listCursor
+	if err := bson.Unmarshal(raw, &cur); err != nil || cur.ID.IsZero() {
+		return nil, errInvalidCursor
+	}
+	return &cur, nil
+}
+
+// responseInvalidQuery is the 400 response for a list request with bad query parameters.
+func responseInvalidQuery(c *gin.Context, errs []FieldError) {
+	responseErrorDetails(c, "Invalid query parameters", 400, errs)
+}
+
+// respondPage sends items as a Page. When fields were requested, each item is cut down to
+// _id, those fields and whatever was included.
+func respondPage(c *gin.Context, q *listQuery, items interface{}, next string) {
+	if len(q.Fields) == 0 {
+		responseSuccess(c, Page{Items: items, NextCursor: next}, 200)
+		return
+	}
+	raw, err := json.Marshal(items)
+	if err != nil {
+		responseError(c, fmt.Sprintf("Failed to encode items: %v", err), 500)
+		return
+	}
+	var full []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &full); err != nil {
+		responseError(c, fmt.Sprintf("Failed to encode items: %v", err), 500)
+		return
+	}
+	keep := []string{"_id"}
+	keep = append(keep, q.Fields...)
+	for name := range q.Include {
+		keep = append(keep, name)
+	}
+	out := make([]map[string]json.RawMessage, 0, len(full))
+	for _, item := range full {
+		m := map[string]json.RawMessage{}
+		for _, k := range keep {
+			if v, ok := item[k]; ok {
+				m[k] = v
+			}
+		}
+		out = append(out, m)
+	}
+	responseSuccess(c, Page{Items: out, NextCursor: next}, 200)
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Log subsystems. Each has its own level, so e.g. tree building can log at debug while
+// everything else stays at info.
+const (
+	logApp      = "app"
+	logHTTP     = "http"
+	logDB       = "db"
+	logStore    = "store"
+	logTree     = "tree"
+	logUpload   = "upload"
+	logSearch   = "search"
+	logTrash    = "trash"
+	logHistory  = "history"
+	logSecurity = "security"
+)
+
+var logSubsystems = []string{logApp, logHTTP, logDB, logStore, logTree, logUpload, logSearch, logTrash, logHistory, logSecurity}
+
+// loggers holds one logger per subsystem. InitLogging replaces it at startup, before any
+// goroutine logs; until then everything logs JSON at info.
+var loggers = newLoggers(os.Stderr, DefaultConfig().Log)
+
+// InitLogging applies the log configuration and makes the app logger the default, so the
+// standard log package and slog's top-level functions go through it too.
+func InitLogging(cfg LogConfig) {
+	loggers = newLoggers(os.Stderr, cfg)
+	slog.SetDefault(loggers[logApp])
+}
+
+// Logger returns the logger of a subsystem, for code outside this package.
+func Logger(subsystem string) *slog.Logger {
+	return logger(subsystem)
+}
+
+func logger(subsystem string) *slog.Logger {
+	if l, ok := loggers[subsystem]; ok {
+		return l
+	}
+	return loggers[logApp]
+}
+
+// newLoggers builds the subsystem loggers over one JSON or text handler. LoadConfig has
+// validated the level names, so an unknown one can't get here.
+func newLoggers(w io.Writer, cfg LogConfig) map[string]*slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // contextHandler does the filtering
+	var base slog.Handler = slog.NewJSONHandler(w, opts)
+	if cfg.Format == "text" {
+		base = slog.NewTextHandler(w, opts)
+	}
+	out := map[string]*slog.Logger{}
+	for _, name := range logSubsystems {
+		level, _ := parseLogLevel(cfg.Level)
+		if v, ok := cfg.Levels[name]; ok {
+			level, _ = parseLogLevel(v)
+		}
+		out[name] = slog.New(contextHandler{Handler: base.WithAttrs([]slog.Attr{slog.String("subsystem", name)}), level: level})
+	}
+	return out
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// contextHandler filters records by its subsystem's level and adds the request ID and the
+// authenticated user of the request behind the context to every record.
+type contextHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h contextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id, ok := ctx.Value("requestId").(string); ok {
+			r.AddAttrs(slog.String("request_id", id))
+		}
+		if u, ok := ctx.Value("user").(*User); ok {
+			r.AddAttrs(slog.String("user_id", u.ID))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// RequestLogger gives every request an ID and logs it once it has been served. An ID sent
+// by the client or a proxy in X-Request-ID is kept, so one request can be followed across
+// services; otherwise a new one is generated. Either way it is sent back in the response.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set("requestId", id)
+		c.Header(requestIDHeader, id)
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case c.FullPath() == "/healthz" || c.FullPath() == "/readyz":
+			// probes would drown everything else
+			level = slog.LevelDebug
+		}
+		logger(logHTTP).Log(c, level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", c.Writer.Size(),
+			"ip", c.ClientIP(),
+		)
+	}
+}
+
+// validRequestID accepts IDs of up to 128 visible ASCII characters, so a client can't
+// inject line breaks or huge values into the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The functions below back the operator subcommands of the backend binary. They run
+// outside any request, so the caller passes the stores to work against.
+
+// minPasswordLength applies to the passwords set by CreateAdmin and ResetPassword. The API
+// doesn't enforce it, so existing clients keep working.
+const minPasswordLength = 8
+
+// CreateAdmin adds an admin account. It is the only way to get the first admin, since
+// accounts created through the API are always plain users.
+func CreateAdmin(ctx context.Context, stores *Stores, username, name, password string) (*User, error) {
+	if username == "" || name == "" {
+		return nil, errors.New("username and name are required")
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if _, err := stores.Users.GetByUsername(ctx, username); err == nil {
+		return nil, fmt.Errorf("username %q is already taken", username)
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	return stores.Users.Create(ctx, &User{Name: name, Username: username, Password: string(hashed), Role: RoleAdmin})
+}
+
+// ResetPassword sets a new password for the user with username.
+func ResetPassword(ctx context.Context, stores *Stores, username, password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	user, err := stores.Users.GetByUsername(ctx, username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("no user %q", username)
+	}
+	if err != nil {
+		return err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return stores.Users.SetPassword(ctx, user.ID, string(hashed))
+}
+
+// FlushCache drops every cached entry. It reports false when Redis isn't configured.
+func FlushCache(ctx context.Context) bool {
+	if RedisClient == nil {
+		return false
+	}
+	cacheDelPattern(ctx, "ft:*")
+	return true
+}
+
+// RunAudit runs the data audit, like GET /api/admin/audit.
+func RunAudit(ctx context.Context, stores *Stores) (*AuditReport, error) {
+	return runDataAudit(WithStores(ctx, stores))
+}
+
+// FixAudit applies the auto-fixes for the given finding codes (all fixable findings when
+// codes is empty), like POST /api/admin/audit/fix.
+func FixAudit(ctx context.Context, stores *Stores, codes []string) ([]AuditFinding, error) {
+	return applyAuditFixes(WithStores(ctx, stores), codes)
+}
+
+// familyExportFormat is bumped whenever FamilyExport changes incompatibly.
+const familyExportFormat = 1
+
+// FamilyExport is a family with its members and the relationships between them, as
+// written by ExportFamily and read by ImportFamily. IDs are the ones of the source
+// database; ImportFamily only uses them to connect the documents.
+type FamilyExport struct {
+	Format        int             `json:"format"`
+	ExportedAt    time.Time       `json:"exportedAt"`
+	Family        *Family         `json:"family"`
+	People        []*Person       `json:"people"`
+	Relationships []*Relationship `json:"relationships"`
+}
+
+// ExportFamily collects the family with id, its members and every live relationship
+// between two members.
+func ExportFamily(ctx context.Context, stores *Stores, id string) (*FamilyExport, error) {
+	ctx = WithStores(ctx, stores)
+	f, err := stores.Families.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := familyMemberIDs(ctx, liveTreeSource, f)
+	if err != nil {
+		return nil, err
+	}
+	out := &FamilyExport{Format: familyExportFormat, ExportedAt: time.Now(), Family: f, People: []*Person{}, Relationships: []*Relationship{}}
+	f.Person = nil
+
+	members := map[string]bool{}
+	for _, id := range ids {
+		p, err := stores.People.Get(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Owners, p.Relationships = nil, nil
+		members[p.ID] = true
+		out.People = append(out.People, p)
+	}
+	seen := map[string]bool{}
+	for _, p := range out.People {
+		rels, err := stores.Relationships.ListByPerson(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rels {
+			if seen[r.ID] || !members[r.From] || !members[r.To] {
+				continue
+			}
+			seen[r.ID] = true
+			r.FromDetails, r.ToDetails = nil, nil
+			out.Relationships = append(out.Relationships, r)
+		}
+	}
+	return out, nil
+}
+
+// ImportFamily creates the people, relationships and family of an export as new
+// documents. A non-empty owner is the username that owns them instead of the owners
+// recorded in the export, for imports into a database where those users don't exist.
+// The export is checked before anything is written, but the writes themselves are not
+// atomic: a failure part way leaves the documents created so far.
+func ImportFamily(ctx context.Context, stores *Stores, data *FamilyExport, owner string) (*Family, error) {
+	if data.Format != familyExportFormat {
+		return nil, fmt.Errorf("unsupported export format %d", data.Format)
+	}
+	if data.Family == nil {
+		return nil, errors.New("export has no family")
+	}
+	exported := map[string]bool{}
+	for _, p := range data.People {
+		exported[p.ID] = true
+	}
+	for _, r := range data.Relationships {
+		if !exported[r.From] || !exported[r.To] {
+			return nil, fmt.Errorf("relationship %s refers to a person missing from the export", r.ID)
+		}
+	}
+	for _, c := range data.Family.Founders {
+		if !exported[c.Person] || (c.Spouse != "" && !exported[c.Spouse]) {
+			return nil, errors.New("family founders refer to a person missing from the export")
+		}
+	}
+	var ownedBy []string
+	if owner != "" {
+		u, err := stores.Users.GetByUsername(ctx, owner)
+		if err != nil {
+			return nil, fmt.Errorf("owner %q: %w", owner, err)
+		}
+		ownedBy = []string{u.ID}
+	}
+
+	ids := map[string]string{}
+	for _, p := range data.People {
+		in := *p
+		if ownedBy != nil {
+			in.OwnedBy = ownedBy
+		}
+		created, err := stores.People.Create(ctx, &in)
+		if err != nil {
+			return nil, fmt.Errorf("create person %s: %w", p.ID, err)
+		}
+		ids[p.ID] = created.ID
+	}
+
+	inserts := make([]Relationship, 0, len(data.Relationships))
+	for _, r := range data.Relationships {
+		inserts = append(inserts, Relationship{From: ids[r.From], To: ids[r.To], Type: r.Type, Subtype: r.Subtype, Order: r.Order, Marriage: r.Marriage})
+	}
+	if _, err := stores.Relationships.Apply(ctx, inserts, nil, nil); err != nil {
+		return nil, fmt.Errorf("create relationships: %w", err)
+	}
+
+	f := *data.Family
+	f.Founders = make([]FamilyCouple, 0, len(data.Family.Founders))
+	for _, c := range data.Family.Founders {
+		f.Founders = append(f.Founders, FamilyCouple{Person: ids[c.Person], Spouse: ids[c.Spouse]})
+	}
+	// explicit members that weren't exported no longer exist; drop them
+	f.Members = nil
+	for _, m := range data.Family.Members {
+		if id, ok := ids[m]; ok {
+			f.Members = append(f.Members, id)
+		}
+	}
+	if ownedBy != nil {
+		f.OwnedBy = ownedBy
+	}
+	return stores.Families.Create(ctx, &f)
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Marriage statuses accepted on spouse relationships.
+const (
+	MarriageMarried  = "married"
+	MarriageDivorced = "divorced"
+	MarriageWidowed  = "widowed"
+	MarriagePartner  = "partner"
+)
+
+var marriageStatuses = map[string]bool{
+	MarriageMarried:  true,
+	MarriageDivorced: true,
+	MarriageWidowed:  true,
+	MarriagePartner:  true,
+}
+
+// normalizeMarriage drops empty metadata and defaults the status to married.
+func normalizeMarriage(m *Marriage) *Marriage {
+	if m == nil || (*m == Marriage{}) {
+		return nil
+	}
+	out := *m
+	if out.Status == "" {
+		out.Status = MarriageMarried
+	}
+	return &out
+}
+
+// marriageEqual reports whether two marriages hold the same metadata.
+func marriageEqual(a, b *Marriage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Status == b.Status && a.Place == b.Place &&
+		timePtrEqual(a.StartDate, b.StartDate) && timePtrEqual(a.EndDate, b.EndDate)
+}
+
+func timePtrEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// marriageDoc converts marriage metadata to the subdocument stored on a relationship.
+func marriageDoc(m *Marriage) bson.M {
+	doc := bson.M{"status": m.Status}
+	if m.StartDate != nil {
+		doc["startDate"] = *m.StartDate
+	}
+	if m.EndDate != nil {
+		doc["endDate"] = *m.EndDate
+	}
+	if m.Place != "" {
+		doc["place"] = m.Place
+	}
+	return doc
+}
+
+// marriageAttributes exposes marriage metadata as D3 node attributes.
+func marriageAttributes(m *Marriage) map[string]interface{} {
+	attrs := map[string]interface{}{}
+	if m == nil {
+		return attrs
+	}
+	attrs["marriageStatus"] = m.Status
+	attrs["marriagePlace"] = m.Place
+	if m.StartDate != nil {
+		attrs["marriageStart"] = m.StartDate.Format("2006-01-02")
+	}
+	if m.EndDate != nil {
+		attrs["marriageEnd"] = m.EndDate.Format("2006-01-02")
+	}
+	return attrs
+}
+
+// sortSpouseEdges orders spouse relationships chronologically by marriage start date.
+// Undated marriages follow, by order and then by spouse ID, so the result never depends
+// on map iteration.
+func sortSpouseEdges(edges []*Relationship, spouseID func(*Relationship) string) {
+	sort.SliceStable(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		var as, bs *time.Time
+		if a.Marriage != nil {
+			as = a.Marriage.StartDate
+		}
+		if b.Marriage != nil {
+			bs = b.Marriage.StartDate
+		}
+		switch {
+		case as != nil && bs != nil && !as.Equal(*bs):
+			return as.Before(*bs)
+		case as != nil && bs == nil:
+			return true
+		case as == nil && bs != nil:
+			return false
+		}
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		return spouseID(a) < spouseID(b)
+	})
+}
@@ -0,0 +1,1419 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryDB keeps the collections in memory, as the same bson.M documents Mongo would
+// return, so the stores below can reuse the Mongo decoders and list helpers. Documents go
+// through a BSON round trip on the way in and out: types match what a cursor decodes and
+// callers never share maps with the store.
+type memoryDB struct {
+	mu   sync.Mutex
+	docs map[string]map[primitive.ObjectID]bson.M
+}
+
+// NewMemoryStores returns stores that keep everything in process memory, with the same
+// soft-delete, ownership and version rules as the Mongo stores. It is meant for tests and
+// local development; nothing is cached or persisted.
+func NewMemoryStores() *Stores {
+	db := &memoryDB{docs: map[string]map[primitive.ObjectID]bson.M{
+		"users":         {},
+		"people":        {},
+		"families":      {},
+		"relationships": {},
+		"merges":        {},
+		"revisions":     {},
+		"securityLog":   {},
+	}}
+	return &Stores{
+		Users:         memoryUserStore{db},
+		People:        memoryPersonStore{db},
+		Families:      memoryFamilyStore{db},
+		Relationships: memoryRelationshipStore{db},
+		Merges:        memoryMergeStore{db},
+		Trash:         memoryTrashStore{db},
+		History:       memoryHistoryStore{db},
+		Audit:         memoryAuditStore{db},
+		SecurityLog:   memorySecurityLogStore{db},
+	}
+}
+
+// memCopy deep-copies doc the way the driver would decode it.
+func memCopy(doc bson.M) bson.M {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("memory store: %v", err))
+	}
+	var out bson.M
+	if err := bson.Unmarshal(raw, &out); err != nil {
+		panic(fmt.Sprintf("memory store: %v", err))
+	}
+	return out
+}
+
+// put stores a copy of doc, which must have an _id.
+func (db *memoryDB) put(collection string, doc bson.M) {
+	doc = memCopy(doc)
+	db.docs[collection][doc["_id"].(primitive.ObjectID)] = doc
+}
+
+// get returns a copy of a document, or nil when it doesn't exist or is soft-deleted.
+func (db *memoryDB) get(collection string, id primitive.ObjectID) bson.M {
+	doc, ok := db.docs[collection][id]
+	if !ok || doc["deleted"] == true {
+		return nil
+	}
+	return memCopy(doc)
+}
+
+// find returns copies of the documents matching filter, in _id order.
+func (db *memoryDB) find(collection string, filter bson.M) []bson.M {
+	out := []bson.M{}
+	for _, doc := range db.docs[collection] {
+		if memMatch(doc, filter) {
+			out = append(out, memCopy(doc))
+		}
+	}
+	memSort(out, "_id", false)
+	return out
+}
+
+// update applies set to a document, if there is one, and bumps its version.
+func (db *memoryDB) update(collection string, id primitive.ObjectID, set bson.M) {
+	doc, ok := db.docs[collection][id]
+	if !ok {
+		return
+	}
+	for k, v := range memCopy(set) {
+		doc[k] = v
+	}
+	doc["version"] = int32(versionOf(doc) + 1)
+}
+
+// softDelete marks a live document deleted; it reports whether there was one.
+func (db *memoryDB) softDelete(collection string, id primitive.ObjectID, now time.Time) bool {
+	if db.get(collection, id) == nil {
+		return false
+	}
+	db.update(collection, id, bson.M{"deleted": true, "deletedAt": now})
+	return true
+}
+
+// restore undoes a soft delete like trashRestoreUpdate does.
+func (db *memoryDB) restore(collection string, id primitive.ObjectID) {
+	db.update(collection, id, bson.M{"deleted": false})
+	db.unset(collection, id, "deletedAt")
+}
+
+// unset removes fields from a document without touching its version.
+func (db *memoryDB) unset(collection string, id primitive.ObjectID, fields ...string) {
+	for _, f := range fields {
+		delete(db.docs[collection][id], f)
+	}
+}
+
+// ownedPeople returns the IDs of the people owned by one of ownedBy, deleted or not.
+func (db *memoryDB) ownedPeople(ownedBy []string) []string {
+	ids := []string{}
+	for _, doc := range db.find("people", bson.M{"ownedBy": bson.M{"$in": objectIDs(ownedBy)}}) {
+		ids = append(ids, hexID(doc["_id"]))
+	}
+	return ids
+}
+
+// live reports whether id is a person that isn't soft-deleted.
+func (db *memoryDB) live(id string) bool {
+	oid, err := primitive.ObjectIDFromHex(id)
+	return err == nil && db.get("people", oid) != nil
+}
+
+// memoryTracker records a revision for every watched document a write changed, like
+// revisionTracker does in Mongo. It is used with the lock held.
+type memoryTracker struct {
+	db     *memoryDB
+	reason string
+	before map[string]map[primitive.ObjectID]bson.M
+}
+
+func (db *memoryDB) track(reason string) *memoryTracker {
+	return &memoryTracker{db: db, reason: reason, before: map[string]map[primitive.ObjectID]bson.M{}}
+}
+
+// watch snapshots documents about to be changed; missing ones are recorded as creates.
+func (t *memoryTracker) watch(collection string, ids ...primitive.ObjectID) {
+	if t.before[collection] == nil {
+		t.before[collection] = map[primitive.ObjectID]bson.M{}
+	}
+	for _, id := range ids {
+		if _, ok := t.before[collection][id]; !ok {
+			t.before[collection][id] = t.snapshot(collection, id)
+		}
+	}
+}
+
+func (t *memoryTracker) snapshot(collection string, id primitive.ObjectID) bson.M {
+	if doc, ok := t.db.docs[collection][id]; ok {
+		return memCopy(doc)
+	}
+	return nil
+}
+
+func (t *memoryTracker) record(ctx context.Context) {
+	now := time.Now()
+	actor := revisionActor(ctx)
+	for collection, before := range t.before {
+		for id, b := range before {
+			if doc := revisionDoc(collection, id, b, t.snapshot(collection, id), t.reason, actor, now); doc != nil {
+				doc["_id"] = primitive.NewObjectID()
+				t.db.put("revisions", doc)
+			}
+		}
+	}
+}
+
+// page runs a list query over a collection: scope, filters and cursor, then sort and
+// limit, like listQuery.pipeline does in Mongo.
+func (db *memoryDB) page(collection string, scope bson.M, q *listQuery) ([]bson.M, string) {
+	conds := bson.A{scope}
+	if len(q.Match) > 0 {
+		conds = append(conds, q.Match)
+	}
+	if q.After != nil {
+		conds = append(conds, q.cursorFilter())
+	}
+	docs := db.find(collection, bson.M{"$and": conds})
+	memSort(docs, q.Sort, q.Desc)
+	if len(docs) > q.Limit+1 {
+		docs = docs[:q.Limit+1]
+	}
+	return q.page(docs)
+}
+
+// lookupPeople returns the people documents with the given IDs, deleted or not, as a
+// $lookup would.
+func (db *memoryDB) lookupPeople(refs ...interface{}) primitive.A {
+	out := primitive.A{}
+	for _, ref := range refs {
+		if oid, ok := ref.(primitive.ObjectID); ok {
+			if doc, ok := db.docs["people"][oid]; ok {
+				out = append(out, memCopy(doc))
+			}
+		}
+	}
+	return out
+}
+
+// withOwners adds the owners lookup of a person, without password hashes.
+func (db *memoryDB) withOwners(doc bson.M) bson.M {
+	owners := primitive.A{}
+	ids, _ := doc["ownedBy"].(primitive.A)
+	for _, id := range ids {
+		oid, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if u, ok := db.docs["users"][oid]; ok {
+			u = memCopy(u)
+			delete(u, "password")
+			owners = append(owners, u)
+		}
+	}
+	doc["owners"] = owners
+	return doc
+}
+
+// withRelationships adds the live outgoing edges of a person, each with toDetails.
+func (db *memoryDB) withRelationships(doc bson.M) bson.M {
+	rels := primitive.A{}
+	for _, r := range db.find("relationships", bson.M{"from": doc["_id"], "deleted": bson.M{"$ne": true}}) {
+		r["toDetails"] = db.lookupPeople(r["to"])
+		rels = append(rels, r)
+	}
+	doc["relationships"] = rels
+	return doc
+}
+
+// withPersonDetails adds the family's root person, as familyPersonLookup does.
+func (db *memoryDB) withPersonDetails(doc bson.M) bson.M {
+	if people := db.lookupPeople(doc["person"]); len(people) > 0 {
+		doc["personDetails"] = people[0]
+	}
+	return doc
+}
+
+// memScope is the soft-delete and ownership filter of a listing.
+func memScope(ownedBy []string) bson.M {
+	scope := bson.M{"deleted": bson.M{"$ne": true}}
+	if ownedBy != nil {
+		scope["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	return scope
+}
+
+// memLegacyScope is the scope of the unpaged listings, which ignore an empty ownedBy.
+func memLegacyScope(ownedBy []string) bson.M {
+	if len(ownedBy) == 0 {
+		ownedBy = nil
+	}
+	return memScope(ownedBy)
+}
+
+// memMatch evaluates the subset of the Mongo query language the repos use: $and, $or,
+// field equality (a nil value matches a missing field, an array field matches any of its
+// elements), $ne, $in, $exists and the range operators.
+func memMatch(doc bson.M, filter bson.M) bool {
+	for key, cond := range filter {
+		switch key {
+		case "$and", "$or":
+			subs := memList(cond)
+			matched := false
+			for _, sub := range subs {
+				m, _ := sub.(bson.M)
+				ok := memMatch(doc, m)
+				if key == "$and" && !ok {
+					return false
+				}
+				matched = matched || ok
+			}
+			if key == "$or" && !matched {
+				return false
+			}
+		default:
+			v, present := memPath(doc, key)
+			if !memMatchField(v, present, cond) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// memPath looks up a field by a dotted path like Mongo does: going through an array of
+// documents, it collects the field from each of them.
+func memPath(doc bson.M, path string) (interface{}, bool) {
+	head, rest, nested := strings.Cut(path, ".")
+	v, ok := doc[head]
+	if !ok || !nested {
+		return v, ok
+	}
+	switch vv := v.(type) {
+	case bson.M:
+		return memPath(vv, rest)
+	case primitive.A:
+		found := primitive.A{}
+		for _, e := range vv {
+			if m, ok := e.(bson.M); ok {
+				if x, ok := memPath(m, rest); ok {
+					found = append(found, x)
+				}
+			}
+		}
+		return found, len(found) > 0
+	}
+	return nil, false
+}
+
+func memMatchField(v interface{}, present bool, cond interface{}) bool {
+	ops, ok := cond.(bson.M)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(memFirstKey(ops), "$") {
+		return memEqual(v, cond)
+	}
+	for op, arg := range ops {
+		switch op {
+		case "$ne":
+			if memEqual(v, arg) {
+				return false
+			}
+		case "$in":
+			if !slices.ContainsFunc(memList(arg), func(x interface{}) bool { return memEqual(v, x) }) {
+				return false
+			}
+		case "$nin":
+			if slices.ContainsFunc(memList(arg), func(x interface{}) bool { return memEqual(v, x) }) {
+				return false
+			}
+		case "$exists":
+			if present != (arg == true) {
+				return false
+			}
+		case "$gt", "$gte", "$lt", "$lte":
+			if !memRange(v, op, arg) {
+				return false
+			}
+		default:
+			panic("memory store: unsupported operator " + op)
+		}
+	}
+	return true
+}
+
+func memFirstKey(m bson.M) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+// memList turns a bson.A or any typed slice into a []interface{}.
+func memList(v interface{}) []interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil
+	}
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out
+}
+
+// memScalar normalizes the Go types a filter may hold to the ones a decoded document has.
+func memScalar(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case time.Time:
+		return primitive.NewDateTimeFromTime(vv)
+	case int:
+		return int64(vv)
+	case int32:
+		return int64(vv)
+	case UserRole:
+		return string(vv)
+	}
+	return v
+}
+
+func memEqual(v, want interface{}) bool {
+	if arr, ok := v.(primitive.A); ok {
+		return slices.ContainsFunc(arr, func(e interface{}) bool { return memEqual(e, want) })
+	}
+	if want == nil {
+		return v == nil
+	}
+	return memCompare(v, want) == 0 && memTypeRank(v) == memTypeRank(want)
+}
+
+func memRange(v interface{}, op string, bound interface{}) bool {
+	if arr, ok := v.(primitive.A); ok {
+		return slices.ContainsFunc(arr, func(e interface{}) bool { return memRange(e, op, bound) })
+	}
+	// like Mongo, ranges only match values of the bound's type
+	if v == nil || memTypeRank(v) != memTypeRank(bound) {
+		return false
+	}
+	c := memCompare(v, bound)
+	switch op {
+	case "$gt":
+		return c > 0
+	case "$gte":
+		return c >= 0
+	case "$lt":
+		return c < 0
+	}
+	return c <= 0
+}
+
+// memTypeRank orders values of different types the way Mongo sorts them.
+func memTypeRank(v interface{}) int {
+	switch memScalar(v).(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	case bson.M:
+		return 3
+	case primitive.A:
+		return 4
+	case primitive.ObjectID:
+		return 5
+	case bool:
+		return 6
+	case primitive.DateTime:
+		return 7
+	}
+	return 8
+}
+
+// memCompare orders two values: by type first, then by value.
+func memCompare(a, b interface{}) int {
+	a, b = memScalar(a), memScalar(b)
+	if ra, rb := memTypeRank(a), memTypeRank(b); ra != rb {
+		return ra - rb
+	}
+	switch av := a.(type) {
+	case int64:
+		return memCompareFloat(float64(av), memFloat(b))
+	case float64:
+		return memCompareFloat(av, memFloat(b))
+	case string:
+		return strings.Compare(av, b.(string))
+	case primitive.ObjectID:
+		bv := b.(primitive.ObjectID)
+		return bytes.Compare(av[:], bv[:])
+	case bool:
+		bv := b.(bool)
+		if av == bv {
+			return 0
+		}
+		if !av {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return memCompareFloat(float64(av), float64(b.(primitive.DateTime)))
+	case nil:
+		return 0
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return 1
+}
+
+func memFloat(v interface{}) float64 {
+	if i, ok := v.(int64); ok {
+		return float64(i)
+	}
+	f, _ := v.(float64)
+	return f
+}
+
+func memCompareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// memSort sorts docs by field then _id, both in the same direction. Missing values sort
+// first, as in Mongo.
+func memSort(docs []bson.M, field string, desc bool) {
+	slices.SortStableFunc(docs, func(a, b bson.M) int {
+		c := 0
+		if field != "_id" {
+			c = memCompare(a[field], b[field])
+		}
+		if c == 0 {
+			c = memCompare(a["_id"], b["_id"])
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
+
+type memoryUserStore struct{ db *memoryDB }
+
+func (s memoryUserStore) Get(ctx context.Context, id string) (*User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc := s.db.get("users", oid)
+	if doc == nil {
+		return nil, errors.New("not found")
+	}
+	u := decodeUserListDoc(doc)
+	u.Password, _ = doc["password"].(string)
+	return &u, nil
+}
+
+func (s memoryUserStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs := s.db.find("users", bson.M{"username": username, "deleted": bson.M{"$ne": true}})
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	u := decodeUserListDoc(docs[0])
+	u.Password, _ = docs[0]["password"].(string)
+	return &u, nil
+}
+
+func (s memoryUserStore) Create(ctx context.Context, u *User) (*User, error) {
+	oid := primitive.NewObjectID()
+	s.db.mu.Lock()
+	s.db.put("users", bson.M{"_id": oid, "name": u.Name, "username": u.Username, "password": u.Password, "role": string(u.Role)})
+	s.db.mu.Unlock()
+	return s.Get(ctx, oid.Hex())
+}
+
+func (s memoryUserStore) SetPassword(ctx context.Context, id, hash string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc := s.db.get("users", oid)
+	if doc == nil {
+		return mongo.ErrNoDocuments
+	}
+	doc["password"] = hash
+	s.db.put("users", doc)
+	return nil
+}
+
+func (s memoryUserStore) ListByRole(ctx context.Context, role UserRole) ([]User, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	res := []User{}
+	for _, doc := range s.db.find("users", bson.M{"role": string(role), "deleted": bson.M{"$ne": true}}) {
+		res = append(res, decodeUserListDoc(doc))
+	}
+	return res, nil
+}
+
+func (s memoryUserStore) Page(ctx context.Context, q *listQuery) ([]User, string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs, next := s.db.page("users", bson.M{"deleted": bson.M{"$ne": true}}, q)
+	res := []User{}
+	for _, doc := range docs {
+		res = append(res, decodeUserListDoc(doc))
+	}
+	return res, next, nil
+}
+
+type memoryPersonStore struct{ db *memoryDB }
+
+func (s memoryPersonStore) Get(ctx context.Context, id string) (*Person, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.get(oid)
+}
+
+// get must be called with the lock held.
+func (s memoryPersonStore) get(oid primitive.ObjectID) (*Person, error) {
+	doc := s.db.get("people", oid)
+	if doc == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return decodePersonListDoc(s.db.withOwners(doc)), nil
+}
+
+func (s memoryPersonStore) List(ctx context.Context, ownedBy []string) ([]*Person, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	res := []*Person{}
+	for _, doc := range s.db.find("people", memLegacyScope(ownedBy)) {
+		res = append(res, decodePersonListDoc(s.db.withRelationships(s.db.withOwners(doc))))
+	}
+	return res, nil
+}
+
+func (s memoryPersonStore) Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Person, string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs, next := s.db.page("people", memScope(ownedBy), q)
+	res := []*Person{}
+	for _, doc := range docs {
+		if q.Include["owners"] {
+			doc = s.db.withOwners(doc)
+		}
+		if q.Include["relationships"] {
+			doc = s.db.withRelationships(doc)
+		}
+		res = append(res, decodePersonListDoc(doc))
+	}
+	return res, next, nil
+}
+
+// Search stands in for the text index with whole-word matches on the normalized name,
+// nickname and address; the searchKeys half works as in Mongo.
+func (s memoryPersonStore) Search(ctx context.Context, ownedBy []string, text string, keys []string, minHits int) ([]searchCandidate, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	queryWords := strings.Fields(text)
+	res := []searchCandidate{}
+	for _, doc := range s.db.find("people", memScope(ownedBy)) {
+		p := decodePersonListDoc(doc)
+		words := strings.Fields(normalizeName(p.Name + " " + p.Nickname + " " + p.Address))
+		textMatch := slices.ContainsFunc(queryWords, func(w string) bool { return slices.Contains(words, w) })
+		hits := 0
+		for _, k := range searchKeysOf(doc) {
+			if slices.Contains(keys, k) {
+				hits++
+			}
+		}
+		if textMatch || hits >= minHits {
+			res = append(res, searchCandidate{person: p, textMatch: textMatch})
+		}
+	}
+	return res, nil
+}
+
+func (s memoryPersonStore) Create(ctx context.Context, p *Person) (*Person, error) {
+	oid := primitive.NewObjectID()
+	doc := personFields(p)
+	doc["_id"] = oid
+	doc["version"] = 1
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.db.track("")
+	t.watch("people", oid)
+	s.db.put("people", doc)
+	t.record(ctx)
+	return s.get(oid)
+}
+
+func (s memoryPersonStore) Update(ctx context.Context, p *Person) (*Person, error) {
+	oid, err := primitive.ObjectIDFromHex(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc, ok := s.db.docs["people"][oid]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	if versionOf(doc) != p.Version {
+		return nil, errVersionConflict
+	}
+	t := s.db.track("")
+	t.watch("people", oid)
+	s.db.update("people", oid, personFields(p))
+	t.record(ctx)
+	return decodePersonListDoc(memCopy(doc)), nil
+}
+
+func (s memoryPersonStore) Delete(ctx context.Context, id string, relationshipIDs, familyIDs []string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.db.track("")
+	t.watch("people", oid)
+	t.watch("relationships", objectIDs(relationshipIDs)...)
+	t.watch("families", objectIDs(familyIDs)...)
+	if !s.db.softDelete("people", oid, now) {
+		return mongo.ErrNoDocuments
+	}
+	for _, rid := range objectIDs(relationshipIDs) {
+		s.db.softDelete("relationships", rid, now)
+	}
+	for _, fid := range objectIDs(familyIDs) {
+		s.db.softDelete("families", fid, now)
+	}
+	t.record(ctx)
+	return nil
+}
+
+func (s memoryPersonStore) OwnedIDs(ctx context.Context, ownedBy []string) ([]string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.db.ownedPeople(ownedBy), nil
+}
+
+type memoryFamilyStore struct{ db *memoryDB }
+
+func (s memoryFamilyStore) Get(ctx context.Context, id string) (*Family, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.get(oid)
+}
+
+// get must be called with the lock held.
+func (s memoryFamilyStore) get(oid primitive.ObjectID) (*Family, error) {
+	doc := s.db.get("families", oid)
+	if doc == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return decodeFamilyDoc(s.db.withPersonDetails(doc)), nil
+}
+
+func (s memoryFamilyStore) List(ctx context.Context, ownedBy []string) ([]*Family, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	res := []*Family{}
+	for _, doc := range s.db.find("families", memLegacyScope(ownedBy)) {
+		res = append(res, decodeFamilyDoc(s.db.withPersonDetails(doc)))
+	}
+	return res, nil
+}
+
+func (s memoryFamilyStore) Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Family, string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs, next := s.db.page("families", memScope(ownedBy), q)
+	res := []*Family{}
+	for _, doc := range docs {
+		if q.Include["person"] {
+			doc = s.db.withPersonDetails(doc)
+		}
+		f := decodeFamilyDoc(doc)
+		if pid, ok := doc["person"].(primitive.ObjectID); ok && f.Person == nil {
+			f.Person = &Person{ID: pid.Hex()}
+		}
+		res = append(res, f)
+	}
+	return res, next, nil
+}
+
+func (s memoryFamilyStore) ListByPerson(ctx context.Context, personID string) ([]*Family, error) {
+	oid, err := primitive.ObjectIDFromHex(personID)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	res := []*Family{}
+	for _, doc := range s.db.find("families", familiesByPersonFilter(oid)) {
+		res = append(res, decodeFamilyDoc(doc))
+	}
+	return res, nil
+}
+
+func (s memoryFamilyStore) Create(ctx context.Context, f *Family) (*Family, error) {
+	doc, err := familyFields(f)
+	if err != nil {
+		return nil, err
+	}
+	oid := primitive.NewObjectID()
+	doc["_id"] = oid
+	doc["version"] = 1
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.db.track("")
+	t.watch("families", oid)
+	s.db.put("families", doc)
+	t.record(ctx)
+	return s.get(oid)
+}
+
+func (s memoryFamilyStore) Update(ctx context.Context, f *Family) (*Family, error) {
+	oid, err := primitive.ObjectIDFromHex(f.ID)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := familyFields(f)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc := s.db.get("families", oid)
+	if doc == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	if versionOf(doc) != f.Version {
+		return nil, errVersionConflict
+	}
+	t := s.db.track("")
+	t.watch("families", oid)
+	s.db.update("families", oid, fields)
+	t.record(ctx)
+	return s.get(oid)
+}
+
+func (s memoryFamilyStore) Delete(ctx context.Context, id string) (*Family, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	out, err := s.get(oid)
+	if err != nil {
+		return nil, err
+	}
+	t := s.db.track("")
+	t.watch("families", oid)
+	s.db.softDelete("families", oid, time.Now())
+	t.record(ctx)
+	return out, nil
+}
+
+type memoryRelationshipStore struct{ db *memoryDB }
+
+func (s memoryRelationshipStore) ListByPerson(ctx context.Context, personID string) ([]*Relationship, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	forms := idForms(personID)
+	filter := bson.M{
+		"$or":     bson.A{bson.M{"from": bson.M{"$in": forms}}, bson.M{"to": bson.M{"$in": forms}}},
+		"deleted": bson.M{"$ne": true},
+	}
+	res := []*Relationship{}
+	for _, doc := range s.db.find("relationships", filter) {
+		doc["fromDetails"] = s.db.lookupPeople(doc["from"])
+		doc["toDetails"] = s.db.lookupPeople(doc["to"])
+		res = append(res, decodeRelationshipDoc(doc))
+	}
+	return res, nil
+}
+
+// Apply checks every update and delete against the stored versions before writing
+// anything, so a conflict leaves the batch unapplied like the Mongo transaction does.
+func (s memoryRelationshipStore) Apply(ctx context.Context, inserts, updates, deletes []Relationship) ([]RelationshipChange, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	changes := []RelationshipChange{}
+	docs := []bson.M{}
+	for _, r := range inserts {
+		fromOID, errFrom := primitive.ObjectIDFromHex(r.From)
+		toOID, errTo := primitive.ObjectIDFromHex(r.To)
+		if errFrom != nil || errTo != nil {
+			return nil, fmt.Errorf("invalid from or to ID")
+		}
+		oid := primitive.NewObjectID()
+		r.ID = oid.Hex()
+		r.Version = 1
+		docs = append(docs, relationshipDoc(r, oid, fromOID, toOID))
+		changes = append(changes, newRelationshipChange(r, "inserted"))
+	}
+	check := func(r Relationship, live bool) (primitive.ObjectID, error) {
+		oid, err := primitive.ObjectIDFromHex(r.ID)
+		if err != nil {
+			return oid, fmt.Errorf("invalid relationship ID")
+		}
+		doc, ok := s.db.docs["relationships"][oid]
+		if !ok || (live && doc["deleted"] == true) || versionOf(doc) != r.Version {
+			return oid, errVersionConflict
+		}
+		return oid, nil
+	}
+	updated := []primitive.ObjectID{}
+	for _, r := range updates {
+		oid, err := check(r, false)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, oid)
+		r.Version++
+		changes = append(changes, newRelationshipChange(r, "updated"))
+	}
+	deleted := []primitive.ObjectID{}
+	for _, r := range deletes {
+		oid, err := check(r, true)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, oid)
+		r.Version++
+		changes = append(changes, newRelationshipChange(r, "deleted"))
+	}
+
+	t := s.db.track("")
+	for _, doc := range docs {
+		t.watch("relationships", doc["_id"].(primitive.ObjectID))
+	}
+	t.watch("relationships", updated...)
+	t.watch("relationships", deleted...)
+	for _, doc := range docs {
+		s.db.put("relationships", doc)
+	}
+	for i, oid := range updated {
+		r := updates[i]
+		s.db.update("relationships", oid, bson.M{"order": r.Order, "subtype": r.Subtype})
+		if r.Marriage != nil {
+			s.db.docs["relationships"][oid]["marriage"] = memCopy(marriageDoc(r.Marriage))
+		} else {
+			delete(s.db.docs["relationships"][oid], "marriage")
+		}
+	}
+	now := time.Now()
+	for _, oid := range deleted {
+		s.db.softDelete("relationships", oid, now)
+	}
+	t.record(ctx)
+	return changes, nil
+}
+
+type memoryMergeStore struct{ db *memoryDB }
+
+// Apply makes the same writes as mergePeopleRepo, under the lock instead of a transaction.
+func (s memoryMergeStore) Apply(ctx context.Context, log *MergeLog, ownedBy []string) error {
+	logDoc, err := mergeLogDoc(log)
+	if err != nil {
+		return err
+	}
+	winner, loser := logDoc["winner"].(primitive.ObjectID), logDoc["loser"].(primitive.ObjectID)
+	families := map[primitive.ObjectID]bson.M{}
+	for _, f := range log.FamiliesBefore {
+		oid, err := primitive.ObjectIDFromHex(f.ID)
+		if err != nil {
+			return fmt.Errorf("invalid family ID")
+		}
+		if families[oid], err = mergedFamilyFields(mergeFamilyPeople(f, log.Loser, log.Winner)); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	rels := map[primitive.ObjectID]bson.M{}
+	for _, r := range log.Repointed {
+		oid, err := primitive.ObjectIDFromHex(r.ID)
+		if err != nil {
+			return fmt.Errorf("invalid relationship ID")
+		}
+		rels[oid] = bson.M{}
+		if r.From == log.Loser {
+			rels[oid]["from"] = winner
+		}
+		if r.To == log.Loser {
+			rels[oid]["to"] = winner
+		}
+	}
+	for _, oid := range objectIDs(relationshipIDs(log.Removed)) {
+		rels[oid] = bson.M{"deleted": true, "deletedAt": now}
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.watch(log)
+	for oid, set := range rels {
+		s.db.update("relationships", oid, set)
+	}
+	for oid, set := range families {
+		s.db.update("families", oid, set)
+	}
+	s.db.update("people", winner, bson.M{"ownedBy": objectIDs(ownedBy)})
+	s.db.update("people", loser, bson.M{"deleted": true, "deletedAt": now, "mergedInto": winner})
+	oid := primitive.NewObjectID()
+	logDoc["_id"] = oid
+	s.db.put("merges", logDoc)
+	log.ID = oid.Hex()
+	t.record(ctx)
+	return nil
+}
+
+func (s memoryMergeStore) Get(ctx context.Context, id string) (*MergeLog, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc, ok := s.db.docs["merges"][oid]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return decodeMergeLog(memCopy(doc)), nil
+}
+
+// Revert makes the same writes as revertMergeRepo.
+func (s memoryMergeStore) Revert(ctx context.Context, log *MergeLog) error {
+	mergeOID, err := primitive.ObjectIDFromHex(log.ID)
+	if err != nil {
+		return err
+	}
+	logDoc, err := mergeLogDoc(log)
+	if err != nil {
+		return err
+	}
+	winner, loser := logDoc["winner"].(primitive.ObjectID), logDoc["loser"].(primitive.ObjectID)
+	families := map[primitive.ObjectID]bson.M{}
+	for _, f := range log.FamiliesBefore {
+		oid, err := primitive.ObjectIDFromHex(f.ID)
+		if err != nil {
+			return fmt.Errorf("invalid family ID")
+		}
+		if families[oid], err = mergedFamilyFields(f); err != nil {
+			return err
+		}
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.watch(log)
+	for _, doc := range relationshipLogDocs(log.Repointed) {
+		d := doc.(bson.M)
+		if oid, ok := d["_id"].(primitive.ObjectID); ok {
+			s.db.update("relationships", oid, bson.M{"from": d["from"], "to": d["to"]})
+		}
+	}
+	for _, oid := range objectIDs(relationshipIDs(log.Removed)) {
+		s.db.restore("relationships", oid)
+	}
+	if len(families) > 0 {
+		for oid, set := range families {
+			s.db.update("families", oid, set)
+		}
+	} else {
+		for _, oid := range objectIDs(log.Families) {
+			s.db.update("families", oid, bson.M{"person": loser})
+		}
+	}
+	s.db.update("people", winner, bson.M{"ownedBy": objectIDs(log.WinnerOwnedBy)})
+	s.db.restore("people", loser)
+	s.db.unset("people", loser, "mergedInto")
+	if doc, ok := s.db.docs["merges"][mergeOID]; ok {
+		doc["revertedAt"] = primitive.NewDateTimeFromTime(time.Now())
+	}
+	t.record(ctx)
+	return nil
+}
+
+// watch snapshots what a merge or its revert touches, like watchMergeRepo.
+func (s memoryMergeStore) watch(log *MergeLog) *memoryTracker {
+	reason := "merge"
+	if log.ID != "" {
+		reason = "merge-revert:" + log.ID
+	}
+	t := s.db.track(reason)
+	t.watch("people", objectIDs([]string{log.Winner, log.Loser})...)
+	t.watch("relationships", objectIDs(relationshipIDs(append(append([]Relationship{}, log.Repointed...), log.Removed...)))...)
+	t.watch("families", objectIDs(log.Families)...)
+	return t
+}
+
+type memoryTrashStore struct{ db *memoryDB }
+
+func (s memoryTrashStore) List(ctx context.Context, ownedBy []string) (*Trash, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	filters := trashFilters(ownedBy, s.db.ownedPeople(ownedBy))
+	deleted := func(collection string) []bson.M {
+		docs := s.db.find(collection, filters[collection])
+		memSort(docs, "deletedAt", true)
+		return docs
+	}
+	trash := &Trash{People: []*Person{}, Families: []*Family{}, Relationships: []*Relationship{}}
+	for _, doc := range deleted("people") {
+		trash.People = append(trash.People, trashPerson(decodeM[personDocument](doc)))
+	}
+	for _, doc := range deleted("families") {
+		trash.Families = append(trash.Families, trashFamily(decodeM[familyDocument](doc)))
+	}
+	for _, doc := range deleted("relationships") {
+		trash.Relationships = append(trash.Relationships, decodeM[relationshipDocument](doc).relationship())
+	}
+	return trash, nil
+}
+
+// Restore follows restorePersonRepo, restoreFamilyRepo and restoreRelationshipRepo.
+func (s memoryTrashStore) Restore(ctx context.Context, kind, id string, ownedBy []string) (*TrashRestore, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	filter := bson.M{"_id": oid, "deleted": true}
+	if len(ownedBy) > 0 && kind != "relationships" {
+		filter["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	docs := s.db.find(kind, filter)
+	if len(docs) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	doc := docs[0]
+	restored := &TrashRestore{People: []string{}, Families: []string{}, Relationships: []string{}}
+	switch kind {
+	case "people":
+		restored.People = append(restored.People, id)
+		if deletedAt := deletedAtOf(doc); deletedAt != nil {
+			edges := bson.M{"deleted": true, "deletedAt": *deletedAt, "$or": bson.A{bson.M{"from": bson.M{"$in": idForms(id)}}, bson.M{"to": bson.M{"$in": idForms(id)}}}}
+			for _, r := range s.db.find("relationships", edges) {
+				other := hexID(r["to"])
+				if other == id {
+					other = hexID(r["from"])
+				}
+				if s.db.live(other) {
+					restored.Relationships = append(restored.Relationships, hexID(r["_id"]))
+				}
+			}
+			founded := bson.M{"deleted": true, "deletedAt": *deletedAt, "$or": bson.A{bson.M{"person": oid}, bson.M{"founders.person": oid}, bson.M{"founders.spouse": oid}}}
+			for _, fd := range s.db.find("families", founded) {
+				f := decodeM[familyDocument](fd).family()
+				if !slices.ContainsFunc(f.founderIDs(), func(p string) bool { return p != id && !s.db.live(p) }) {
+					restored.Families = append(restored.Families, f.ID)
+				}
+			}
+		}
+	case "families":
+		if slices.ContainsFunc(decodeM[familyDocument](doc).family().founderIDs(), func(p string) bool { return !s.db.live(p) }) {
+			return nil, errTrashDependencyDeleted
+		}
+		restored.Families = append(restored.Families, id)
+	case "relationships":
+		from, to := hexID(doc["from"]), hexID(doc["to"])
+		if len(ownedBy) > 0 {
+			owned := s.db.ownedPeople(ownedBy)
+			if !slices.Contains(owned, from) && !slices.Contains(owned, to) {
+				return nil, mongo.ErrNoDocuments
+			}
+		}
+		if !s.db.live(from) || !s.db.live(to) {
+			return nil, errTrashDependencyDeleted
+		}
+		restored.Relationships = append(restored.Relationships, id)
+		typ, _ := doc["type"].(string)
+		if invType := inverseRelationshipType(typ); invType != "" {
+			inverse := bson.M{"from": bson.M{"$in": idForms(to)}, "to": bson.M{"$in": idForms(from)}, "type": invType, "deleted": true}
+			if deletedAt := deletedAtOf(doc); deletedAt != nil {
+				inverse["deletedAt"] = *deletedAt
+			}
+			if inv := s.db.find("relationships", inverse); len(inv) > 0 {
+				restored.Relationships = append(restored.Relationships, hexID(inv[0]["_id"]))
+			}
+		}
+	default:
+		return nil, errUnknownTrashKind
+	}
+
+	t := s.db.track("")
+	for collection, ids := range map[string][]string{"people": restored.People, "families": restored.Families, "relationships": restored.Relationships} {
+		t.watch(collection, objectIDs(ids)...)
+		for _, oid := range objectIDs(ids) {
+			s.db.restore(collection, oid)
+		}
+	}
+	t.record(ctx)
+	return restored, nil
+}
+
+// Purge removes a soft-deleted document and, for a person, their soft-deleted edges.
+func (s memoryTrashStore) Purge(ctx context.Context, kind, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if doc, ok := s.db.docs[kind][oid]; !ok || doc["deleted"] != true {
+		return mongo.ErrNoDocuments
+	}
+	held := s.db.mergeHeld()
+	if slices.Contains(held[kind], id) {
+		return errTrashMergeHeld
+	}
+	t := s.db.track("")
+	t.watch(kind, oid)
+	delete(s.db.docs[kind], oid)
+	if kind == "people" {
+		edges := bson.M{"_id": bson.M{"$nin": objectIDs(held["relationships"])}, "deleted": true, "$or": bson.A{bson.M{"from": bson.M{"$in": idForms(id)}}, bson.M{"to": bson.M{"$in": idForms(id)}}}}
+		for _, r := range s.db.find("relationships", edges) {
+			rid := r["_id"].(primitive.ObjectID)
+			t.watch("relationships", rid)
+			delete(s.db.docs["relationships"], rid)
+		}
+	}
+	t.record(ctx)
+	return nil
+}
+
+// PurgeExpired keeps what unreverted merges need, like purgeExpiredTrashRepo.
+func (s memoryTrashStore) PurgeExpired(ctx context.Context, before time.Time) (map[string]int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	held := s.db.mergeHeld()
+	counts := map[string]int64{}
+	t := s.db.track("")
+	for _, name := range trashCollections {
+		filter := bson.M{"deleted": true, "deletedAt": bson.M{"$lt": before}, "_id": bson.M{"$nin": objectIDs(held[name])}}
+		for _, doc := range s.db.find(name, filter) {
+			oid := doc["_id"].(primitive.ObjectID)
+			t.watch(name, oid)
+			delete(s.db.docs[name], oid)
+			counts[name]++
+		}
+	}
+	t.record(ctx)
+	return counts, nil
+}
+
+// mergeHeld is the memory version of mergeHeldDocsRepo. The caller holds the lock.
+func (db *memoryDB) mergeHeld() map[string][]string {
+	held := map[string][]string{}
+	for _, m := range db.find("merges", bson.M{"revertedAt": bson.M{"$exists": false}}) {
+		addMergeHeld(held, m)
+	}
+	return held
+}
+
+type memoryHistoryStore struct{ db *memoryDB }
+
+func (s memoryHistoryStore) List(ctx context.Context, collection, id string) ([]*Revision, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs := s.db.find("revisions", bson.M{"collection": collection, "documentId": oid})
+	memSort(docs, "changedAt", true)
+	revs := []*Revision{}
+	for _, doc := range docs {
+		revs = append(revs, decodeRevision(doc))
+	}
+	return revs, nil
+}
+
+func (s memoryHistoryStore) Get(ctx context.Context, id string) (*Revision, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	doc, ok := s.db.docs["revisions"][oid]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return decodeRevision(memCopy(doc)), nil
+}
+
+// Revert replaces the document like revertToRevisionRepo, recreating it if it was purged.
+func (s memoryHistoryStore) Revert(ctx context.Context, rev *Revision, version int) error {
+	oid, err := primitive.ObjectIDFromHex(rev.DocumentID)
+	if err != nil {
+		return err
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	current := s.db.docs[rev.Collection][oid]
+	if err := checkRevertable(current, version); err != nil {
+		return err
+	}
+	t := s.db.track("revert:" + rev.ID)
+	t.watch(rev.Collection, oid)
+	doc := revertedDoc(rev.After, current)
+	if rev.Collection == "people" {
+		doc["searchKeys"] = searchKeysOf(doc)
+	}
+	if rev.Collection == "relationships" {
+		typ, _ := current["type"].(string)
+		if invType := inverseRelationshipType(typ); invType != "" {
+			inverse := bson.M{"from": bson.M{"$in": idForms(hexID(current["to"]))}, "to": bson.M{"$in": idForms(hexID(current["from"]))}, "type": invType, "deleted": bson.M{"$ne": true}}
+			if inv := s.db.find("relationships", inverse); len(inv) > 0 {
+				invOID := inv[0]["_id"].(primitive.ObjectID)
+				t.watch("relationships", invOID)
+				set, unset := inverseRevertFields(rev.After)
+				s.db.update("relationships", invOID, set)
+				s.db.unset("relationships", invOID, unset...)
+			}
+		}
+	}
+	s.db.put(rev.Collection, doc)
+	t.record(ctx)
+	return nil
+}
+
+func (s memoryHistoryStore) PeopleBefore(ctx context.Context, at time.Time) (map[string]*Person, error) {
+	return statesBefore(s.stateBeforeChanges("people", at), decodePersonListDoc), nil
+}
+
+func (s memoryHistoryStore) RelationshipsBefore(ctx context.Context, at time.Time) (map[string]*Relationship, error) {
+	return statesBefore(s.stateBeforeChanges("relationships", at), decodeRelationshipDoc), nil
+}
+
+// stateBeforeChanges is the memory version of stateBeforeChangesRepo.
+func (s memoryHistoryStore) stateBeforeChanges(collection string, at time.Time) map[string]bson.M {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	docs := s.db.find("revisions", bson.M{"collection": collection, "changedAt": bson.M{"$gt": at}})
+	memSort(docs, "changedAt", false)
+	states := map[string]bson.M{}
+	for _, doc := range docs {
+		id := hexID(doc["documentId"])
+		if _, seen := states[id]; !seen {
+			before, _ := doc["before"].(bson.M)
+			states[id] = before
+		}
+	}
+	return states
+}
+
+type memoryAuditStore struct{ db *memoryDB }
+
+func (s memoryAuditStore) Scan(ctx context.Context) ([]auditPerson, []auditRelationship, []auditFamily, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	people := []auditPerson{}
+	for _, doc := range s.db.find("people", bson.M{}) {
+		people = append(people, auditPersonOf(decodeM[personDocument](doc)))
+	}
+	rels := []auditRelationship{}
+	for _, doc := range s.db.find("relationships", bson.M{"deleted": bson.M{"$ne": true}}) {
+		rels = append(rels, auditRelationshipOf(doc))
+	}
+	families := []auditFamily{}
+	for _, doc := range s.db.find("families", bson.M{"deleted": bson.M{"$ne": true}}) {
+		families = append(families, auditFamilyOf(decodeM[familyDocument](doc)))
+	}
+	return people, rels, families, nil
+}
+
+func (s memoryAuditStore) ConvertIDs(ctx context.Context, rels []Relationship) error {
+	sets := map[primitive.ObjectID]bson.M{}
+	for _, r := range rels {
+		oid, errID := primitive.ObjectIDFromHex(r.ID)
+		fromOID, errFrom := primitive.ObjectIDFromHex(r.From)
+		toOID, errTo := primitive.ObjectIDFromHex(r.To)
+		if errID != nil || errFrom != nil || errTo != nil {
+			return fmt.Errorf("invalid relationship %s", r.ID)
+		}
+		sets[oid] = bson.M{"from": fromOID, "to": toOID}
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	t := s.db.track("audit")
+	for oid, set := range sets {
+		t.watch("relationships", oid)
+		s.db.update("relationships", oid, set)
+	}
+	t.record(ctx)
+	return nil
+}
+
+type memorySecurityLogStore struct{ db *memoryDB }
+
+func (s memorySecurityLogStore) Insert(ctx context.Context, ev *SecurityEvent) error {
+	doc := securityEventDoc(ev)
+	oid := primitive.NewObjectID()
+	doc["_id"] = oid
+	s.db.mu.Lock()
+	s.db.put("securityLog", doc)
+	s.db.mu.Unlock()
+	ev.ID = oid.Hex()
+	return nil
+}
+
+// Each copies the matching entries before calling fn, so fn runs without the lock.
+func (s memorySecurityLogStore) Each(ctx context.Context, f SecurityLogFilter, limit int, fn func(*SecurityEvent) error) error {
+	s.db.mu.Lock()
+	docs := s.db.find("securityLog", securityLogQuery(f))
+	s.db.mu.Unlock()
+	memSort(docs, "at", true)
+	if limit > 0 && len(docs) > limit {
+		docs = docs[:limit]
+	}
+	for _, doc := range docs {
+		if err := fn(decodeSecurityEvent(doc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DuplicateCandidate is a pair of people that may be the same person entered twice.
+type DuplicateCandidate struct {
+	A       *Person  `json:"a"`
+	B       *Person  `json:"b"`
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+}
+
+// MergeLog records what a person merge changed so it can be reverted.
+type MergeLog struct {
+	ID         string     `json:"_id"`
+	Winner     string     `json:"winner"`
+	Loser      string     `json:"loser"`
+	MergedBy   string     `json:"mergedBy"`
+	MergedAt   time.Time  `json:"mergedAt"`
+	RevertedAt *time.Time `json:"revertedAt,omitempty"`
+	// WinnerOwnedBy is the winner's ownership before the merge
+	WinnerOwnedBy []string `json:"winnerOwnedBy"`
+	// Repointed holds the loser's edges as they were before being moved to the winner
+	Repointed []Relationship `json:"repointed"`
+	// Removed holds the loser's edges soft-deleted as duplicates or self links
+	Removed []Relationship `json:"removed"`
+	// Families lists the families re-targeted from the loser to the winner
+	Families []string `json:"families"`
+	// FamiliesBefore holds the founders and members of those families before the merge.
+	// Logs from before it was recorded only had their person re-targeted.
+	FamiliesBefore []MergedFamily `json:"familiesBefore"`
+}
+
+// MergedFamily is who founds a family and, for an explicit family, who belongs to it.
+type MergedFamily struct {
+	ID       string         `json:"_id"`
+	Founders []FamilyCouple `json:"founders"`
+	Members  []string       `json:"members"`
+}
+
+var (
+	errMergeSamePerson = errors.New("cannot merge a person with themselves")
+	errMergeReverted   = errors.New("merge already reverted")
+)
+
+// relativeIDs returns the people p is directly related to.
+func relativeIDs(p *Person) map[string]struct{} {
+	out := map[string]struct{}{}
+	for _, r := range p.Relationships {
+		if r.From == p.ID {
+			out[r.To] = struct{}{}
+		} else {
+			out[r.From] = struct{}{}
+		}
+	}
+	return out
+}
+
+// scoreDuplicate scores how likely a and b are the same person, from 0 to 1, and explains
+// why. People of different genders are never duplicates.
+func scoreDuplicate(a, b *Person) (float64, []string) {
+	if a.Gender != "" && b.Gender != "" && a.Gender != b.Gender {
+		return 0, nil
+	}
+	score := 0.0
+	reasons := []string{}
+
+	nameA, nameB := normalizeName(a.Name), normalizeName(b.Name)
+	if sim := nameSimilarity(nameA, nameB); sim > 0 {
+		score += 0.4 * sim
+		if sim == 1 {
+			reasons = append(reasons, "same name")
+		} else if sim >= 0.6 {
+			reasons = append(reasons, "similar name")
+		}
+	}
+	nickA, nickB := normalizeName(a.Nickname), normalizeName(b.Nickname)
+	if nickA != "" && nickA == nickB {
+		score += 0.15
+		reasons = append(reasons, "same nickname")
+	} else if nickA != "" && (nickA == nameB || nickB == nameA) {
+		score += 0.1
+		reasons = append(reasons, "nickname matches name")
+	}
+
+	if !a.BirthDate.IsZero() && !b.BirthDate.IsZero() {
+		switch {
+		case a.BirthDate.Format("2006-01-02") == b.BirthDate.Format("2006-01-02"):
+			score += 0.25
+			reasons = append(reasons, "same birth date")
+		case a.BirthDate.Year() == b.BirthDate.Year():
+			score += 0.1
+			reasons = append(reasons, "same birth year")
+		default:
+			score -= 0.2
+		}
+	}
+
+	shared := 0
+	relA, relB := relativeIDs(a), relativeIDs(b)
+	for id := range relA {
+		if _, ok := relB[id]; ok {
+			shared++
+		}
+	}
+	if shared > 0 {
+		score += min(0.2, 0.1*float64(shared))
+		reasons = append(reasons, fmt.Sprintf("%d shared relatives", shared))
+	}
+
+	if score < 0 {
+		score = 0
+	}
+	return min(score, 1), reasons
+}
+
+// findDuplicatePeople scores candidate pairs among the people visible to ownedBy (all
+// people when empty) and returns those scoring at least minScore, best first.
+func findDuplicatePeople(ctx context.Context, ownedBy []string, minScore float64, limit int) ([]DuplicateCandidate, error) {
+	defer startSpan(ctx, "service/findDuplicatePeople").End()
+	people, err := storesFrom(ctx).People.List(ctx, ownedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[[2]int]bool{}
+	out := []DuplicateCandidate{}
+	for _, idxs := range duplicateBlocks(people) {
+		for x := 0; x < len(idxs); x++ {
+			for y := x + 1; y < len(idxs); y++ {
+				pair := [2]int{idxs[x], idxs[y]}
+				if seen[pair] {
+					continue
+				}
+				seen[pair] = true
+				a, b := people[pair[0]], people[pair[1]]
+				score, reasons := scoreDuplicate(a, b)
+				if score >= minScore {
+					out = append(out, DuplicateCandidate{A: personSummary(a), B: personSummary(b), Score: score, Reasons: reasons})
+				}
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].A.ID+out[i].B.ID < out[j].A.ID+out[j].B.ID
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// maxDuplicateBlock caps how many people one blocking key may group. A larger block comes
+// from a common name token, which costs a quadratic number of comparisons and says little
+// on its own; real duplicates usually share a rarer key as well.
+const maxDuplicateBlock = 200
+
+// duplicateBlocks groups people, by index, on name tokens, nickname and birth date so only
+// plausible pairs are scored. Blocks of one person or of more than maxDuplicateBlock
+// people are left out.
+func duplicateBlocks(people []*Person) map[string][]int {
+	blocks := map[string][]int{}
+	for i, p := range people {
+		keys := map[string]struct{}{}
+		for _, tok := range strings.Fields(normalizeName(p.Name)) {
+			keys["n:"+tok] = struct{}{}
+		}
+		if nick := normalizeName(p.Nickname); nick != "" {
+			keys["n:"+nick] = struct{}{}
+		}
+		if !p.BirthDate.IsZero() {
+			keys["b:"+p.BirthDate.Format("2006-01-02")] = struct{}{}
+		}
+		for k := range keys {
+			blocks[k] = append(blocks[k], i)
+		}
+	}
+	for k, idxs := range blocks {
+		if len(idxs) < 2 || len(idxs) > maxDuplicateBlock {
+			delete(blocks, k)
+		}
+	}
+	return blocks
+}
+
+// personSummary copies p without its populated relationships and owners.
+func personSummary(p *Person) *Person {
+	cp := *p
+	cp.Relationships = nil
+	cp.Owners = nil
+	return &cp
+}
+
+// mergePeople folds loser into winner: the loser's edges are re-pointed at the winner
+// (dropping those that would duplicate an existing edge or link the winner to
+// themselves), ownership is unioned, the winner takes the loser's place among the
+// founders and members of their families and the loser is soft-deleted. The returned log
+// can be passed to revertMerge.
+func mergePeople(ctx context.Context, winnerID, loserID, actorID string) (*MergeLog, error) {
+	defer startSpan(ctx, "service/mergePeople").End()
+	if winnerID == loserID {
+		return nil, errMergeSamePerson
+	}
+	winner, err := storesFrom(ctx).People.Get(ctx, winnerID)
+	if err != nil {
+		return nil, err
+	}
+	loser, err := storesFrom(ctx).People.Get(ctx, loserID)
+	if err != nil {
+		return nil, err
+	}
+	winnerRels, err := storesFrom(ctx).Relationships.ListByPerson(ctx, winnerID)
+	if err != nil {
+		return nil, err
+	}
+	loserRels, err := storesFrom(ctx).Relationships.ListByPerson(ctx, loserID)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &MergeLog{
+		Winner:         winnerID,
+		Loser:          loserID,
+		MergedBy:       actorID,
+		MergedAt:       time.Now(),
+		WinnerOwnedBy:  winner.OwnedBy,
+		Repointed:      []Relationship{},
+		Removed:        []Relationship{},
+		Families:       []string{},
+		FamiliesBefore: []MergedFamily{},
+	}
+
+	keys := map[string]bool{}
+	for _, r := range winnerRels {
+		keys[r.From+"_"+r.To+"_"+r.Type] = true
+	}
+	repoint := func(id string) string {
+		if id == loserID {
+			return winnerID
+		}
+		return id
+	}
+	for _, r := range loserRels {
+		edge := Relationship{ID: r.ID, From: r.From, To: r.To, Type: r.Type, Subtype: r.Subtype, Order: r.Order, Marriage: r.Marriage}
+		from, to := repoint(r.From), repoint(r.To)
+		key := from + "_" + to + "_" + r.Type
+		if from == to || keys[key] {
+			log.Removed = append(log.Removed, edge)
+			continue
+		}
+		keys[key] = true
+		log.Repointed = append(log.Repointed, edge)
+	}
+
+	families, err := storesFrom(ctx).Families.ListByPerson(ctx, loserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range families {
+		log.Families = append(log.Families, f.ID)
+		log.FamiliesBefore = append(log.FamiliesBefore, MergedFamily{ID: f.ID, Founders: f.Founders, Members: f.Members})
+	}
+
+	ownedBy := append([]string{}, winner.OwnedBy...)
+	for _, o := range loser.OwnedBy {
+		if !slices.Contains(ownedBy, o) {
+			ownedBy = append(ownedBy, o)
+		}
+	}
+
+	if err := storesFrom(ctx).Merges.Apply(ctx, log, ownedBy); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// mergeFamilyPeople puts winnerID in place of loserID among the founders and members of
+// f. A couple left with the winner on both sides keeps only the founder, and couples and
+// members the winner already had are not repeated.
+func mergeFamilyPeople(f MergedFamily, loserID, winnerID string) MergedFamily {
+	swap := func(id string) string {
+		if id == loserID {
+			return winnerID
+		}
+		return id
+	}
+	out := MergedFamily{ID: f.ID, Founders: []FamilyCouple{}, Members: []string{}}
+	for _, c := range f.Founders {
+		c = FamilyCouple{Person: swap(c.Person), Spouse: swap(c.Spouse)}
+		if c.Spouse == c.Person {
+			c.Spouse = ""
+		}
+		if !slices.Contains(out.Founders, c) {
+			out.Founders = append(out.Founders, c)
+		}
+	}
+	for _, m := range f.Members {
+		if m = swap(m); !slices.Contains(out.Members, m) {
+			out.Members = append(out.Members, m)
+		}
+	}
+	return out
+}
+
+// revertMerge undoes a recorded merge.
+func revertMerge(ctx context.Context, mergeID string) (*MergeLog, error) {
+	defer startSpan(ctx, "service/revertMerge").End()
+	log, err := storesFrom(ctx).Merges.Get(ctx, mergeID)
+	if err != nil {
+		return nil, err
+	}
+	if log.RevertedAt != nil {
+		return nil, errMergeReverted
+	}
+	if err := storesFrom(ctx).Merges.Revert(ctx, log); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	log.RevertedAt = &now
+	return log, nil
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDuplicateBlocksSkipCommonTokens(t *testing.T) {
+	people := make([]*Person, 0, maxDuplicateBlock+2)
+	for i := range maxDuplicateBlock {
+		people = append(people, &Person{Name: fmt.Sprintf("Muhammad %d", i)})
+	}
+	people = append(people, &Person{Name: "Muhammad Rizki"}, &Person{Name: "Muhammad Rizky", Nickname: "Rizki"})
+
+	blocks := duplicateBlocks(people)
+	if _, ok := blocks["n:muhammad"]; ok {
+		t.Fatal("the block shared by every person was kept")
+	}
+	for k, idxs := range blocks {
+		if len(idxs) > maxDuplicateBlock {
+			t.Fatalf("block %q has %d people, over the cap", k, len(idxs))
+		}
+	}
+	// the pair still meets through the rarer token
+	if got := blocks["n:rizki"]; len(got) != 2 || got[0] != maxDuplicateBlock || got[1] != maxDuplicateBlock+1 {
+		t.Fatalf("blocks[n:rizki] = %v, want both Rizkis", got)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want only n:rizki", len(blocks))
+	}
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus metrics of the app, in a registry of their own so that only
+// these and the Go runtime and process metrics are exported. The methods do nothing on a
+// nil *metrics, which is what the app has when metrics are off.
+type metrics struct {
+	registry  *prometheus.Registry
+	requests  *prometheus.HistogramVec
+	cache     *prometheus.CounterVec
+	mongo     *prometheus.HistogramVec
+	treeNodes prometheus.Histogram
+	treeBuild prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "familytree",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "familytree",
+			Name:      "cache_requests_total",
+			Help:      "Redis cache lookups, by kind of entry and hit or miss.",
+		}, []string{"cache", "result"}),
+		mongo: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "familytree",
+			Name:      "mongo_command_duration_seconds",
+			Help:      "Time taken by MongoDB commands, by command and outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"command", "outcome"}),
+		treeNodes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "familytree",
+			Name:      "tree_nodes",
+			Help:      "Number of people in the family trees built.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		treeBuild: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "familytree",
+			Name:      "tree_build_duration_seconds",
+			Help:      "Time taken to build a family tree.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.cache, m.mongo, m.treeNodes, m.treeBuild,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeRequest records a served request under its route pattern rather than its path,
+// so IDs don't each get their own series.
+func (m *metrics) observeRequest(c *gin.Context, took time.Duration) {
+	if m == nil {
+		return
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(took.Seconds())
+}
+
+// observeCache counts a lookup by the kind of entry, the part of the key after "ft:",
+// e.g. "person" for "ft:person:<id>".
+func (m *metrics) observeCache(key string, hit bool) {
+	if m == nil {
+		return
+	}
+	kind := strings.TrimPrefix(key, "ft:")
+	kind, _, _ = strings.Cut(kind, ":")
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cache.WithLabelValues(kind, result).Inc()
+}
+
+func (m *metrics) observeMongo(command string, took time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	m.mongo.WithLabelValues(command, outcome).Observe(took.Seconds())
+}
+
+func (m *metrics) observeTree(nodes int, took time.Duration) {
+	m.treeNodes.Observe(float64(nodes))
+	m.treeBuild.Observe(took.Seconds())
+}
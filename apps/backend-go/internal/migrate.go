@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// migration is a versioned change to stored data, run by the migrate subcommand. Applied
+// versions are recorded in the migrations collection so each runs once, but every
+// migration must also be safe to run again, e.g. after a crash between the change and the
+// record. Up returns the number of documents it changed.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) (int64, error)
+}
+
+// migrations is the ordered list of every migration. Append only: never renumber or edit
+// one that has shipped.
+var migrations = []migration{
+	{Version: 1, Name: "object-id-references", Up: convertLegacyIDsRepo},
+	{Version: 2, Name: "datetime-dates", Up: convertLegacyDatesRepo},
+}
+
+// MigrationStatus is a migration and, once applied, when and how many documents it changed.
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Changed   int64      `json:"changed"`
+}
+
+// ListMigrations reports every migration with its applied state.
+func ListMigrations(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := appliedMigrationsRepo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		if st, ok := applied[m.Version]; ok {
+			out = append(out, st)
+			continue
+		}
+		out = append(out, MigrationStatus{Version: m.Version, Name: m.Name})
+	}
+	return out, nil
+}
+
+// PendingMigrations counts the migrations not applied yet.
+func PendingMigrations(ctx context.Context) (int, error) {
+	statuses, err := ListMigrations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, st := range statuses {
+		if st.AppliedAt == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// RunMigrations applies the pending migrations in order, calling done after each one. It
+// stops at the first failure; the failed migration stays pending.
+func RunMigrations(ctx context.Context, done func(MigrationStatus)) error {
+	applied, err := appliedMigrationsRepo(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		changed, err := m.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		now := time.Now()
+		st := MigrationStatus{Version: m.Version, Name: m.Name, AppliedAt: &now, Changed: changed}
+		if err := recordMigrationRepo(ctx, st); err != nil {
+			return fmt.Errorf("record migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		done(st)
+	}
+	invalidateGraphCaches(ctx)
+	return nil
+}
@@ -9,6 +9,16 @@ const (
 	RoleUser  UserRole = "user"
 )
 
+// Parent/child relationship subtypes. Legacy documents without a subtype are biological.
+const (
+	SubtypeBiological = "biological"
+	SubtypeAdoptive   = "adoptive"
+	SubtypeStep       = "step"
+	SubtypeFoster     = "foster"
+	SubtypeGuardian   = "guardian"
+	SubtypeUnknown    = "unknown" // biological link not confirmed
+)
+
 type User struct {
 	ID       string   `json:"_id"`
 	Name     string   `json:"name"`
@@ -30,21 +40,71 @@ type Person struct {
 	OwnedBy       []string        `json:"ownedBy"`
 	Owners        []User          `json:"owners,omitempty"`
 	Relationships []*Relationship `json:"relationships,omitempty"`
+	DeletedAt     *time.Time      `json:"deletedAt,omitempty"`
+	Version       int             `json:"version"`
 }
 
+// Family membership rules.
+const (
+	MembershipDescendants = "descendants" // the founders, their descendants and everyone's spouses
+	MembershipExplicit    = "explicit"    // exactly the people in Members
+)
+
+// Family is a clan: one or more founding couples and the people who belong to it. Person is
+// the first founder, kept for clients that predate Founders.
 type Family struct {
-	ID      string   `json:"_id"`
-	Name    string   `json:"name"`
-	Person  *Person  `json:"person,omitempty"`
-	OwnedBy []string `json:"ownedBy"`
+	ID          string         `json:"_id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	CoverURL    string         `json:"coverUrl,omitempty"`
+	Person      *Person        `json:"person,omitempty"`
+	Founders    []FamilyCouple `json:"founders"`
+	Membership  string         `json:"membership"`
+	Members     []string       `json:"members,omitempty"`
+	OwnedBy     []string       `json:"ownedBy"`
+	DeletedAt   *time.Time     `json:"deletedAt,omitempty"`
+	Version     int            `json:"version"`
+}
+
+// FamilyCouple is a founding couple. Without a Spouse the family descends from every
+// partner of Person.
+type FamilyCouple struct {
+	Person string `json:"person"`
+	Spouse string `json:"spouse,omitempty"`
 }
 
 type Relationship struct {
-	ID          string  `json:"_id"`
-	From        string  `json:"from"`
-	To          string  `json:"to"`
-	Type        string  `json:"type"`
-	Order       int     `json:"order,omitempty"`
-	ToDetails   *Person `json:"toDetails,omitempty"`
-	FromDetails *Person `json:"fromDetails,omitempty"`
+	ID          string     `json:"_id"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Type        string     `json:"type"`
+	Subtype     string     `json:"subtype,omitempty"`
+	Order       int        `json:"order,omitempty"`
+	Marriage    *Marriage  `json:"marriage,omitempty"`
+	ToDetails   *Person    `json:"toDetails,omitempty"`
+	FromDetails *Person    `json:"fromDetails,omitempty"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+	Version     int        `json:"version"`
+}
+
+// RelationshipChange reports what happened to a single edge during a relationship upsert.
+type RelationshipChange struct {
+	ID       string    `json:"_id,omitempty"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Type     string    `json:"type"`
+	Subtype  string    `json:"subtype,omitempty"`
+	Order    int       `json:"order,omitempty"`
+	Marriage *Marriage `json:"marriage,omitempty"`
+	Version  int       `json:"version"` // the edge's version after the change
+	Action   string    `json:"action"`  // inserted, updated, deleted or unchanged
+}
+
+// Marriage is the metadata carried by a spouse relationship. Both sides of the pair hold
+// the same values.
+type Marriage struct {
+	Status    string     `json:"status,omitempty"` // married, divorced, widowed or partner
+	StartDate *time.Time `json:"startDate,omitempty"`
+	EndDate   *time.Time `json:"endDate,omitempty"`
+	Place     string     `json:"place,omitempty"`
 }
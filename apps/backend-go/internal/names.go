@@ -0,0 +1,67 @@
+package app
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeName lowercases a name, strips diacritics and collapses everything that isn't a
+// letter or digit into single spaces, so "  Sitï  Nurhaliza," and "siti nurhaliza" match.
+func normalizeName(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// combining mark left over from decomposition
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// nameBigrams returns the set of adjacent letter pairs of a normalized name.
+func nameBigrams(s string) map[string]struct{} {
+	out := map[string]struct{}{}
+	for _, word := range strings.Fields(s) {
+		runes := []rune(word)
+		if len(runes) == 1 {
+			out[word] = struct{}{}
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			out[string(runes[i:i+2])] = struct{}{}
+		}
+	}
+	return out
+}
+
+// nameSimilarity is the Dice coefficient of the bigrams of two normalized names, from 0
+// (nothing in common) to 1 (identical).
+func nameSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	ab, bb := nameBigrams(a), nameBigrams(b)
+	if len(ab)+len(bb) == 0 {
+		return 0
+	}
+	shared := 0
+	for g := range ab {
+		if _, ok := bb[g]; ok {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ab)+len(bb))
+}
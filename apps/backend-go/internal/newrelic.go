@@ -1,31 +1,28 @@
 package app
 
 import (
-	"os"
+	"context"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/newrelic/go-agent/v3/integrations/nrgin"
 	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
-// NRApp is the global New Relic application instance. It is set by InitNewRelic and used
-// by database/external-service instrumentation hooks throughout the application.
+// NRApp is the global New Relic application instance. It is set by InitNewRelic when New
+// Relic is the tracing backend.
 var NRApp *newrelic.Application
 
-// InitNewRelic initializes the new relic app if NEW_RELIC_APP_NAME and NEW_RELIC_LICENSE_KEY are set
-func InitNewRelic() (*newrelic.Application, error) {
-	appName := os.Getenv("NEW_RELIC_APP_NAME")
-	license := os.Getenv("NEW_RELIC_LICENSE_KEY")
-	enabled := os.Getenv("NEW_RELIC_ENABLED")
-
-	if license == "" || appName == "" {
-		return nil, nil
-	}
-	if enabled == "false" {
+// InitNewRelic initializes the new relic app if the app name and license key are set and
+// it isn't disabled
+func InitNewRelic(cfg NewRelicConfig) (*newrelic.Application, error) {
+	if cfg.LicenseKey == "" || cfg.AppName == "" || !cfg.Enabled {
 		return nil, nil
 	}
 
 	app, err := newrelic.NewApplication(
-		newrelic.ConfigAppName(appName),
-		newrelic.ConfigLicense(license),
+		newrelic.ConfigAppName(cfg.AppName),
+		newrelic.ConfigLicense(cfg.LicenseKey),
 		newrelic.ConfigDistributedTracerEnabled(true),
 	)
 	if err != nil {
@@ -34,3 +31,45 @@ func InitNewRelic() (*newrelic.Application, error) {
 	NRApp = app
 	return app, nil
 }
+
+// newRelicTracer reports spans as segments of the New Relic transaction of the request.
+// Outside a request there is no transaction and nothing is recorded.
+type newRelicTracer struct {
+	app *newrelic.Application
+}
+
+func (newRelicTracer) start(ctx context.Context, name string) span {
+	return startSpan(ctx, name)
+}
+
+func (newRelicTracer) startExternal(ctx context.Context, service, procedure, url string) span {
+	return &newrelic.ExternalSegment{
+		StartTime: newrelic.FromContext(ctx).StartSegmentNow(),
+		URL:       url,
+		Procedure: procedure,
+		Library:   service,
+	}
+}
+
+func (newRelicTracer) startDatastore(ctx context.Context, database, collection, command string) span {
+	return &newrelic.DatastoreSegment{
+		StartTime:    newrelic.FromContext(ctx).StartSegmentNow(),
+		Product:      newrelic.DatastoreMongoDB,
+		DatabaseName: database,
+		Collection:   collection,
+		Operation:    command,
+	}
+}
+
+func (t newRelicTracer) middleware() gin.HandlerFunc {
+	return nrgin.Middleware(t.app)
+}
+
+func (t newRelicTracer) shutdown(ctx context.Context) error {
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	t.app.Shutdown(timeout)
+	return nil
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelTracer exports OpenTelemetry traces over OTLP/HTTP. A request's trace continues the
+// one of the caller when it sends a W3C traceparent header.
+type otelTracer struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func newOTelTracer(ctx context.Context, cfg TelemetryConfig) (*otelTracer, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	return &otelTracer{
+		provider:   provider,
+		tracer:     provider.Tracer("github.com/fahmialfareza/family-tree/backend"),
+		propagator: propagation.TraceContext{},
+	}, nil
+}
+
+// spanStackKey holds the open spans of a request in its gin context. Handlers and stores
+// get the gin context rather than one derived from the span's, so this is how a span
+// finds its parent.
+const spanStackKey = "spanStack"
+
+type spanStack struct {
+	mu    sync.Mutex
+	spans []trace.Span
+}
+
+func (s *spanStack) top() trace.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spans[len(s.spans)-1]
+}
+
+func (s *spanStack) push(sp trace.Span) {
+	s.mu.Lock()
+	s.spans = append(s.spans, sp)
+	s.mu.Unlock()
+}
+
+// remove drops sp wherever it is, since spans of concurrent work don't end in order.
+func (s *spanStack) remove(sp trace.Span) {
+	s.mu.Lock()
+	if i := slices.Index(s.spans, sp); i > 0 {
+		s.spans = slices.Delete(s.spans, i, i+1)
+	}
+	s.mu.Unlock()
+}
+
+type otelSpan struct {
+	span  trace.Span
+	stack *spanStack
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+	if s.stack != nil {
+		s.stack.remove(s.span)
+	}
+}
+
+func (t *otelTracer) startWith(ctx context.Context, name string, opts ...trace.SpanStartOption) span {
+	parent := ctx
+	stack, _ := ctx.Value(spanStackKey).(*spanStack)
+	if stack != nil {
+		parent = trace.ContextWithSpan(ctx, stack.top())
+	}
+	_, sp := t.tracer.Start(parent, name, opts...)
+	if stack != nil {
+		stack.push(sp)
+	}
+	return otelSpan{span: sp, stack: stack}
+}
+
+func (t *otelTracer) start(ctx context.Context, name string) span {
+	return t.startWith(ctx, name)
+}
+
+func (t *otelTracer) startExternal(ctx context.Context, service, procedure, url string) span {
+	return t.startWith(ctx, service+"/"+procedure,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", url)),
+	)
+}
+
+// startDatastore only traces commands run for a request; the background jobs would
+// otherwise bury the request traces in one-span traces of their own.
+func (t *otelTracer) startDatastore(ctx context.Context, database, collection, command string) span {
+	if ctx.Value(spanStackKey) == nil && !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return noopSpan{}
+	}
+	return t.startWith(ctx, "mongo/"+command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "mongodb"),
+			attribute.String("db.namespace", database),
+			attribute.String("db.collection.name", collection),
+			attribute.String("db.operation.name", command),
+		),
+	)
+}
+
+func (t *otelTracer) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := t.propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		ctx, sp := t.tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+			),
+		)
+		defer sp.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(spanStackKey, &spanStack{spans: []trace.Span{sp}})
+
+		c.Next()
+
+		status := c.Writer.Status()
+		sp.SetAttributes(attribute.Int("http.response.status_code", status))
+		if id := c.GetString("requestId"); id != "" {
+			sp.SetAttributes(attribute.String("request.id", id))
+		}
+		if status >= 500 {
+			sp.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+func (t *otelTracer) shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
@@ -1,67 +1,123 @@
 package app
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 func getAllPeople(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/getAllPeople").End()
+	defer startSpan(c, "handler/getAllPeople").End()
 	u, _ := c.Get("user")
 	user := u.(*User)
+	if isListRequest(c, personListFilters...) {
+		listPeople(c, user)
+		return
+	}
 	if user.Role == RoleAdmin {
-		people, _ := repoGetAllPeople(c, nil)
+		people, _ := storesFrom(c).People.List(c, nil)
 		responseSuccess(c, people, 200)
 		return
 	}
-	people, _ := repoGetAllPeople(c, []string{user.ID})
+	people, _ := storesFrom(c).People.List(c, []string{user.ID})
 	responseSuccess(c, people, 200)
 }
 
+var personListSpec = listSpec{
+	sorts:    []string{"name", "nickname", "birthDate", "_id"},
+	includes: []string{"relationships", "owners"},
+	fields:   []string{"name", "nickname", "address", "status", "gender", "birthDate", "phone", "photoUrl", "ownedBy", "version"},
+}
+
+var personListFilters = []string{"status", "gender", "birthYearFrom", "birthYearTo", "owner"}
+
+// listPeople is the paged form of getAllPeople, used once any list parameter is present.
+func listPeople(c *gin.Context, user *User) {
+	v := &validator{}
+	q := parseListQuery(c, personListSpec, v)
+	q.filterIn(c, v, "status", "alive", "deceased")
+	q.filterIn(c, v, "gender", "male", "female")
+	q.filterYears(c, v, "birthYearFrom", "birthYearTo", "birthDate")
+	q.filterID(c, v, "owner", "ownedBy")
+	if !v.valid() {
+		responseInvalidQuery(c, v.errs)
+		return
+	}
+	var scope []string
+	if user.Role != RoleAdmin {
+		scope = []string{user.ID}
+	}
+	people, next, err := storesFrom(c).People.Page(c, scope, q)
+	if err != nil {
+		responseError(c, "Failed to fetch people", 500)
+		return
+	}
+	respondPage(c, q, people, next)
+}
+
+// searchPerson ranks the caller's people (everyone for admins) against q, tolerating
+// diacritics, typos and old Indonesian spellings. limit defaults to 20 (max 100).
+func searchPerson(c *gin.Context) {
+	defer startSpan(c, "handler/searchPerson").End()
+	v := &validator{}
+	q := strings.TrimSpace(c.Query("q"))
+	v.required("q", q)
+	limit := defaultSearchLimit
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			v.add("limit", "must be a positive integer")
+		} else {
+			limit = min(n, maxSearchLimit)
+		}
+	}
+	if !v.valid() {
+		responseInvalidQuery(c, v.errs)
+		return
+	}
+	u, _ := c.Get("user")
+	user := u.(*User)
+	var scope []string
+	if user.Role != RoleAdmin {
+		scope = []string{user.ID}
+	}
+	results, err := searchPeople(c, scope, q, limit)
+	if err != nil {
+		responseError(c, "Failed to search people", 500)
+		return
+	}
+	responseSuccess(c, results, 200)
+}
+
 func getPersonById(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/getPersonById").End()
+	defer startSpan(c, "handler/getPersonById").End()
 	id := c.Param("id")
-	p, err := getPersonByIdRepo(c, id)
+	p, err := storesFrom(c).People.Get(c, id)
 	if err != nil {
 		responseError(c, "Person not found", 404)
 		return
 	}
+	c.Header("ETag", etag(p.Version))
 	responseSuccess(c, p, 200)
 }
 
 func createPerson(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/createPerson").End()
+	defer startSpan(c, "handler/createPerson").End()
 	// accept multipart/form-data or json
-	name := c.PostForm("name")
-	nickname := c.PostForm("nickname")
-	address := c.PostForm("address")
-	status := c.PostForm("status")
-	gender := c.PostForm("gender")
-	birthDateStr := c.PostForm("birthDate")
-	phone := c.PostForm("phone")
-
-	if name == "" || nickname == "" || address == "" || (status != "alive" && status != "deceased") || (gender != "male" && gender != "female") {
-		responseError(c, "Invalid request body", 400)
+	var req personRequest
+	if errs := bindRequest(c, &req); errs != nil {
+		responseInvalid(c, errs)
 		return
 	}
-
-	var birthDate time.Time
-	if birthDateStr != "" {
-		t, err := time.Parse(time.RFC3339, birthDateStr)
-		if err != nil {
-			// try parse date only
-			t2, err2 := time.Parse("2006-01-02", birthDateStr)
-			if err2 != nil {
-				responseError(c, "Invalid birth date", 400)
-				return
-			}
-			birthDate = t2
-		} else {
-			birthDate = t
-		}
+	v := &validator{}
+	req.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
+		return
 	}
 
 	var photoURL string
@@ -69,7 +125,7 @@ func createPerson(c *gin.Context) {
 	if err == nil && file != nil {
 		url, err := uploadImage(c, "photo")
 		if err != nil {
-			fmt.Printf("[ERROR] createPerson - Failed to upload photo: %v\n", err)
+			logger(logUpload).ErrorContext(c, "photo upload failed", "handler", "createPerson", "error", err)
 			responseError(c, fmt.Sprintf("Failed to upload photo: %v", err), 500)
 			return
 		}
@@ -80,109 +136,170 @@ func createPerson(c *gin.Context) {
 	user := u.(*User)
 
 	id := fmt.Sprintf("p-%d", time.Now().UnixNano())
-	person := &Person{
-		ID:        id,
-		Name:      name,
-		Nickname:  nickname,
-		Address:   address,
-		Status:    status,
-		Gender:    gender,
-		BirthDate: birthDate,
-		Phone:     phone,
-		PhotoURL:  photoURL,
-		OwnedBy:   []string{user.ID},
-	}
-
-	newPerson, _ := createPersonRepo(c, person)
+	person := &Person{ID: id, PhotoURL: photoURL, OwnedBy: []string{user.ID}}
+	req.apply(person)
+
+	newPerson, err := storesFrom(c).People.Create(c, person)
+	if err != nil {
+		responseError(c, "Failed to create person", 500)
+		return
+	}
 	responseSuccess(c, newPerson, 200)
 }
 
+// updatePerson replaces a person's editable fields. It is a full replacement: birthDate and
+// phone are cleared when omitted, and the photo is kept unless a new one is uploaded. Use
+// patchPerson to change only some fields.
 func updatePerson(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/updatePerson").End()
+	defer startSpan(c, "handler/updatePerson").End()
 	id := c.Param("id")
-	name := c.PostForm("name")
-	nickname := c.PostForm("nickname")
-	address := c.PostForm("address")
-	status := c.PostForm("status")
-	gender := c.PostForm("gender")
-	birthDateStr := c.PostForm("birthDate")
-	phone := c.PostForm("phone")
-
-	if name == "" || nickname == "" || address == "" || (status != "alive" && status != "deceased") || (gender != "male" && gender != "female") {
-		responseError(c, "Invalid request body", 400)
+	var req personRequest
+	if errs := bindRequest(c, &req); errs != nil {
+		responseInvalid(c, errs)
 		return
 	}
-
-	var birthDate time.Time
-	if birthDateStr != "" {
-		t, err := time.Parse(time.RFC3339, birthDateStr)
-		if err != nil {
-			t2, err2 := time.Parse("2006-01-02", birthDateStr)
-			if err2 != nil {
-				responseError(c, "Invalid birth date", 400)
-				return
-			}
-			birthDate = t2
-		} else {
-			birthDate = t
-		}
+	v := &validator{}
+	req.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
+		return
 	}
 
-	p, err := getPersonByIdRepo(c, id)
+	p, err := storesFrom(c).People.Get(c, id)
 	if err != nil {
 		responseError(c, "Person not found", 404)
 		return
 	}
+	if !checkIfMatch(c, p.Version) {
+		return
+	}
 
 	var photoURL string
 	file, err := c.FormFile("photo")
 	if err == nil && file != nil {
 		url, err := uploadImage(c, "photo")
 		if err != nil {
-			fmt.Printf("[ERROR] updatePerson - Failed to upload photo: %v\n", err)
+			logger(logUpload).ErrorContext(c, "photo upload failed", "handler", "updatePerson", "error", err)
 			responseError(c, fmt.Sprintf("Failed to upload photo: %v", err), 500)
 			return
 		}
 		photoURL = url
 	}
 
-	p.Name = name
-	p.Nickname = nickname
-	p.Address = address
-	p.Status = status
-	p.Gender = gender
-	p.BirthDate = birthDate
-	p.Phone = phone
+	req.apply(p)
 	if photoURL != "" {
 		p.PhotoURL = photoURL
 	}
 
-	updated, _ := updatePersonRepo(c, p)
+	updated, err := storesFrom(c).People.Update(c, p)
+	if err != nil {
+		if errors.Is(err, errVersionConflict) {
+			responseError(c, "Person was changed by someone else; reload it and retry", 409)
+			return
+		}
+		responseError(c, "Failed to update person", 500)
+		return
+	}
+	c.Header("ETag", etag(updated.Version))
 	responseSuccess(c, updated, 200)
 }
 
-func updatePersonOwnership(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/updatePersonOwnership").End()
+// patchPerson applies a JSON Merge Patch (or the fields of a multipart form, plus an
+// optional photo file) to a person. Fields absent from the request are left untouched.
+func patchPerson(c *gin.Context) {
+	defer startSpan(c, "handler/patchPerson").End()
 	id := c.Param("id")
-	var body struct {
-		Owners []string `json:"owners"`
+	patch, fieldErrs, err := readPersonPatch(c)
+	if errors.Is(err, errUnsupportedPatch) {
+		responseError(c, "Content-Type must be application/merge-patch+json, application/json or multipart/form-data", 415)
+		return
 	}
-	if err := c.ShouldBindJSON(&body); err != nil {
+	if err != nil {
 		responseError(c, "Invalid request body", 400)
 		return
 	}
-	p, err := getPersonByIdRepo(c, id)
+
+	p, err := storesFrom(c).People.Get(c, id)
 	if err != nil {
 		responseError(c, "Person not found", 404)
 		return
 	}
+	if !checkIfMatch(c, p.Version) {
+		return
+	}
+	if errs := applyPersonPatch(p, patch); len(fieldErrs)+len(errs) > 0 {
+		responseInvalid(c, append(fieldErrs, errs...))
+		return
+	}
+
+	// the photo is uploaded only once every field has passed validation
+	if file, err := c.FormFile("photo"); err == nil && file != nil {
+		url, err := uploadImage(c, "photo")
+		if err != nil {
+			logger(logUpload).ErrorContext(c, "photo upload failed", "handler", "patchPerson", "error", err)
+			responseError(c, fmt.Sprintf("Failed to upload photo: %v", err), 500)
+			return
+		}
+		p.PhotoURL = url
+	} else if len(patch) == 0 {
+		// nothing to change
+		c.Header("ETag", etag(p.Version))
+		responseSuccess(c, p, 200)
+		return
+	}
+
+	updated, err := storesFrom(c).People.Update(c, p)
+	if err != nil {
+		if errors.Is(err, errVersionConflict) {
+			responseError(c, "Person was changed by someone else; reload it and retry", 409)
+			return
+		}
+		responseError(c, "Failed to update person", 500)
+		return
+	}
+	c.Header("ETag", etag(updated.Version))
+	responseSuccess(c, updated, 200)
+}
+
+func updatePersonOwnership(c *gin.Context) {
+	defer startSpan(c, "handler/updatePersonOwnership").End()
+	id := c.Param("id")
+	var body ownershipRequest
+	defer func() { securityLog(c, "person.ownership", id, "owners="+strings.Join(body.Owners, ",")) }()
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
+	}
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
+		return
+	}
+	p, err := storesFrom(c).People.Get(c, id)
+	if err != nil {
+		responseError(c, "Person not found", 404)
+		return
+	}
+	if !checkIfMatch(c, p.Version) {
+		return
+	}
 
 	// Update ownership for the person
 	p.OwnedBy = body.Owners
-	_, _ = updatePersonRepo(c, p)
+	updated, err := storesFrom(c).People.Update(c, p)
+	if err != nil {
+		if errors.Is(err, errVersionConflict) {
+			responseError(c, "Person was changed by someone else; reload it and retry", 409)
+			return
+		}
+		responseError(c, "Failed to update ownership", 500)
+		return
+	}
+	c.Header("ETag", etag(updated.Version))
 
 	// Get all relationships for this person
-	rels, err := getRelationshipsByPersonIdRepo(c, id)
+	rels, err := storesFrom(c).Relationships.ListByPerson(c, id)
 	if err == nil && len(rels) > 0 {
 		// Collect all spouse and child IDs
 		relatedIDs := make(map[string]bool)
@@ -209,9 +326,9 @@ func updatePersonOwnership(c *gin.Context) {
 
 		// Update ownership for all related people
 		for relatedID := range relatedIDs {
-			if relatedPerson, err := getPersonByIdRepo(c, relatedID); err == nil {
+			if relatedPerson, err := storesFrom(c).People.Get(c, relatedID); err == nil {
 				relatedPerson.OwnedBy = body.Owners
-				_, _ = updatePersonRepo(c, relatedPerson)
+				_, _ = storesFrom(c).People.Update(c, relatedPerson)
 			}
 		}
 	}
@@ -219,13 +336,130 @@ func updatePersonOwnership(c *gin.Context) {
 	responseSuccess(c, true, 200)
 }
 
+// deletePersonById soft-deletes a person and every relationship attached to them.
+// ?preview=true returns what would be deleted without writing. A person who founds or
+// co-founds a family is only deleted with ?cascade=true, which soft-deletes those
+// families as well.
 func deletePersonById(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/deletePersonById").End()
+	defer startSpan(c, "handler/deletePersonById").End()
 	id := c.Param("id")
-	p, err := deletePersonRepo(c, id)
+	preview, cascade := c.Query("preview") == "true", c.Query("cascade") == "true"
+	defer securityLog(c, "person.delete", id, "preview="+strconv.FormatBool(preview)+" cascade="+strconv.FormatBool(cascade))
+	deletion, err := planPersonDeletion(c, id)
 	if err != nil {
 		responseError(c, "Person not found", 404)
 		return
 	}
-	responseSuccess(c, p, 200)
+	if preview {
+		responseSuccess(c, deletion, 200)
+		return
+	}
+	if len(deletion.Families) > 0 && !cascade {
+		responseErrorDetails(c, "Person founds a family; delete with cascade=true to remove the family too", 409, deletion)
+		return
+	}
+	if err := applyPersonDeletion(c, deletion); err != nil {
+		responseError(c, "Failed to delete person", 500)
+		return
+	}
+	responseSuccess(c, deletion, 200)
+}
+
+func getDuplicatePeople(c *gin.Context) {
+	defer startSpan(c, "handler/getDuplicatePeople").End()
+	minScore := 0.5
+	if v := c.Query("minScore"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			responseError(c, "minScore must be a number between 0 and 1", 400)
+			return
+		}
+		minScore = f
+	}
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			responseError(c, "limit must be a positive integer", 400)
+			return
+		}
+		limit = n
+	}
+	u, _ := c.Get("user")
+	user := u.(*User)
+	var ownedBy []string
+	if user.Role != RoleAdmin {
+		ownedBy = []string{user.ID}
+	}
+	candidates, err := findDuplicatePeople(c, ownedBy, minScore, limit)
+	if err != nil {
+		responseError(c, "Failed to find duplicates", 500)
+		return
+	}
+	responseSuccess(c, candidates, 200)
+}
+
+func mergePerson(c *gin.Context) {
+	defer startSpan(c, "handler/mergePerson").End()
+	var body mergeRequest
+	defer func() { securityLog(c, "person.merge", body.Loser, "into="+body.Winner) }()
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
+	}
+	v := &validator{}
+	body.validate(v)
+	if !v.valid() {
+		responseInvalid(c, v.errs)
+		return
+	}
+	u, _ := c.Get("user")
+	user := u.(*User)
+	for _, id := range []string{body.Winner, body.Loser} {
+		p, err := storesFrom(c).People.Get(c, id)
+		if err != nil {
+			responseError(c, "Person not found", 404)
+			return
+		}
+		if !canEditPerson(user, p) {
+			responseError(c, "Forbidden", 403)
+			return
+		}
+	}
+	log, err := mergePeople(c, body.Winner, body.Loser, user.ID)
+	if err != nil {
+		if errors.Is(err, errMergeSamePerson) {
+			responseError(c, "Cannot merge a person with themselves", 400)
+			return
+		}
+		responseError(c, "Failed to merge people", 500)
+		return
+	}
+	responseSuccess(c, log, 200)
+}
+
+func revertPersonMerge(c *gin.Context) {
+	defer startSpan(c, "handler/revertPersonMerge").End()
+	defer securityLog(c, "person.merge.revert", c.Param("mergeId"), "")
+	log, err := storesFrom(c).Merges.Get(c, c.Param("mergeId"))
+	if err != nil {
+		responseError(c, "Merge not found", 404)
+		return
+	}
+	u, _ := c.Get("user")
+	user := u.(*User)
+	if user.Role != RoleAdmin && user.ID != log.MergedBy {
+		responseError(c, "Forbidden", 403)
+		return
+	}
+	reverted, err := revertMerge(c, log.ID)
+	if err != nil {
+		if errors.Is(err, errMergeReverted) {
+			responseError(c, "Merge already reverted", 409)
+			return
+		}
+		responseError(c, "Failed to revert merge", 500)
+		return
+	}
+	responseSuccess(c, reverted, 200)
 }
@@ -0,0 +1,55 @@
+package app
+
+import "context"
+
+// PersonDeletion lists everything deleting a person touches: their relationships (both
+// directions), the families they found or co-found, and the explicit families they are
+// a member of. Only the founded families are deleted, with cascade; memberships are left
+// in place, so restoring the person brings them back.
+type PersonDeletion struct {
+	Person        *Person         `json:"person"`
+	Relationships []*Relationship `json:"relationships"`
+	Families      []*Family       `json:"families"`
+	MemberOf      []*Family       `json:"memberOf"`
+}
+
+// planPersonDeletion collects what deleting person id would affect, without writing.
+func planPersonDeletion(ctx context.Context, id string) (*PersonDeletion, error) {
+	defer startSpan(ctx, "service/planPersonDeletion").End()
+	p, err := storesFrom(ctx).People.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rels, err := storesFrom(ctx).Relationships.ListByPerson(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	families, err := storesFrom(ctx).Families.ListByPerson(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	d := &PersonDeletion{Person: personSummary(p), Relationships: rels, Families: []*Family{}, MemberOf: []*Family{}}
+	for _, f := range families {
+		if f.foundedBy(id) {
+			d.Families = append(d.Families, f)
+		} else {
+			d.MemberOf = append(d.MemberOf, f)
+		}
+	}
+	return d, nil
+}
+
+// applyPersonDeletion soft-deletes the person, their relationships and, when cascade is
+// set, the families they found.
+func applyPersonDeletion(ctx context.Context, d *PersonDeletion) error {
+	defer startSpan(ctx, "service/applyPersonDeletion").End()
+	relIDs := make([]string, 0, len(d.Relationships))
+	for _, r := range d.Relationships {
+		relIDs = append(relIDs, r.ID)
+	}
+	familyIDs := make([]string, 0, len(d.Families))
+	for _, f := range d.Families {
+		familyIDs = append(familyIDs, f.ID)
+	}
+	return storesFrom(ctx).People.Delete(ctx, d.Person.ID, relIDs, familyIDs)
+}
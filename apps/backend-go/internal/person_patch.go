@@ -0,0 +1,114 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// personPatch maps field names to new values; a nil value clears the field (JSON null).
+type personPatch map[string]*string
+
+var errUnsupportedPatch = errors.New("unsupported content type")
+
+// readPersonPatch reads a JSON Merge Patch (application/merge-patch+json or
+// application/json) or the fields of a multipart/urlencoded form. Only fields present in
+// the request end up in the patch. Values that aren't strings or null are reported as
+// field errors.
+func readPersonPatch(c *gin.Context) (personPatch, []FieldError, error) {
+	patch := personPatch{}
+	errs := []FieldError{}
+	switch c.ContentType() {
+	case "application/merge-patch+json", "application/json":
+		var raw map[string]json.RawMessage
+		if err := json.NewDecoder(c.Request.Body).Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+		for field, v := range raw {
+			if string(v) == "null" {
+				patch[field] = nil
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				errs = append(errs, FieldError{Field: field, Message: "must be a string or null"})
+				continue
+			}
+			patch[field] = &s
+		}
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		if c.ContentType() == "multipart/form-data" {
+			if _, err := c.MultipartForm(); err != nil {
+				return nil, nil, err
+			}
+		} else if err := c.Request.ParseForm(); err != nil {
+			return nil, nil, err
+		}
+		for field, vs := range c.Request.PostForm {
+			if len(vs) > 0 {
+				v := vs[0]
+				patch[field] = &v
+			}
+		}
+	default:
+		return nil, nil, errUnsupportedPatch
+	}
+	return patch, errs, nil
+}
+
+// applyPersonPatch validates every field of the patch and, when all of them are valid,
+// applies them to p. name, nickname, address, status and gender can be changed but not
+// cleared; birthDate and phone are cleared by null or an empty string; photoUrl can only
+// be cleared (new photos are uploaded as the multipart "photo" file).
+func applyPersonPatch(p *Person, patch personPatch) []FieldError {
+	v := &validator{}
+	next := *p
+	value := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	for field, raw := range patch {
+		val := value(raw)
+		switch field {
+		case "name":
+			v.required(field, val)
+			next.Name = val
+		case "nickname":
+			v.required(field, val)
+			next.Nickname = val
+		case "address":
+			v.required(field, val)
+			next.Address = val
+		case "status":
+			v.oneOf(field, val, "alive", "deceased")
+			next.Status = val
+		case "gender":
+			v.oneOf(field, val, "male", "female")
+			next.Gender = val
+		case "birthDate":
+			next.BirthDate = v.date(field, val)
+		case "phone":
+			next.Phone = val
+		case "photoUrl":
+			if val != "" {
+				v.add(field, "can only be cleared; upload a new photo as the photo file")
+			}
+			next.PhotoURL = ""
+		case "_id", "ownedBy", "owners", "relationships", "version", "deletedAt":
+			v.add(field, "is read-only")
+		default:
+			v.add(field, "unknown field")
+		}
+	}
+	if !v.valid() {
+		slices.SortFunc(v.errs, func(a, b FieldError) int { return strings.Compare(a.Field, b.Field) })
+		return v.errs
+	}
+	*p = next
+	return nil
+}
@@ -1,14 +1,17 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 func getRelationships(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/getRelationships").End()
+	defer startSpan(c, "handler/getRelationships").End()
 	id := c.Param("id")
-	rels, err := getRelationshipsByPersonIdRepo(c, id)
+	rels, err := storesFrom(c).Relationships.ListByPerson(c, id)
 	if err != nil {
 		responseError(c, "Failed to fetch relationships", 500)
 		return
@@ -26,21 +29,39 @@ func getRelationships(c *gin.Context) {
 }
 
 func crudRelationships(c *gin.Context) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "handler/crudRelationships").End()
-	var body []struct {
-		From  *string `json:"from"`
-		To    string  `json:"to"`
-		Order *int    `json:"order"`
-		Type  string  `json:"type"`
-		ID    *string `json:"_id"`
-	}
-	if err := c.ShouldBindJSON(&body); err != nil {
-		responseError(c, "Invalid request body", 400)
+	defer startSpan(c, "handler/crudRelationships").End()
+	// result says what became of the request, so rejected ones aren't mistaken for updates
+	result := "rejected: invalid request"
+	defer func() { securityLog(c, "relationship.update", c.Param("id"), result) }()
+	var body []relationshipRequest
+	if errs := bindRequest(c, &body); errs != nil {
+		responseInvalid(c, errs)
+		return
+	}
+	body = slices.DeleteFunc(body, func(r relationshipRequest) bool { return r.incomplete() })
+	v := &validator{}
+	for i := range body {
+		body[i].validate(v, i)
+	}
+	if !v.valid() {
+		responseInvalid(c, v.errs)
 		return
 	}
 	id := c.Param("id")
+	if !isObjectIDHex(id) {
+		responseInvalid(c, []FieldError{{Field: "id", Message: "must be a valid ID"}})
+		return
+	}
+	// force lets an admin save relationships that break the forceable validation rules
+	force := c.Query("force") == "true"
+	u, _ := c.Get("user")
+	if user := u.(*User); force && user.Role != RoleAdmin {
+		result = "denied: force needs an admin"
+		responseError(c, "Only admins can force relationship changes", 403)
+		return
+	}
 	// implement upsert similar to Node: build inserts and updates
-	existing, err := getRelationshipsByPersonIdRepo(c, id)
+	existing, err := storesFrom(c).Relationships.ListByPerson(c, id)
 	if err != nil {
 		responseError(c, "Failed to fetch relationships", 500)
 		return
@@ -48,19 +69,62 @@ func crudRelationships(c *gin.Context) {
 	existingMap := map[string]Relationship{}
 	for _, r := range existing {
 		key := r.From + "_" + r.To + "_" + r.Type
-		existingMap[key] = *r
+		ex := *r
+		ex.Subtype = normalizeRelationshipSubtype(ex.Type, ex.Subtype)
+		existingMap[key] = ex
+	}
+
+	// edges the client read at an older version than what is stored
+	conflicts := []RelationshipChange{}
+	for _, rel := range body {
+		from := id
+		if rel.From != nil && *rel.From != "" {
+			from = *rel.From
+		}
+		if ex, ok := existingMap[from+"_"+rel.To+"_"+rel.Type]; ok && rel.Version != nil && *rel.Version != ex.Version {
+			conflicts = append(conflicts, newRelationshipChange(ex, "conflict"))
+		}
+	}
+	if len(conflicts) > 0 {
+		result = "rejected: version conflict"
+		responseErrorDetails(c, "Relationships were changed by someone else; reload them and retry", 409, conflicts)
+		return
 	}
 
 	toInsert := []Relationship{}
 	toUpdate := []Relationship{}
+	unchanged := []RelationshipChange{}
+	// toValidate holds new edges and existing edges whose subtype changes
+	toValidate := []Relationship{}
 	seenKeys := map[string]bool{}
 
-	for _, rel := range body {
-		if rel.To == "" || rel.Type == "" {
-			continue
+	// plan classifies one edge against what is already stored; each key is planned once
+	// so a body that lists both sides of a pair doesn't produce duplicate writes.
+	plan := func(from, to, typ, subtype string, marriage *Marriage, order int) {
+		key := from + "_" + to + "_" + typ
+		if seenKeys[key] {
+			return
 		}
-		key := id + "_" + rel.To + "_" + rel.Type
 		seenKeys[key] = true
+		ex, ok := existingMap[key]
+		switch {
+		case !ok:
+			toInsert = append(toInsert, Relationship{From: from, To: to, Type: typ, Subtype: subtype, Marriage: marriage, Order: order})
+			toValidate = append(toValidate, toInsert[len(toInsert)-1])
+		case ex.Order != order || ex.Subtype != subtype || !marriageEqual(ex.Marriage, marriage):
+			if ex.Subtype != subtype || !marriageEqual(ex.Marriage, marriage) {
+				toValidate = append(toValidate, Relationship{ID: ex.ID, From: from, To: to, Type: typ, Subtype: subtype, Marriage: marriage, Order: order})
+			}
+			ex.Order = order
+			ex.Subtype = subtype
+			ex.Marriage = marriage
+			toUpdate = append(toUpdate, ex)
+		default:
+			unchanged = append(unchanged, newRelationshipChange(ex, "unchanged"))
+		}
+	}
+
+	for _, rel := range body {
 		order := 0
 		if rel.Order != nil {
 			order = *rel.Order
@@ -69,16 +133,11 @@ func crudRelationships(c *gin.Context) {
 		if rel.From != nil && *rel.From != "" {
 			from = *rel.From
 		}
-		if ex, ok := existingMap[key]; !ok {
-			toInsert = append(toInsert, Relationship{From: from, To: rel.To, Type: rel.Type, Order: order})
-		} else {
-			if ex.Order != order {
-				ex.Order = order
-				toUpdate = append(toUpdate, ex)
-			}
-		}
+		subtype := normalizeRelationshipSubtype(rel.Type, rel.Subtype)
+		marriage := normalizeMarriage(rel.Marriage.marriage())
+		plan(from, rel.To, rel.Type, subtype, marriage, order)
 
-		// inverse
+		// inverse: parent and child swap, spouse mirrors; subtype and marriage are shared by both sides
 		invType := ""
 		if rel.Type == "parent" {
 			invType = "child"
@@ -88,44 +147,46 @@ func crudRelationships(c *gin.Context) {
 			invType = "spouse"
 		}
 		if invType != "" {
-			invKey := rel.To + "_" + id + "_" + invType
-			seenKeys[invKey] = true
-			if ex, ok := existingMap[invKey]; !ok {
-				toInsert = append(toInsert, Relationship{From: rel.To, To: id, Type: invType, Order: order})
-			} else {
-				ex.Order = order
-				toUpdate = append(toUpdate, ex)
-			}
+			plan(rel.To, from, invType, subtype, marriage, order)
 		}
 	}
 
 	// delete those not seen
-	toDelete := []string{}
+	toDelete := []Relationship{}
 	for _, ex := range existing {
 		key := ex.From + "_" + ex.To + "_" + ex.Type
 		if !seenKeys[key] {
-			toDelete = append(toDelete, ex.ID)
+			toDelete = append(toDelete, *ex)
 		}
 	}
 
-	if len(toInsert) > 0 {
-		if err := insertManyRelationshipsRepo(c, toInsert); err != nil {
-			responseError(c, "Failed to insert relationships", 500)
-			return
-		}
+	violations, err := validateRelationshipChanges(c, id, toValidate, existing, toDelete)
+	if err != nil {
+		responseError(c, "Failed to validate relationships", 500)
+		return
 	}
-	for _, up := range toUpdate {
-		if _, err := updateRelationshipRepo(c, up); err != nil {
-			responseError(c, "Failed to update relationships", 500)
-			return
-		}
+	if blocking := blockingViolations(violations, force); len(blocking) > 0 {
+		result = fmt.Sprintf("rejected: %d validation violations", len(blocking))
+		responseErrorDetails(c, "Relationship validation failed", 422, blocking)
+		return
 	}
-	if len(toDelete) > 0 {
-		if err := deleteRelationshipsRepo(c, toDelete); err != nil {
-			responseError(c, "Failed to delete relationships", 500)
-			return
-		}
+
+	changes, err := storesFrom(c).Relationships.Apply(c, toInsert, toUpdate, toDelete)
+	if errors.Is(err, errVersionConflict) {
+		result = "rejected: version conflict"
+		responseError(c, "Relationships were changed by someone else; reload them and retry", 409)
+		return
+	}
+	if err != nil {
+		result = "failed: " + err.Error()
+		responseError(c, "Failed to save relationships", 500)
+		return
+	}
+	result = fmt.Sprintf("applied: %d inserted, %d updated, %d deleted", len(toInsert), len(toUpdate), len(toDelete))
+	if force {
+		result += ", forced"
 	}
+	changes = append(changes, unchanged...)
 
-	responseSuccess(c, gin.H{"inserted": len(toInsert), "updated": len(toUpdate), "deleted": len(toDelete)}, 201)
+	responseSuccess(c, changes, 201)
 }
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Relationship validation codes. Forceable codes describe data that is unusual but
+// possible, and can be overridden by an admin with ?force=true.
+const (
+	violationInvalidType          = "invalid_type"
+	violationInvalidSubtype       = "invalid_subtype"
+	violationInvalidMarriage      = "invalid_marriage"
+	violationSelfRelationship     = "self_relationship"
+	violationPersonNotFound       = "person_not_found"
+	violationTooManyParents       = "too_many_parents"
+	violationParentBornAfterChild = "parent_born_after_child"
+)
+
+// maxBiologicalParents is the number of biological parents a child may have before the
+// too_many_parents rule fires. Adoptive, step, foster and guardian parents don't count.
+const maxBiologicalParents = 2
+
+var parentSubtypes = map[string]bool{
+	SubtypeBiological: true,
+	SubtypeAdoptive:   true,
+	SubtypeStep:       true,
+	SubtypeFoster:     true,
+	SubtypeGuardian:   true,
+	SubtypeUnknown:    true,
+}
+
+// normalizeRelationshipSubtype defaults parent and child edges without a subtype to
+// biological. Other relationship types keep whatever subtype they were given.
+func normalizeRelationshipSubtype(typ, subtype string) string {
+	if (typ == "parent" || typ == "child") && subtype == "" {
+		return SubtypeBiological
+	}
+	return subtype
+}
+
+// RelationshipViolation describes a single rule broken by an edge in a relationship upsert.
+type RelationshipViolation struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Type      string `json:"type"`
+	Forceable bool   `json:"forceable"`
+}
+
+// blockingViolations returns the violations that still prevent the write. When force is
+// set, only the violations that cannot be overridden are returned.
+func blockingViolations(violations []RelationshipViolation, force bool) []RelationshipViolation {
+	if !force {
+		return violations
+	}
+	out := []RelationshipViolation{}
+	for _, v := range violations {
+		if !v.Forceable {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// parentChildPair normalizes a parent or child edge to (parent, child). ok is false for
+// every other relationship type.
+func parentChildPair(r Relationship) (parent string, child string, ok bool) {
+	switch r.Type {
+	case "parent":
+		return r.From, r.To, true
+	case "child":
+		return r.To, r.From, true
+	}
+	return "", "", false
+}
+
+// validateRelationshipChanges checks the edges a relationship upsert is about to insert or
+// re-type. existing holds the stored relationships of personID and deletes the edges the same
+// upsert removes, so parent counts reflect the state after the write.
+func validateRelationshipChanges(ctx context.Context, personID string, edges []Relationship, existing []*Relationship, deletes []Relationship) ([]RelationshipViolation, error) {
+	defer startSpan(ctx, "service/validateRelationshipChanges").End()
+
+	violations := []RelationshipViolation{}
+	reported := map[string]bool{}
+	report := func(r Relationship, code, message string, forceable bool) {
+		// both sides of a pair are inserted together; report the pair once
+		a, b := r.From, r.To
+		if p, ch, ok := parentChildPair(r); ok {
+			a, b = p, ch
+		} else if b < a {
+			a, b = b, a
+		}
+		key := code + "_" + a + "_" + b
+		if reported[key] {
+			return
+		}
+		reported[key] = true
+		violations = append(violations, RelationshipViolation{Code: code, Message: message, From: r.From, To: r.To, Type: r.Type, Forceable: forceable})
+	}
+
+	// people lookup, memoized; a nil entry means missing or soft-deleted
+	people := map[string]*Person{}
+	person := func(id string) *Person {
+		if p, ok := people[id]; ok {
+			return p
+		}
+		p, err := storesFrom(ctx).People.Get(ctx, id)
+		if err != nil {
+			p = nil
+		}
+		people[id] = p
+		return p
+	}
+
+	for _, r := range edges {
+		if r.Type != "parent" && r.Type != "child" && r.Type != "spouse" {
+			report(r, violationInvalidType, fmt.Sprintf("Unknown relationship type %q", r.Type), false)
+			continue
+		}
+		if isParentChild := r.Type != "spouse"; (isParentChild && !parentSubtypes[r.Subtype]) || (!isParentChild && r.Subtype != "") {
+			report(r, violationInvalidSubtype, fmt.Sprintf("Subtype %q is not allowed on a %s relationship", r.Subtype, r.Type), false)
+			continue
+		}
+		if m := r.Marriage; m != nil {
+			if r.Type != "spouse" || !marriageStatuses[m.Status] {
+				report(r, violationInvalidMarriage, fmt.Sprintf("Marriage status %q is not allowed on a %s relationship", m.Status, r.Type), false)
+				continue
+			}
+			if m.StartDate != nil && m.EndDate != nil && m.EndDate.Before(*m.StartDate) {
+				report(r, violationInvalidMarriage, "Marriage end date is before its start date", false)
+				continue
+			}
+		}
+		if r.From == r.To {
+			report(r, violationSelfRelationship, "A person cannot be related to themselves", false)
+			continue
+		}
+		from, to := person(r.From), person(r.To)
+		if from == nil || to == nil {
+			missing := r.From
+			if from != nil {
+				missing = r.To
+			}
+			report(r, violationPersonNotFound, fmt.Sprintf("Person %s does not exist or has been deleted", missing), false)
+			continue
+		}
+		if parentID, childID, ok := parentChildPair(r); ok {
+			parent, child := person(parentID), person(childID)
+			if !parent.BirthDate.IsZero() && !child.BirthDate.IsZero() && parent.BirthDate.After(child.BirthDate) {
+				report(r, violationParentBornAfterChild, fmt.Sprintf("%s was born after their child %s", parent.Name, child.Name), true)
+			}
+		}
+	}
+
+	// count parents per child as they will be after the write
+	deleted := map[string]bool{}
+	for _, d := range deletes {
+		deleted[d.ID] = true
+	}
+	changed := map[string]bool{}
+	newParentEdges := map[string][]Relationship{}
+	for _, r := range edges {
+		if r.ID != "" {
+			changed[r.ID] = true
+		}
+		if _, childID, ok := parentChildPair(r); ok {
+			newParentEdges[childID] = append(newParentEdges[childID], r)
+		}
+	}
+	children := make([]string, 0, len(newParentEdges))
+	for childID := range newParentEdges {
+		children = append(children, childID)
+	}
+	sort.Strings(children)
+	for _, childID := range children {
+		if person(childID) == nil {
+			continue
+		}
+		rels := existing
+		if childID != personID {
+			var err error
+			if rels, err = storesFrom(ctx).Relationships.ListByPerson(ctx, childID); err != nil {
+				return nil, err
+			}
+		}
+		parents := map[string]struct{}{}
+		for _, r := range rels {
+			if deleted[r.ID] || changed[r.ID] {
+				continue
+			}
+			if normalizeRelationshipSubtype(r.Type, r.Subtype) != SubtypeBiological {
+				continue
+			}
+			if p, ch, ok := parentChildPair(*r); ok && ch == childID {
+				parents[p] = struct{}{}
+			}
+		}
+		for _, r := range newParentEdges[childID] {
+			if r.Subtype == SubtypeBiological {
+				p, _, _ := parentChildPair(r)
+				parents[p] = struct{}{}
+			}
+		}
+		if len(parents) > maxBiologicalParents {
+			for _, r := range newParentEdges[childID] {
+				if r.Subtype == SubtypeBiological {
+					report(r, violationTooManyParents, fmt.Sprintf("%s would have %d biological parents", person(childID).Name, len(parents)), true)
+				}
+			}
+		}
+	}
+
+	return violations, nil
+}
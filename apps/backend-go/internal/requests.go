@@ -0,0 +1,231 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// personRequest is the body of POST /api/person and PUT /api/person/:id, as JSON or as a
+// multipart form (which may also carry a "photo" file).
+type personRequest struct {
+	Name      string `json:"name" form:"name"`
+	Nickname  string `json:"nickname" form:"nickname"`
+	Address   string `json:"address" form:"address"`
+	Status    string `json:"status" form:"status"`
+	Gender    string `json:"gender" form:"gender"`
+	BirthDate string `json:"birthDate" form:"birthDate"`
+	Phone     string `json:"phone" form:"phone"`
+
+	birthDate time.Time
+}
+
+func (r *personRequest) validate(v *validator) {
+	v.required("name", r.Name)
+	v.required("nickname", r.Nickname)
+	v.required("address", r.Address)
+	v.oneOf("status", r.Status, "alive", "deceased")
+	v.oneOf("gender", r.Gender, "male", "female")
+	r.birthDate = v.date("birthDate", r.BirthDate)
+}
+
+// apply copies the request onto p, replacing every editable field except the photo.
+func (r *personRequest) apply(p *Person) {
+	p.Name = r.Name
+	p.Nickname = r.Nickname
+	p.Address = r.Address
+	p.Status = r.Status
+	p.Gender = r.Gender
+	p.BirthDate = r.birthDate
+	p.Phone = r.Phone
+}
+
+// ownershipRequest is the body of PUT /api/person/:id/ownership.
+type ownershipRequest struct {
+	Owners []string `json:"owners" form:"owners"`
+}
+
+func (r *ownershipRequest) validate(v *validator) {
+	if r.Owners == nil {
+		v.add("owners", "is required")
+	}
+	for i, o := range r.Owners {
+		v.objectID(fmt.Sprintf("owners.%d", i), o)
+	}
+}
+
+// familyRequest is the body of POST /api/family and PUT /api/family/:id. A lone person is
+// shorthand for a single founder, as sent by older clients.
+type familyRequest struct {
+	Name        string                `json:"name" form:"name"`
+	Description string                `json:"description" form:"description"`
+	Person      string                `json:"person" form:"person"`
+	Founders    []familyCoupleRequest `json:"founders"`
+	Membership  string                `json:"membership" form:"membership"`
+	Members     []string              `json:"members" form:"members"`
+}
+
+type familyCoupleRequest struct {
+	Person string `json:"person"`
+	Spouse string `json:"spouse"`
+}
+
+func (r *familyRequest) validate(v *validator) {
+	v.required("name", r.Name)
+	if len(r.Founders) == 0 {
+		if r.Person == "" {
+			v.add("founders", "is required")
+		} else {
+			v.objectID("person", r.Person)
+		}
+	}
+	for i, c := range r.Founders {
+		v.objectID(fmt.Sprintf("founders.%d.person", i), c.Person)
+		if c.Spouse != "" {
+			v.objectID(fmt.Sprintf("founders.%d.spouse", i), c.Spouse)
+			if c.Spouse == c.Person {
+				v.add(fmt.Sprintf("founders.%d.spouse", i), "must differ from person")
+			}
+		}
+	}
+	if r.Membership == "" {
+		r.Membership = MembershipDescendants
+	}
+	v.oneOf("membership", r.Membership, MembershipDescendants, MembershipExplicit)
+	if r.Membership == MembershipDescendants && len(r.Members) > 0 {
+		v.add("members", "is only allowed with membership "+MembershipExplicit)
+	}
+	for i, m := range r.Members {
+		v.objectID(fmt.Sprintf("members.%d", i), m)
+	}
+}
+
+// founders returns the founding couples, with a lone person as the only founder.
+func (r *familyRequest) founders() []FamilyCouple {
+	if len(r.Founders) == 0 {
+		return []FamilyCouple{{Person: r.Person}}
+	}
+	out := make([]FamilyCouple, 0, len(r.Founders))
+	for _, c := range r.Founders {
+		out = append(out, FamilyCouple{Person: c.Person, Spouse: c.Spouse})
+	}
+	return out
+}
+
+// apply copies the request onto f, replacing every editable field except the cover image.
+func (r *familyRequest) apply(f *Family) {
+	f.Name = r.Name
+	f.Description = r.Description
+	f.Founders = r.founders()
+	f.Membership = r.Membership
+	f.Members = nil
+	if r.Membership == MembershipExplicit {
+		f.Members = append([]string{}, r.Members...)
+	}
+}
+
+// relationshipRequest is one edge of the POST /api/relationship/:id body.
+type relationshipRequest struct {
+	From     *string          `json:"from"`
+	To       string           `json:"to"`
+	Order    *int             `json:"order"`
+	Type     string           `json:"type"`
+	Subtype  string           `json:"subtype"`
+	Marriage *marriageRequest `json:"marriage"`
+	ID       *string          `json:"_id"`
+	Version  *int             `json:"version"` // when set, the edge must still be at this version
+}
+
+// marriageRequest is the marriage metadata of a spouse edge. Dates take the same forms as
+// birthDate.
+type marriageRequest struct {
+	Status    string `json:"status"`
+	StartDate string `json:"startDate"`
+	EndDate   string `json:"endDate"`
+	Place     string `json:"place"`
+
+	startDate, endDate time.Time
+}
+
+func (r *marriageRequest) validate(v *validator, field string) {
+	r.startDate = v.date(field+".startDate", r.StartDate)
+	r.endDate = v.date(field+".endDate", r.EndDate)
+}
+
+// marriage converts the request to marriage metadata; unset dates stay nil.
+func (r *marriageRequest) marriage() *Marriage {
+	if r == nil {
+		return nil
+	}
+	m := &Marriage{Status: r.Status, Place: r.Place}
+	if !r.startDate.IsZero() {
+		m.StartDate = &r.startDate
+	}
+	if !r.endDate.IsZero() {
+		m.EndDate = &r.endDate
+	}
+	return m
+}
+
+// incomplete reports whether the edge lacks a target or a type. Such edges are skipped,
+// as they always have been.
+func (r *relationshipRequest) incomplete() bool {
+	return r.To == "" || r.Type == ""
+}
+
+// validate only checks the IDs; the type and everything else about the edge is checked by
+// validateRelationshipChanges, which answers 422.
+func (r *relationshipRequest) validate(v *validator, i int) {
+	field := func(name string) string { return fmt.Sprintf("%d.%s", i, name) }
+	if r.From != nil && *r.From != "" {
+		v.objectID(field("from"), *r.From)
+	}
+	v.objectID(field("to"), r.To)
+	if r.Marriage != nil {
+		r.Marriage.validate(v, field("marriage"))
+	}
+}
+
+// mergeRequest is the body of POST /api/person/merge.
+type mergeRequest struct {
+	Winner string `json:"winner" form:"winner"`
+	Loser  string `json:"loser" form:"loser"`
+}
+
+func (r *mergeRequest) validate(v *validator) {
+	if v.required("winner", r.Winner) {
+		v.objectID("winner", r.Winner)
+	}
+	if v.required("loser", r.Loser) {
+		v.objectID("loser", r.Loser)
+	}
+}
+
+// auditFixRequest is the optional body of POST /api/admin/audit/fix. Codes limits the fix
+// to these finding codes; empty fixes everything fixable.
+type auditFixRequest struct {
+	Codes []string `json:"codes" form:"codes"`
+}
+
+// signInRequest is the body of POST /api/auth/signin.
+type signInRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+}
+
+func (r *signInRequest) validate(v *validator) {
+	v.required("username", r.Username)
+	v.required("password", r.Password)
+}
+
+// createUserRequest is the body of POST /api/auth.
+type createUserRequest struct {
+	Name     string `json:"name" form:"name"`
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+}
+
+func (r *createUserRequest) validate(v *validator) {
+	v.required("name", r.Name)
+	v.required("username", r.Username)
+	v.required("password", r.Password)
+}
@@ -4,11 +4,11 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func RegisterRoutes(rg *gin.Engine) {
-	// temporary helper: list registered routes for debugging
-	rg.GET("/routes", func(c *gin.Context) {
-		c.JSON(200, gin.H{"routes": rg.Routes()})
-	})
+// RegisterRoutes mounts the API on rg, serving it from stores with configuration cfg.
+func RegisterRoutes(rg *gin.Engine, stores *Stores, cfg *Config) {
+	rg.Use(useConfig(cfg), useStores(stores))
+	rg.GET("/healthz", healthz)
+	rg.GET("/readyz", readyz)
 	api := rg.Group("/api")
 	{
 		api.GET("/tree/:personId", authenticate([]string{"user", "admin"}), getFamilyTree)
@@ -17,8 +17,13 @@ func RegisterRoutes(rg *gin.Engine) {
 		{
 			person.GET("", authenticate([]string{"admin", "user"}), getAllPeople)
 			person.POST("", authenticate([]string{"admin", "user"}), createPerson)
+			person.GET("/search", authenticate([]string{"admin", "user"}), searchPerson)
+			person.GET("/duplicates", authenticate([]string{"admin", "user"}), getDuplicatePeople)
+			person.POST("/merge", authenticate([]string{"admin", "user"}), mergePerson)
+			person.POST("/merge/:mergeId/revert", authenticate([]string{"admin", "user"}), revertPersonMerge)
 			person.GET("/:id", authenticate([]string{"admin", "user"}), getPersonById)
 			person.PUT("/:id", authenticate([]string{"admin", "user"}), updatePerson)
+			person.PATCH("/:id", authenticate([]string{"admin", "user"}), patchPerson)
 			person.DELETE("/:id", authenticate([]string{"admin", "user"}), deletePersonById)
 			person.PUT("/:id/ownership", authenticate([]string{"admin"}), updatePersonOwnership)
 		}
@@ -27,6 +32,12 @@ func RegisterRoutes(rg *gin.Engine) {
 		{
 			family.GET("", authenticate([]string{"admin", "user"}), getFamilies)
 			family.POST("", authenticate([]string{"admin", "user"}), createFamily)
+			family.GET("/:id", authenticate([]string{"admin", "user"}), getFamilyById)
+			family.PUT("/:id", authenticate([]string{"admin", "user"}), updateFamily)
+			family.GET("/:id/members", authenticate([]string{"admin", "user"}), getFamilyMembers)
+			family.GET("/:id/tree", authenticate([]string{"admin", "user"}), getFamilyTreeById)
+			family.PUT("/:id/cover", authenticate([]string{"admin", "user"}), uploadFamilyCover)
+			family.DELETE("/:id/cover", authenticate([]string{"admin", "user"}), deleteFamilyCover)
 			family.DELETE("/:id", authenticate([]string{"admin", "user"}), deleteFamily)
 		}
 
@@ -36,6 +47,32 @@ func RegisterRoutes(rg *gin.Engine) {
 			rel.POST("/:id", authenticate([]string{"admin", "user"}), crudRelationships)
 		}
 
+		trash := api.Group("/trash")
+		{
+			trash.GET("", authenticate([]string{"admin", "user"}), getTrash)
+			trash.POST("/:kind/:id/restore", authenticate([]string{"admin", "user"}), restoreTrash)
+			trash.DELETE("/:kind/:id", authenticate([]string{"admin"}), purgeTrash)
+		}
+
+		history := api.Group("/history")
+		{
+			history.GET("/:kind/:id", authenticate([]string{"admin", "user"}), getHistory)
+			history.POST("/:kind/:id/revert/:revisionId", authenticate([]string{"admin", "user"}), revertHistory)
+		}
+
+		admin := api.Group("/admin")
+		{
+			admin.GET("/audit", authenticate([]string{"admin"}), getAudit)
+			admin.POST("/audit/fix", authenticate([]string{"admin"}), fixAudit)
+			admin.GET("/security-log", authenticate([]string{"admin"}), getSecurityLog)
+			admin.GET("/security-log/export", authenticate([]string{"admin"}), exportSecurityLog)
+			admin.GET("/config", authenticate([]string{"admin"}), getConfig)
+			// lists the registered routes, for debugging
+			admin.GET("/routes", authenticate([]string{"admin"}), func(c *gin.Context) {
+				responseSuccess(c, rg.Routes(), 200)
+			})
+		}
+
 		auth := api.Group("/auth")
 		{
 			auth.POST("/signin", signIn)
@@ -0,0 +1,576 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testAPI is the router mounted on the memory stores, with an admin and a user signed in.
+type testAPI struct {
+	t      *testing.T
+	router *gin.Engine
+	stores *Stores
+	admin  string // bearer tokens
+	user   string
+	userID string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := DefaultConfig()
+	cfg.JWTSecret = "test-secret"
+	cfg.Uploads.Dir = t.TempDir()
+	stores := NewMemoryStores()
+	router := gin.New()
+	RegisterRoutes(router, stores, cfg)
+
+	api := &testAPI{t: t, router: router, stores: stores}
+	admin := api.createUser("Admin", "admin", RoleAdmin)
+	user := api.createUser("User", "user", RoleUser)
+	api.admin, api.user, api.userID = api.token(cfg, admin), api.token(cfg, user), user.ID
+	return api
+}
+
+func (api *testAPI) createUser(name, username string, role UserRole) *User {
+	api.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	u, err := api.stores.Users.Create(api.t.Context(), &User{Name: name, Username: username, Password: string(hash), Role: role})
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return u
+}
+
+func (api *testAPI) token(cfg *Config, u *User) string {
+	api.t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": u.ID, "exp": time.Now().Add(time.Hour).Unix()}).SignedString([]byte(cfg.JWTSecret))
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return s
+}
+
+// send serves one request; body is encoded as JSON unless it is already an io.Reader.
+func (api *testAPI) send(method, path, token string, body any, header ...string) *httptest.ResponseRecorder {
+	api.t.Helper()
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		r = b
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		r = bytes.NewReader(raw)
+	}
+	req := httptest.NewRequest(method, path, r)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	api.router.ServeHTTP(w, req)
+	return w
+}
+
+// call serves a request, checks its status and decodes the data of the response into out.
+func (api *testAPI) call(method, path, token string, body any, want int, out any, header ...string) {
+	api.t.Helper()
+	w := api.send(method, path, token, body, header...)
+	if w.Code != want {
+		api.t.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, want, w.Body.String())
+	}
+	if out == nil {
+		return
+	}
+	var res struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		api.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	if err := json.Unmarshal(res.Data, out); err != nil {
+		api.t.Fatalf("%s %s: decoding %s: %v", method, path, res.Data, err)
+	}
+}
+
+func (api *testAPI) createPerson(token, name, gender string) *Person {
+	api.t.Helper()
+	var p Person
+	api.call("POST", "/api/person", token, gin.H{
+		"name": name, "nickname": name, "address": "Jakarta", "status": "alive", "gender": gender, "birthDate": "1980-01-02",
+	}, 200, &p)
+	return &p
+}
+
+func (api *testAPI) createFamily(token, name string, founders ...string) *Family {
+	api.t.Helper()
+	couple := gin.H{"person": founders[0]}
+	if len(founders) > 1 {
+		couple["spouse"] = founders[1]
+	}
+	var f Family
+	api.call("POST", "/api/family", token, gin.H{"name": name, "founders": []gin.H{couple}}, 201, &f)
+	return &f
+}
+
+// marry links two people as spouses and makes child their child. A POST replaces all of a
+// person's edges, so the wife's lists her husband again.
+func (api *testAPI) marry(token string, husband, wife, child *Person) {
+	api.t.Helper()
+	api.call("POST", "/api/relationship/"+husband.ID, token, []gin.H{
+		{"to": wife.ID, "type": "spouse"},
+		{"to": child.ID, "type": "parent"},
+	}, 201, nil)
+	api.call("POST", "/api/relationship/"+wife.ID, token, []gin.H{
+		{"to": husband.ID, "type": "spouse"},
+		{"to": child.ID, "type": "parent"},
+	}, 201, nil)
+}
+
+func TestStoresFromNeedsStores(t *testing.T) {
+	stores := NewMemoryStores()
+	if got := storesFrom(context.WithoutCancel(WithStores(t.Context(), stores))); got != stores {
+		t.Fatalf("storesFrom = %p, want %p", got, stores)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("storesFrom fell back to other stores instead of panicking")
+		}
+	}()
+	storesFrom(t.Context())
+}
+
+func TestHealthRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	api.call("GET", "/healthz", "", nil, 200, nil)
+	// the memory stores don't connect MongoDB, which readiness requires
+	api.call("GET", "/readyz", "", nil, 503, nil)
+}
+
+func TestAuthRoutes(t *testing.T) {
+	api := newTestAPI(t)
+
+	var signedIn struct {
+		User  User   `json:"user"`
+		Token string `json:"token"`
+	}
+	api.call("POST", "/api/auth/signin", "", gin.H{"username": "user", "password": "secret"}, 200, &signedIn)
+	if signedIn.Token == "" || signedIn.User.Password != "" {
+		t.Fatalf("signin = %+v, want a token and no password hash", signedIn)
+	}
+	api.call("GET", "/api/auth", signedIn.Token, nil, 200, nil)
+	api.call("POST", "/api/auth/signin", "", gin.H{"username": "user", "password": "wrong"}, 401, nil)
+	api.call("POST", "/api/auth/signin", "", gin.H{"username": "user"}, 400, nil)
+	api.call("GET", "/api/auth", "", nil, 401, nil)
+	api.call("GET", "/api/auth", "not-a-token", nil, 401, nil)
+
+	var created User
+	api.call("POST", "/api/auth", api.admin, gin.H{"name": "Other", "username": "other", "password": "secret"}, 201, &created)
+	if created.Role != RoleUser || created.Password != "" {
+		t.Fatalf("created user = %+v", created)
+	}
+	api.call("POST", "/api/auth", api.user, gin.H{"name": "Other", "username": "other2", "password": "secret"}, 403, nil)
+
+	var users []User
+	api.call("GET", "/api/auth/users", api.admin, nil, 200, &users)
+	if len(users) != 2 {
+		t.Fatalf("got %d users, want the 2 with role user", len(users))
+	}
+	api.call("GET", "/api/auth/users", api.user, nil, 403, nil)
+}
+
+func TestPersonRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	p := api.createPerson(api.user, "Budi Santoso", "male")
+	api.createPerson(api.admin, "Siti Aminah", "female")
+
+	var got Person
+	api.call("GET", "/api/person/"+p.ID, api.user, nil, 200, &got)
+	if got.Name != "Budi Santoso" || len(got.OwnedBy) != 1 || got.OwnedBy[0] != api.userID {
+		t.Fatalf("person = %+v", got)
+	}
+	api.call("GET", "/api/person/000000000000000000000000", api.user, nil, 404, nil)
+
+	var people []Person
+	api.call("GET", "/api/person", api.user, nil, 200, &people)
+	if len(people) != 1 {
+		t.Fatalf("user sees %d people, want only their own", len(people))
+	}
+	api.call("GET", "/api/person", api.admin, nil, 200, &people)
+	if len(people) != 2 {
+		t.Fatalf("admin sees %d people, want 2", len(people))
+	}
+	api.call("GET", "/api/person/search?q=budi", api.user, nil, 200, nil)
+	api.call("POST", "/api/person", api.user, gin.H{"name": "No Gender"}, 400, nil)
+
+	var updated Person
+	api.call("PUT", "/api/person/"+p.ID, api.user, gin.H{
+		"name": "Budi S.", "nickname": "Budi", "address": "Bandung", "status": "alive", "gender": "male",
+	}, 200, &updated)
+	if updated.Name != "Budi S." || updated.Version <= got.Version {
+		t.Fatalf("updated = %+v, was version %d", updated, got.Version)
+	}
+
+	var patched Person
+	api.call("PATCH", "/api/person/"+p.ID, api.user, strings.NewReader(`{"phone":"0812"}`), 200, &patched,
+		"Content-Type", "application/merge-patch+json")
+	if patched.Phone != "0812" || patched.Name != "Budi S." {
+		t.Fatalf("patched = %+v", patched)
+	}
+	api.call("PATCH", "/api/person/"+p.ID, api.user, strings.NewReader(`{"phone":"0813"}`), 412, nil,
+		"Content-Type", "application/merge-patch+json", "If-Match", etag(updated.Version))
+
+	api.call("PUT", "/api/person/"+p.ID+"/ownership", api.user, gin.H{"owners": []string{api.userID}}, 403, nil)
+	api.call("PUT", "/api/person/"+p.ID+"/ownership", api.admin, gin.H{"owners": []string{api.userID}}, 200, nil)
+
+	var preview PersonDeletion
+	api.call("DELETE", "/api/person/"+p.ID+"?preview=true", api.user, nil, 200, &preview)
+	api.call("GET", "/api/person/"+p.ID, api.user, nil, 200, nil)
+	api.call("DELETE", "/api/person/"+p.ID, api.user, nil, 200, nil)
+	api.call("GET", "/api/person/"+p.ID, api.user, nil, 404, nil)
+}
+
+func TestDeletingAFounderNeedsCascade(t *testing.T) {
+	api := newTestAPI(t)
+	p := api.createPerson(api.user, "Budi", "male")
+	f := api.createFamily(api.user, "Keluarga Budi", p.ID)
+
+	api.call("DELETE", "/api/person/"+p.ID, api.user, nil, 409, nil)
+	var deletion PersonDeletion
+	api.call("DELETE", "/api/person/"+p.ID+"?cascade=true", api.user, nil, 200, &deletion)
+	if len(deletion.Families) != 1 || deletion.Families[0].ID != f.ID {
+		t.Fatalf("deletion = %+v, want family %s", deletion, f.ID)
+	}
+	api.call("GET", "/api/family/"+f.ID, api.user, nil, 404, nil)
+
+	var events []SecurityEvent
+	api.call("GET", "/api/admin/security-log?action=person.delete", api.admin, nil, 200, &events)
+	if len(events) != 2 || events[0].Detail != "preview=false cascade=true" {
+		t.Fatalf("security log = %+v, want the cascade delete first", events)
+	}
+}
+
+func TestFamilyRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.user, "Budi", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	child := api.createPerson(api.user, "Andi", "male")
+	api.marry(api.user, husband, wife, child)
+	f := api.createFamily(api.user, "Keluarga Budi", husband.ID, wife.ID)
+
+	var got Family
+	api.call("GET", "/api/family/"+f.ID, api.user, nil, 200, &got)
+	if got.Name != "Keluarga Budi" || len(got.Founders) != 1 || got.Founders[0].Spouse != wife.ID {
+		t.Fatalf("family = %+v", got)
+	}
+	var families []Family
+	api.call("GET", "/api/family", api.user, nil, 200, &families)
+	if len(families) != 1 {
+		t.Fatalf("got %d families, want 1", len(families))
+	}
+
+	var members []Person
+	api.call("GET", "/api/family/"+f.ID+"/members", api.user, nil, 200, &members)
+	if len(members) != 3 {
+		t.Fatalf("got %d members, want the founders and their child", len(members))
+	}
+	api.call("GET", "/api/family/"+f.ID+"/tree", api.user, nil, 200, nil)
+
+	var updated Family
+	api.call("PUT", "/api/family/"+f.ID, api.user, gin.H{
+		"name": "Keluarga Besar", "founders": []gin.H{{"person": husband.ID}}, "membership": MembershipExplicit,
+		"members": []string{husband.ID, child.ID},
+	}, 200, &updated)
+	if updated.Name != "Keluarga Besar" || len(updated.Members) != 2 {
+		t.Fatalf("updated = %+v", updated)
+	}
+	api.call("POST", "/api/family", api.user, gin.H{"name": "No Founders"}, 400, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("cover", "cover.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("\x89PNG\r\n\x1a\n"))
+	form.Close()
+	var covered Family
+	api.call("PUT", "/api/family/"+f.ID+"/cover", api.user, &body, 200, &covered, "Content-Type", form.FormDataContentType())
+	if covered.CoverURL == "" {
+		t.Fatalf("covered = %+v, want a cover URL", covered)
+	}
+	var uncovered Family
+	api.call("DELETE", "/api/family/"+f.ID+"/cover", api.user, nil, 200, &uncovered)
+	if uncovered.CoverURL != "" {
+		t.Fatalf("cover URL = %q after deleting it", uncovered.CoverURL)
+	}
+
+	other := api.token(&Config{JWTSecret: "test-secret"}, api.createUser("Other", "other", RoleUser))
+	api.call("DELETE", "/api/family/"+f.ID, other, nil, 404, nil)
+	api.call("DELETE", "/api/family/"+f.ID, api.user, nil, 200, nil)
+	api.call("GET", "/api/family/"+f.ID, api.user, nil, 404, nil)
+}
+
+func TestRelationshipRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.user, "Budi", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	child := api.createPerson(api.user, "Andi", "male")
+	api.marry(api.user, husband, wife, child)
+
+	var rels []Relationship
+	api.call("GET", "/api/relationship/"+husband.ID, api.user, nil, 200, &rels)
+	if len(rels) != 2 {
+		t.Fatalf("husband has %d edges, want spouse and child", len(rels))
+	}
+	api.call("GET", "/api/relationship/"+child.ID, api.user, nil, 200, &rels)
+	if len(rels) != 2 {
+		t.Fatalf("child has %d edges, want the inverse child edges", len(rels))
+	}
+
+	api.call("POST", "/api/relationship/not-an-id", api.user, []gin.H{}, 400, nil)
+	api.call("POST", "/api/relationship/"+husband.ID+"?force=true", api.user, []gin.H{}, 403, nil)
+	api.call("POST", "/api/relationship/"+child.ID, api.user, []gin.H{{"to": child.ID, "type": "spouse"}}, 422, nil)
+
+	// marriage dates take the same forms as birthDate
+	api.call("POST", "/api/relationship/"+husband.ID, api.user, []gin.H{
+		{"to": wife.ID, "type": "spouse", "marriage": gin.H{"startDate": "1990-05-06", "place": "Bandung"}},
+	}, 201, nil)
+	api.call("GET", "/api/relationship/"+husband.ID, api.user, nil, 200, &rels)
+	if len(rels) != 1 || rels[0].Marriage == nil || rels[0].Marriage.StartDate == nil || rels[0].Marriage.StartDate.Year() != 1990 {
+		t.Fatalf("rels = %+v, want the dated marriage", rels)
+	}
+	api.call("POST", "/api/relationship/"+husband.ID, api.user, []gin.H{
+		{"to": wife.ID, "type": "spouse", "marriage": gin.H{"startDate": "May 1990"}},
+	}, 400, nil)
+}
+
+func TestTreeRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.user, "Budi", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	child := api.createPerson(api.user, "Andi", "male")
+	before := time.Now()
+	// revisions store milliseconds
+	time.Sleep(2 * time.Millisecond)
+	api.marry(api.user, husband, wife, child)
+
+	var tree []FamilyTreeNode
+	api.call("GET", "/api/tree/"+husband.ID, api.user, nil, 200, &tree)
+	if len(tree) == 0 {
+		t.Fatal("empty tree")
+	}
+	api.call("GET", "/api/tree/"+child.ID+"?mode=child", api.user, nil, 200, nil)
+	live := api.send("GET", "/api/tree/"+husband.ID, api.user, nil).Body.String()
+	past := api.send("GET", "/api/tree/"+husband.ID+"?asOf="+before.UTC().Format(time.RFC3339Nano), api.user, nil)
+	if past.Code != 200 || past.Body.String() == live || strings.Contains(past.Body.String(), child.ID) {
+		t.Fatalf("asOf before the marriage: status %d, body %s", past.Code, past.Body.String())
+	}
+	api.call("GET", "/api/tree/"+husband.ID+"?asOf=yesterday", api.user, nil, 400, nil)
+}
+
+func TestMergeRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	winner := api.createPerson(api.user, "Budi Santoso", "male")
+	loser := api.createPerson(api.user, "Budi Santoso", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	api.call("POST", "/api/relationship/"+loser.ID, api.user, []gin.H{{"to": wife.ID, "type": "spouse"}}, 201, nil)
+	f := api.createFamily(api.user, "Keluarga Budi", loser.ID, wife.ID)
+
+	var dups []DuplicateCandidate
+	api.call("GET", "/api/person/duplicates", api.user, nil, 200, &dups)
+	if len(dups) != 1 {
+		t.Fatalf("got %d duplicate pairs, want 1", len(dups))
+	}
+	api.call("GET", "/api/person/duplicates?minScore=2", api.user, nil, 400, nil)
+
+	api.call("POST", "/api/person/merge", api.user, gin.H{"winner": winner.ID, "loser": winner.ID}, 400, nil)
+	api.call("POST", "/api/person/merge", api.user, gin.H{"winner": winner.ID}, 400, nil)
+	var log MergeLog
+	api.call("POST", "/api/person/merge", api.user, gin.H{"winner": winner.ID, "loser": loser.ID}, 200, &log)
+	if log.ID == "" || len(log.Repointed) != 2 || len(log.FamiliesBefore) != 1 {
+		t.Fatalf("merge log = %+v", log)
+	}
+	api.call("GET", "/api/person/"+loser.ID, api.user, nil, 404, nil)
+	// the revert needs the loser back
+	api.call("DELETE", "/api/trash/people/"+loser.ID, api.admin, nil, 409, nil)
+	var family Family
+	api.call("GET", "/api/family/"+f.ID, api.user, nil, 200, &family)
+	if family.Founders[0].Person != winner.ID {
+		t.Fatalf("family founders = %+v after the merge, want %s", family.Founders, winner.ID)
+	}
+
+	api.call("POST", "/api/person/merge/"+log.ID+"/revert", api.user, nil, 200, nil)
+	api.call("POST", "/api/person/merge/"+log.ID+"/revert", api.user, nil, 409, nil)
+	api.call("POST", "/api/person/merge/000000000000000000000000/revert", api.user, nil, 404, nil)
+	api.call("GET", "/api/person/"+loser.ID, api.user, nil, 200, nil)
+	api.call("GET", "/api/family/"+f.ID, api.user, nil, 200, &family)
+	if family.Founders[0].Person != loser.ID {
+		t.Fatalf("family founders = %+v after the revert, want %s", family.Founders, loser.ID)
+	}
+	var rels []Relationship
+	api.call("GET", "/api/relationship/"+loser.ID, api.user, nil, 200, &rels)
+	if len(rels) != 1 || rels[0].To != wife.ID {
+		t.Fatalf("loser edges = %+v after the revert", rels)
+	}
+}
+
+func TestTrashRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.user, "Budi", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	api.call("POST", "/api/relationship/"+husband.ID, api.user, []gin.H{{"to": wife.ID, "type": "spouse"}}, 201, nil)
+	api.call("DELETE", "/api/person/"+husband.ID, api.user, nil, 200, nil)
+
+	var trash Trash
+	api.call("GET", "/api/trash", api.user, nil, 200, &trash)
+	if len(trash.People) != 1 || len(trash.Relationships) != 2 {
+		t.Fatalf("trash = %d people, %d relationships, want 1 and 2", len(trash.People), len(trash.Relationships))
+	}
+	api.call("POST", "/api/trash/people/"+husband.ID+"/restore", api.admin, nil, 200, nil)
+	api.call("POST", "/api/trash/people/"+husband.ID+"/restore", api.user, nil, 404, nil)
+	var rels []Relationship
+	api.call("GET", "/api/relationship/"+husband.ID, api.user, nil, 200, &rels)
+	if len(rels) != 1 {
+		t.Fatalf("husband has %d edges after the restore, want 1", len(rels))
+	}
+	api.call("POST", "/api/trash/pets/"+husband.ID+"/restore", api.user, nil, 400, nil)
+	api.call("POST", "/api/trash/people/not-an-id/restore", api.user, nil, 400, nil)
+
+	// an edge can't come back while one of its people is still deleted
+	api.call("DELETE", "/api/person/"+wife.ID, api.user, nil, 200, nil)
+	api.call("DELETE", "/api/person/"+husband.ID, api.user, nil, 200, nil)
+	api.call("GET", "/api/trash", api.user, nil, 200, &trash)
+	edge := trash.Relationships[0].ID
+	api.call("POST", "/api/trash/relationships/"+edge+"/restore", api.user, nil, 409, nil)
+
+	api.call("DELETE", "/api/trash/people/"+wife.ID, api.user, nil, 403, nil)
+	api.call("DELETE", "/api/trash/people/"+wife.ID, api.admin, nil, 200, nil)
+	api.call("DELETE", "/api/trash/people/"+wife.ID, api.admin, nil, 404, nil)
+	api.call("GET", "/api/trash", api.user, nil, 200, &trash)
+	if len(trash.People) != 1 || len(trash.Relationships) != 0 {
+		t.Fatalf("trash = %d people, %d relationships after the purge, want 1 and 0", len(trash.People), len(trash.Relationships))
+	}
+}
+
+func TestHistoryRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	p := api.createPerson(api.user, "Budi", "male")
+	api.call("PATCH", "/api/person/"+p.ID, api.user, strings.NewReader(`{"name":"Budi Santoso"}`), 200, nil,
+		"Content-Type", "application/merge-patch+json")
+
+	var revs []Revision
+	api.call("GET", "/api/history/people/"+p.ID, api.user, nil, 200, &revs)
+	if len(revs) != 2 || revs[0].Action != "update" || revs[1].Action != "create" {
+		t.Fatalf("history = %+v, want an update then the create", revs)
+	}
+	other := api.createUser("Other", "other", RoleUser)
+	otherToken := api.token(&Config{JWTSecret: "test-secret"}, other)
+	api.call("GET", "/api/history/people/"+p.ID, otherToken, nil, 404, nil)
+	api.call("GET", "/api/history/pets/"+p.ID, api.user, nil, 400, nil)
+
+	// the revert keeps the owners set since
+	api.call("PUT", "/api/person/"+p.ID+"/ownership", api.admin, gin.H{"owners": []string{api.userID, other.ID}}, 200, nil)
+	revert := "/api/history/people/" + p.ID + "/revert/" + revs[1].ID
+	api.call("POST", revert, api.user, nil, 428, nil)
+	api.call("POST", revert, api.user, nil, 412, nil, "If-Match", etag(2))
+	api.call("POST", revert, api.user, nil, 200, nil, "If-Match", etag(3))
+	var got Person
+	api.call("GET", "/api/person/"+p.ID, otherToken, nil, 200, &got)
+	if got.Name != "Budi" {
+		t.Fatalf("name = %q after reverting to the create, want Budi", got.Name)
+	}
+	api.call("POST", "/api/history/people/"+p.ID+"/revert/000000000000000000000000", api.user, nil, 404, nil, "If-Match", etag(got.Version))
+
+	// a deleted document comes back through the trash, not a revert
+	api.call("DELETE", "/api/person/"+p.ID, api.user, nil, 200, nil)
+	api.call("POST", revert, api.user, nil, 409, nil, "If-Match", etag(got.Version+1))
+}
+
+func TestHistoryRevertsTheInverseEdge(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.user, "Budi", "male")
+	wife := api.createPerson(api.user, "Siti", "female")
+	api.call("POST", "/api/relationship/"+husband.ID, api.user, []gin.H{{"to": wife.ID, "type": "spouse"}}, 201, nil)
+	api.call("POST", "/api/relationship/"+husband.ID, api.user, []gin.H{
+		{"to": wife.ID, "type": "spouse", "marriage": gin.H{"place": "Bandung"}},
+	}, 201, nil)
+
+	var rels []Relationship
+	api.call("GET", "/api/relationship/"+husband.ID, api.user, nil, 200, &rels)
+	edge := rels[0]
+	var revs []Revision
+	api.call("GET", "/api/history/relationships/"+edge.ID, api.user, nil, 200, &revs)
+	if len(revs) != 2 || revs[1].Action != RevisionCreate {
+		t.Fatalf("history = %+v, want an update then the create", revs)
+	}
+	api.call("POST", "/api/history/relationships/"+edge.ID+"/revert/"+revs[1].ID, api.user, nil, 200, nil, "If-Match", etag(edge.Version))
+
+	var inverse []Relationship
+	api.call("GET", "/api/relationship/"+wife.ID, api.user, nil, 200, &inverse)
+	if len(inverse) != 1 || inverse[0].Marriage != nil {
+		t.Fatalf("inverse edges = %+v, want the marriage reverted on both sides", inverse)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	api := newTestAPI(t)
+	husband := api.createPerson(api.admin, "Budi", "male")
+	wife := api.createPerson(api.admin, "Siti", "female")
+	api.call("POST", "/api/relationship/"+husband.ID, api.admin, []gin.H{{"to": wife.ID, "type": "spouse"}}, 201, nil)
+	api.call("POST", "/api/auth/signin", "", gin.H{"username": "user", "password": "wrong"}, 401, nil)
+
+	var report AuditReport
+	api.call("GET", "/api/admin/audit", api.admin, nil, 200, &report)
+	if report.People != 2 || report.Relationships != 2 || len(report.Findings) != 0 {
+		t.Fatalf("audit = %+v", report)
+	}
+	api.call("POST", "/api/admin/audit/fix", api.admin, nil, 200, nil)
+	api.call("POST", "/api/admin/audit/fix", api.admin, gin.H{"codes": "all"}, 400, nil)
+	api.call("GET", "/api/admin/audit", api.user, nil, 403, nil)
+
+	var events []SecurityEvent
+	api.call("GET", "/api/admin/security-log?action=auth.signin&outcome="+OutcomeDenied, api.admin, nil, 200, &events)
+	if len(events) != 1 || events[0].Target != "user" {
+		t.Fatalf("security log = %+v, want the failed signin", events)
+	}
+	w := api.send("GET", "/api/admin/security-log/export", api.admin, nil)
+	if w.Code != 200 || strings.Count(w.Body.String(), "\n") < 3 {
+		t.Fatalf("export: status %d, body %q", w.Code, w.Body.String())
+	}
+	// the denied request above was logged too
+	api.call("GET", "/api/admin/security-log?action=auth.authorize", api.admin, nil, 200, &events)
+	if len(events) != 1 {
+		t.Fatalf("got %d authorize events, want 1", len(events))
+	}
+
+	var cfg Config
+	api.call("GET", "/api/admin/config", api.admin, nil, 200, &cfg)
+	if cfg.JWTSecret == "test-secret" {
+		t.Fatal("config dump shows the JWT secret")
+	}
+	api.call("GET", "/api/admin/routes", api.admin, nil, 200, nil)
+}
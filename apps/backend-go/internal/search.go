@@ -0,0 +1,245 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	// minSearchScore drops candidates that only share a trigram or two with the query.
+	minSearchScore = 0.4
+)
+
+// SearchResult is one ranked person search hit. Highlights holds the matched fields with
+// the matching words wrapped in <mark>; the rest of the text is HTML-escaped.
+type SearchResult struct {
+	Person     *Person           `json:"person"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// searchCandidate is a person found by the text index or by shared search keys.
+type searchCandidate struct {
+	person    *Person
+	textMatch bool
+}
+
+// phoneticReplacer rewrites pre-1972 Indonesian spelling and Arabic/Dutch transliterations
+// to their modern forms: Soekarno/Sukarno, Djoko/Joko, Tjahjo/Cahyo, Achmad/Ahmad.
+var phoneticReplacer = strings.NewReplacer("oe", "u", "dj", "j", "tj", "c", "sj", "sy", "nj", "ny", "kh", "h", "ch", "h", "ph", "f")
+
+// phoneticKey reduces a normalized word to a consonant skeleton, so spelling variants of
+// the same name share a key: Muhammad, Mohamad and Muhamad are all "mhmd", Yahya and the
+// old spelling Jahja both "yh", Setiawan and Setyawan both "stwn". Vowels after the first
+// letter and h after a consonant are dropped; a leading vowel becomes "a" so Umar and
+// Omar still match.
+func phoneticKey(word string) string {
+	var b []rune
+	prev := rune(0)
+	for i, r := range []rune(phoneticReplacer.Replace(word)) {
+		switch r {
+		case 'j':
+			r = 'y' // old spelling j is modern y
+		case 'v':
+			r = 'f'
+		case 'q':
+			r = 'k'
+		case 'z', 'x':
+			r = 's'
+		}
+		afterVowel := strings.ContainsRune("aeiouy", prev)
+		prev = r
+		switch {
+		case i == 0 && strings.ContainsRune("aeiou", r):
+			r = 'a'
+		case i > 0 && strings.ContainsRune("aeiouy", r):
+			continue
+		case r == 'h' && i > 0 && !afterVowel:
+			continue // Muhammad/Muchammad, Sjah/Syah
+		}
+		if len(b) > 0 && b[len(b)-1] == r {
+			continue
+		}
+		b = append(b, r)
+	}
+	return string(b)
+}
+
+// wordTrigrams returns the trigrams of a word padded with "$" at both ends, so short words
+// and word boundaries still produce keys.
+func wordTrigrams(word string) []string {
+	runes := []rune("$" + word + "$")
+	out := []string{}
+	for i := 0; i+3 <= len(runes); i++ {
+		out = append(out, string(runes[i:i+3]))
+	}
+	return out
+}
+
+// personSearchKeys derives the searchKeys of a person from its name and nickname: every
+// normalized word ("w:"), its phonetic key ("p:") and its trigrams ("t:"). The same
+// function turns a query into the keys to look up.
+func personSearchKeys(fields ...string) []string {
+	set := map[string]struct{}{}
+	for _, f := range fields {
+		for _, w := range strings.Fields(normalizeName(f)) {
+			set["w:"+w] = struct{}{}
+			set["p:"+phoneticKey(w)] = struct{}{}
+			for _, g := range wordTrigrams(w) {
+				set["t:"+g] = struct{}{}
+			}
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// wordScore rates how well a person's word matches a query word, from 0 to 1: exact,
+// then prefix (for type-ahead), then same phonetic key, then bigram similarity for typos.
+func wordScore(query, word string) float64 {
+	switch {
+	case query == word:
+		return 1
+	case len([]rune(query)) >= 2 && strings.HasPrefix(word, query):
+		return 0.9
+	case phoneticKey(query) == phoneticKey(word):
+		return 0.8
+	}
+	return 0.9 * nameSimilarity(query, word)
+}
+
+// bestWordScore is the best wordScore of query against any word of text.
+func bestWordScore(query, text string) float64 {
+	best := 0.0
+	for _, w := range strings.Fields(normalizeName(text)) {
+		best = max(best, wordScore(query, w))
+	}
+	return best
+}
+
+// scoreSearchCandidate averages, over the query words, the best match in the name or
+// nickname (address matches count for half), plus a bonus for text index hits.
+func scoreSearchCandidate(queryWords []string, c searchCandidate) float64 {
+	if len(queryWords) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, qw := range queryWords {
+		total += max(bestWordScore(qw, c.person.Name), bestWordScore(qw, c.person.Nickname), 0.5*bestWordScore(qw, c.person.Address))
+	}
+	score := total / float64(len(queryWords))
+	if c.textMatch {
+		score += 0.1
+	}
+	return min(score, 1)
+}
+
+// highlight HTML-escapes text and wraps each word matching a query word in <mark>. It
+// returns "" when nothing matched.
+func highlight(text string, queryWords []string) string {
+	var b strings.Builder
+	matched := false
+	runes := []rune(text)
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) }
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && isWord(runes[j]) == isWord(runes[i]) {
+			j++
+		}
+		seg := string(runes[i:j])
+		hit := false
+		if isWord(runes[i]) {
+			w := normalizeName(seg)
+			for _, qw := range queryWords {
+				if wordScore(qw, w) >= 0.6 {
+					hit = true
+					break
+				}
+			}
+		}
+		if hit {
+			matched = true
+			b.WriteString("<mark>" + html.EscapeString(seg) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(seg))
+		}
+		i = j
+	}
+	if !matched {
+		return ""
+	}
+	return b.String()
+}
+
+// searchPeople finds people matching query among those ownedBy (nil for everyone) and
+// returns at most limit results, best first.
+func searchPeople(ctx context.Context, ownedBy []string, query string, limit int) ([]SearchResult, error) {
+	queryWords := strings.Fields(normalizeName(query))
+	if len(queryWords) == 0 {
+		return []SearchResult{}, nil
+	}
+	keys := personSearchKeys(query)
+	// require a third of the query's trigrams so common ones don't pull in half the collection
+	trigrams := 0
+	for _, k := range keys {
+		if strings.HasPrefix(k, "t:") {
+			trigrams++
+		}
+	}
+	candidates, err := storesFrom(ctx).People.Search(ctx, ownedBy, strings.Join(queryWords, " "), keys, max(1, trigrams/3))
+	if err != nil {
+		return nil, fmt.Errorf("search people: %w", err)
+	}
+	res := []SearchResult{}
+	for _, c := range candidates {
+		score := scoreSearchCandidate(queryWords, c)
+		if score < minSearchScore {
+			continue
+		}
+		hl := map[string]string{}
+		for field, text := range map[string]string{"name": c.person.Name, "nickname": c.person.Nickname, "address": c.person.Address} {
+			if h := highlight(text, queryWords); h != "" {
+				hl[field] = h
+			}
+		}
+		res = append(res, SearchResult{Person: c.person, Score: float64(int(score*1000+0.5)) / 1000, Highlights: hl})
+	}
+	slices.SortStableFunc(res, func(a, b SearchResult) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Person.Name, b.Person.Name)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// StartSearchKeyBackfill adds searchKeys to people created before search existed, in the
+// background. It is a no-op once every person has keys.
+func StartSearchKeyBackfill(ctx context.Context) {
+	go func() {
+		n, err := backfillSearchKeysRepo(ctx)
+		if err != nil {
+			logger(logSearch).ErrorContext(ctx, "search key backfill failed", "updated", n, "error", err)
+			return
+		}
+		if n > 0 {
+			logger(logSearch).InfoContext(ctx, "search key backfill done", "updated", n)
+		}
+	}()
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Security log outcomes, derived from the response status.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// SecurityEvent is one entry of the security log: who did what to which target, from
+// where, and whether it worked.
+type SecurityEvent struct {
+	ID            string    `json:"_id"`
+	Actor         string    `json:"actor,omitempty"`
+	ActorUsername string    `json:"actorUsername,omitempty"`
+	Action        string    `json:"action"`
+	Target        string    `json:"target"`
+	IP            string    `json:"ip"`
+	Outcome       string    `json:"outcome"`
+	Status        int       `json:"status"`
+	Detail        string    `json:"detail,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// SecurityLogFilter narrows a security log query. Zero fields don't filter.
+type SecurityLogFilter struct {
+	Actor   string
+	Action  string
+	Target  string
+	IP      string
+	Outcome string
+	From    time.Time
+	To      time.Time
+}
+
+// securityOutcome maps a response status to a log outcome.
+func securityOutcome(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	case status >= 400:
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// securityLog records a security-sensitive request once its handler has responded, so the
+// outcome reflects the status actually sent. Use it as
+// `defer securityLog(c, "person.delete", id, "")`. Write failures are only logged.
+func securityLog(c *gin.Context, action, target, detail string) {
+	status := c.Writer.Status()
+	ev := &SecurityEvent{
+		Action:  action,
+		Target:  target,
+		IP:      c.ClientIP(),
+		Outcome: securityOutcome(status),
+		Status:  status,
+		Detail:  detail,
+		At:      time.Now(),
+	}
+	if u, ok := c.Get("user"); ok {
+		if user, ok := u.(*User); ok {
+			ev.Actor = user.ID
+			ev.ActorUsername = user.Username
+		}
+	}
+	// the request may already be finishing; don't let that drop the entry
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c), 5*time.Second)
+	defer cancel()
+	if err := storesFrom(ctx).SecurityLog.Insert(ctx, ev); err != nil {
+		logger(logSecurity).ErrorContext(ctx, "failed to record security event", "action", action, "target", target, "error", err)
+	}
+}
+
+// parseSecurityLogFilter reads the filter from query parameters. from/to accept RFC3339
+// timestamps or plain dates.
+func parseSecurityLogFilter(c *gin.Context) (SecurityLogFilter, error) {
+	f := SecurityLogFilter{
+		Actor:   c.Query("actor"),
+		Action:  c.Query("action"),
+		Target:  c.Query("target"),
+		IP:      c.Query("ip"),
+		Outcome: c.Query("outcome"),
+	}
+	parse := func(name string) (time.Time, error) {
+		v := c.Query(name)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s date", name)
+		}
+		return t, nil
+	}
+	var err error
+	if f.From, err = parse("from"); err != nil {
+		return f, err
+	}
+	if f.To, err = parse("to"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
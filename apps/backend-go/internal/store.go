@@ -4,19 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"reflect"
+	"slices"
 	"time"
 
-	"github.com/newrelic/go-agent/v3/newrelic"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-
 func findUserById(ctx context.Context, id string) (*User, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/findUserById").End()
+	defer startSpan(ctx, "store/findUserById").End()
 
 	cacheKey := cacheKeyUser(id)
 	if cached, ok := cacheGet[*User](ctx, cacheKey); ok {
@@ -28,24 +28,24 @@ func findUserById(ctx context.Context, id string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
-	var u User
+	var doc userDocument
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	// Soft delete: exclude deleted documents
 	filter := bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}
-	if err := col.FindOne(ctx, filter).Decode(&u); err != nil {
+	if err := col.FindOne(ctx, filter).Decode(&doc); err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, errors.New("not found")
 		}
 		return nil, err
 	}
-	u.ID = id
+	u := doc.user()
 	cacheSet(ctx, cacheKey, &u, cacheTTLUser)
 	return &u, nil
 }
 
 func findUserByUsername(ctx context.Context, username string) (*User, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/findUserByUsername").End()
+	defer startSpan(ctx, "store/findUserByUsername").End()
 
 	cacheKey := cacheKeyUsername(username)
 	if cached, ok := cacheGet[*User](ctx, cacheKey); ok {
@@ -56,35 +56,20 @@ func findUserByUsername(ctx context.Context, username string) (*User, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var doc bson.M
+	var doc userDocument
 	// Soft delete: exclude deleted documents
 	filter := bson.M{"username": username, "deleted": bson.M{"$ne": true}}
 	if err := col.FindOne(ctx, filter).Decode(&doc); err != nil {
 		return nil, err
 	}
 
-	var u User
-	if idv, ok := doc["_id"].(primitive.ObjectID); ok {
-		u.ID = idv.Hex()
-	}
-	if n, ok := doc["name"].(string); ok {
-		u.Name = n
-	}
-	if un, ok := doc["username"].(string); ok {
-		u.Username = un
-	}
-	if p, ok := doc["password"].(string); ok {
-		u.Password = p
-	}
-	if r, ok := doc["role"].(string); ok {
-		u.Role = UserRole(r)
-	}
+	u := doc.user()
 	cacheSet(ctx, cacheKey, &u, cacheTTLUser)
 	return &u, nil
 }
 
 func addUser(ctx context.Context, u *User) (*User, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/addUser").End()
+	defer startSpan(ctx, "store/addUser").End()
 	col := MongoDB.Collection("users")
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -98,8 +83,33 @@ func addUser(ctx context.Context, u *User) (*User, error) {
 	return findUserById(ctx, oid.Hex())
 }
 
+// setUserPasswordRepo replaces the password hash of a live user.
+func setUserPasswordRepo(ctx context.Context, id, hash string) error {
+	defer startSpan(ctx, "store/setUserPasswordRepo").End()
+	col := MongoDB.Collection("users")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := col.UpdateOne(ctx, bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	// the cached user carries the hash sign-in compares against
+	user, err := findUserById(ctx, id)
+	if err == nil {
+		cacheDel(ctx, cacheKeyUser(id), cacheKeyUsername(user.Username))
+	}
+	return nil
+}
+
 func repoGetAllPeople(ctx context.Context, ownedBy []string) ([]*Person, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/repoGetAllPeople").End()
+	defer startSpan(ctx, "store/repoGetAllPeople").End()
 
 	cacheKey := cacheKeyPeople(ownedBy)
 	if cached, ok := cacheGet[[]*Person](ctx, cacheKey); ok {
@@ -130,15 +140,41 @@ func repoGetAllPeople(ctx context.Context, ownedBy []string) ([]*Person, error)
 		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
 	}
 
-	// lookup owners (users)
-	pipeline = append(pipeline, bson.D{{Key: "$lookup", Value: bson.D{
+	pipeline = append(pipeline, personOwnersLookup(), personRelationshipsLookup())
+
+	// project owners fields (remove passwords)
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.D{{Key: "password", Value: 0}}}})
+
+	cur, err := col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []*Person{}
+	for cur.Next(ctx) {
+		var doc personDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		res = append(res, doc.person())
+	}
+	cacheSet(ctx, cacheKey, res, cacheTTLPeople)
+	return res, nil
+}
+
+// personOwnersLookup populates "owners" with the users in ownedBy.
+func personOwnersLookup() bson.D {
+	return bson.D{{Key: "$lookup", Value: bson.D{
 		{Key: "from", Value: "users"},
 		{Key: "localField", Value: "ownedBy"},
 		{Key: "foreignField", Value: "_id"},
 		{Key: "as", Value: "owners"},
-	}}})
+	}}}
+}
 
-	// lookup relationships for each person and populate toDetails inside the lookup pipeline
+// personRelationshipsLookup populates "relationships" with the person's live outgoing edges,
+// each with its target person in toDetails.
+func personRelationshipsLookup() bson.D {
 	relPipeline := bson.A{
 		bson.D{{Key: "$match", Value: bson.D{
 			{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$from", "$$pid"}}}},
@@ -146,173 +182,185 @@ func repoGetAllPeople(ctx context.Context, ownedBy []string) ([]*Person, error)
 		}}},
 		bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "people"}, {Key: "localField", Value: "to"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "toDetails"}}}},
 	}
-	pipeline = append(pipeline, bson.D{{Key: "$lookup", Value: bson.D{
+	return bson.D{{Key: "$lookup", Value: bson.D{
 		{Key: "from", Value: "relationships"},
 		{Key: "let", Value: bson.D{{Key: "pid", Value: "$_id"}}},
 		{Key: "pipeline", Value: relPipeline},
 		{Key: "as", Value: "relationships"},
-	}}})
+	}}}
+}
 
-	// project owners fields (remove passwords)
-	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.D{{Key: "password", Value: 0}}}})
+// listPeopleRepo returns one page of people. Owners and relationships are only looked up
+// when q includes them. Pages are not cached.
+func listPeopleRepo(ctx context.Context, ownedBy []string, q *listQuery) ([]*Person, string, error) {
+	defer startSpan(ctx, "store/listPeopleRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	scope := bson.M{"deleted": bson.M{"$ne": true}}
+	if ownedBy != nil {
+		scope["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	pipeline := q.pipeline(scope)
+	if q.Include["owners"] {
+		pipeline = append(pipeline, personOwnersLookup(), bson.D{{Key: "$project", Value: bson.D{{Key: "owners.password", Value: 0}}}})
+	}
+	if q.Include["relationships"] {
+		pipeline = append(pipeline, personRelationshipsLookup())
+	}
+	if proj := q.projection("owners", "relationships"); proj != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: proj}})
+	}
+	docs, err := aggregateDocs(ctx, MongoDB.Collection("people"), pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	docs, next := q.page(docs)
+	res := []*Person{}
+	for _, doc := range docs {
+		res = append(res, decodePersonListDoc(doc))
+	}
+	return res, next, nil
+}
 
+// aggregateDocs runs pipeline and decodes every result document.
+func aggregateDocs(ctx context.Context, col *mongo.Collection, pipeline mongo.Pipeline) ([]bson.M, error) {
 	cur, err := col.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
-	res := []*Person{}
+	docs := []bson.M{}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// searchPeopleRepo collects search candidates from two sources: the text index over name,
+// nickname and address, and people sharing at least minHits searchKeys with the query
+// (typos and spelling variants the text index misses). Each source is capped at 200
+// documents.
+func searchPeopleRepo(ctx context.Context, ownedBy []string, text string, keys []string, minHits int) ([]searchCandidate, error) {
+	defer startSpan(ctx, "store/searchPeopleRepo").End()
+	col := MongoDB.Collection("people")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	scope := bson.M{"deleted": bson.M{"$ne": true}}
+	if ownedBy != nil {
+		scope["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	byID := map[string]*searchCandidate{}
+	order := []string{}
+	add := func(doc bson.M, textMatch bool) {
+		p := decodePersonListDoc(doc)
+		if c, ok := byID[p.ID]; ok {
+			c.textMatch = c.textMatch || textMatch
+			return
+		}
+		byID[p.ID] = &searchCandidate{person: p, textMatch: textMatch}
+		order = append(order, p.ID)
+	}
+
+	textFilter := bson.M{"$and": bson.A{scope, bson.M{"$text": bson.M{"$search": text}}}}
+	opts := options.Find().
+		SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}, "searchKeys": 0}).
+		SetSort(bson.M{"score": bson.M{"$meta": "textScore"}}).
+		SetLimit(200)
+	cur, err := col.Find(ctx, textFilter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var docs []bson.M
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		add(doc, true)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{scope, bson.M{"searchKeys": bson.M{"$in": keys}}}}}},
+		{{Key: "$addFields", Value: bson.M{"hits": bson.M{"$size": bson.M{"$setIntersection": bson.A{"$searchKeys", keys}}}}}},
+		{{Key: "$match", Value: bson.M{"hits": bson.M{"$gte": minHits}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "hits", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: 200}},
+		{{Key: "$project", Value: bson.M{"searchKeys": 0}}},
+	}
+	docs, err = aggregateDocs(ctx, col, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		add(doc, false)
+	}
+
+	res := make([]searchCandidate, 0, len(order))
+	for _, id := range order {
+		res = append(res, *byID[id])
+	}
+	return res, nil
+}
+
+// backfillSearchKeysRepo sets searchKeys on people written before search existed. It
+// doesn't bump versions or record revisions: the keys are derived from existing fields.
+func backfillSearchKeysRepo(ctx context.Context) (int, error) {
+	defer startSpan(ctx, "store/backfillSearchKeysRepo").End()
+	col := MongoDB.Collection("people")
+	cur, err := col.Find(ctx, bson.M{"searchKeys": bson.M{"$exists": false}}, options.Find().SetProjection(bson.M{"name": 1, "nickname": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+	n := 0
+	models := []mongo.WriteModel{}
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		if _, err := col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		n += len(models)
+		models = models[:0]
+		return nil
+	}
 	for cur.Next(ctx) {
 		var doc bson.M
 		if err := cur.Decode(&doc); err != nil {
 			continue
 		}
-		// map bson.M to Person
-		var p Person
-		// basic fields
-		if v, ok := doc["_id"].(primitive.ObjectID); ok {
-			p.ID = v.Hex()
-		}
-		if v, ok := doc["name"].(string); ok {
-			p.Name = v
-		}
-		if v, ok := doc["nickname"].(string); ok {
-			p.Nickname = v
-		}
-		if v, ok := doc["address"].(string); ok {
-			p.Address = v
-		}
-		if v, ok := doc["status"].(string); ok {
-			p.Status = v
-		}
-		if v, ok := doc["gender"].(string); ok {
-			p.Gender = v
-		}
-		if v, ok := doc["phone"].(string); ok {
-			p.Phone = v
-		}
-		if v, ok := doc["photoUrl"].(string); ok {
-			p.PhotoURL = v
-		}
-		// birthDate may be stored as primitive.DateTime or as an ISO string
-		if bd, ok := doc["birthDate"].(primitive.DateTime); ok {
-			p.BirthDate = bd.Time()
-		} else if bdStr, ok := doc["birthDate"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-				p.BirthDate = t
-			}
-		}
-		if v, ok := doc["ownedBy"].(primitive.A); ok {
-			ids := []string{}
-			for _, it := range v {
-				switch vv := it.(type) {
-				case primitive.ObjectID:
-					ids = append(ids, vv.Hex())
-				case string:
-					ids = append(ids, vv)
-				}
-			}
-			p.OwnedBy = ids
-		}
-		// owners population
-		if ownersRaw, ok := doc["owners"].(primitive.A); ok {
-			owners := []User{}
-			for _, or := range ownersRaw {
-				if m, ok := or.(bson.M); ok {
-					var u User
-					if idv, ok := m["_id"].(primitive.ObjectID); ok {
-						u.ID = idv.Hex()
-					}
-					if n, ok := m["name"].(string); ok {
-						u.Name = n
-					}
-					if un, ok := m["username"].(string); ok {
-						u.Username = un
-					}
-					if r, ok := m["role"].(string); ok {
-						u.Role = UserRole(r)
-					}
-					owners = append(owners, u)
-				}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": doc["_id"]}).
+			SetUpdate(bson.M{"$set": bson.M{"searchKeys": searchKeysOf(doc)}}))
+		if len(models) == 500 {
+			if err := flush(); err != nil {
+				return n, err
 			}
-			p.Owners = owners
 		}
-
-		// relationships population: relationships is an array of relationship docs where "toDetails" is already populated
-		if relsRaw, ok := doc["relationships"].(primitive.A); ok {
-			rels := []*Relationship{}
-			for _, rr := range relsRaw {
-				if rm, ok := rr.(bson.M); ok {
-					var r Relationship
-					if idv, ok := rm["_id"].(primitive.ObjectID); ok {
-						r.ID = idv.Hex()
-					}
-					if f, ok := rm["from"].(primitive.ObjectID); ok {
-						r.From = f.Hex()
-					} else if fS, ok := rm["from"].(string); ok {
-						r.From = fS
-					}
-					if t, ok := rm["to"].(primitive.ObjectID); ok {
-						r.To = t.Hex()
-					} else if tS, ok := rm["to"].(string); ok {
-						r.To = tS
-					}
-					if ty, ok := rm["type"].(string); ok {
-						r.Type = ty
-					}
-					if ord, ok := rm["order"].(int32); ok {
-						r.Order = int(ord)
-					}
-					if tdArr, ok := rm["toDetails"].(primitive.A); ok && len(tdArr) > 0 {
-						if td, ok := tdArr[0].(bson.M); ok {
-							var tp Person
-							if idv, ok := td["_id"].(primitive.ObjectID); ok {
-								tp.ID = idv.Hex()
-							}
-							if n, ok := td["name"].(string); ok {
-								tp.Name = n
-							}
-							if nick, ok := td["nickname"].(string); ok {
-								tp.Nickname = nick
-							}
-							if address, ok := td["address"].(string); ok {
-								tp.Address = address
-							}
-							if ph, ok := td["photoUrl"].(string); ok {
-								tp.PhotoURL = ph
-							}
-							if bd, ok := td["birthDate"].(primitive.DateTime); ok {
-								tp.BirthDate = bd.Time()
-							} else if bdStr, ok := td["birthDate"].(string); ok {
-								if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-									tp.BirthDate = t
-								}
-							}
-							if phn, ok := td["phone"].(string); ok {
-								tp.Phone = phn
-							}
-							if st, ok := td["status"].(string); ok {
-								tp.Status = st
-							}
-							if gd, ok := td["gender"].(string); ok {
-								tp.Gender = gd
-							}
-							r.ToDetails = &tp
-						}
-					}
-					rels = append(rels, &r)
-				}
-			}
-			p.Relationships = rels
-		}
-		res = append(res, &p)
 	}
-	cacheSet(ctx, cacheKey, res, cacheTTLPeople)
-	return res, nil
+	if err := cur.Err(); err != nil {
+		return n, err
+	}
+	return n, flush()
+}
+
+// searchKeysOf derives searchKeys from a raw people document.
+func searchKeysOf(doc bson.M) []string {
+	name, _ := doc["name"].(string)
+	nickname, _ := doc["nickname"].(string)
+	return personSearchKeys(name, nickname)
+}
+
+// decodePersonListDoc maps a raw people document to a Person, populating owners and
+// relationships (with toDetails) when the pipeline looked them up.
+func decodePersonListDoc(doc bson.M) *Person {
+	return decodeM[personDocument](doc).person()
 }
 
 func getPersonByIdRepo(ctx context.Context, id string) (*Person, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/getPersonByIdRepo").End()
+	defer startSpan(ctx, "store/getPersonByIdRepo").End()
 
 	cacheKey := cacheKeyPerson(id)
 	if cached, ok := cacheGet[*Person](ctx, cacheKey); ok {
@@ -327,18 +375,9 @@ func getPersonByIdRepo(ctx context.Context, id string) (*Person, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	relPipeline := bson.A{
-		bson.D{{Key: "$match", Value: bson.D{
-			{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$from", "$$pid"}}}},
-			{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}},
-		}}},
-		bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "people"}, {Key: "localField", Value: "to"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "toDetails"}}}},
-	}
-
 	pipeline := mongo.Pipeline{
 		bson.D{{Key: "$match", Value: bson.D{{Key: "_id", Value: oid}, {Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}}}}},
-		bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "users"}, {Key: "localField", Value: "ownedBy"}, {Key: "foreignField", Value: "_id"}, {Key: "as", Value: "owners"}}}},
-		bson.D{{Key: "$lookup", Value: bson.D{{Key: "from", Value: "relationships"}, {Key: "let", Value: bson.D{{Key: "pid", Value: "$_id"}}}, {Key: "pipeline", Value: relPipeline}, {Key: "as", Value: "relationships"}}}},
+		personOwnersLookup(),
 		bson.D{{Key: "$project", Value: bson.D{{Key: "password", Value: 0}}}},
 	}
 
@@ -348,113 +387,51 @@ func getPersonByIdRepo(ctx context.Context, id string) (*Person, error) {
 	}
 	defer cur.Close(ctx)
 	if cur.Next(ctx) {
-		var doc bson.M
+		var doc personDocument
 		if err := cur.Decode(&doc); err != nil {
 			return nil, err
 		}
-		var p Person
-		if v, ok := doc["_id"].(primitive.ObjectID); ok {
-			p.ID = v.Hex()
-		}
-		if v, ok := doc["name"].(string); ok {
-			p.Name = v
-		}
-		if v, ok := doc["nickname"].(string); ok {
-			p.Nickname = v
-		}
-		if v, ok := doc["address"].(string); ok {
-			p.Address = v
-		}
-		if v, ok := doc["status"].(string); ok {
-			p.Status = v
-		}
-		if v, ok := doc["gender"].(string); ok {
-			p.Gender = v
-		}
-		if v, ok := doc["phone"].(string); ok {
-			p.Phone = v
-		}
-		if v, ok := doc["photoUrl"].(string); ok {
-			p.PhotoURL = v
-		}
-		// birthDate may be stored as primitive.DateTime or as an ISO string
-		if bd, ok := doc["birthDate"].(primitive.DateTime); ok {
-			p.BirthDate = bd.Time()
-		} else if bdStr, ok := doc["birthDate"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-				p.BirthDate = t
-			}
-		}
-		if v, ok := doc["ownedBy"].(primitive.A); ok {
-			ids := []string{}
-			for _, it := range v {
-				switch vv := it.(type) {
-				case primitive.ObjectID:
-					ids = append(ids, vv.Hex())
-				case string:
-					ids = append(ids, vv)
-				}
-			}
-			p.OwnedBy = ids
-		}
-		if ownersRaw, ok := doc["owners"].(primitive.A); ok {
-			owners := []User{}
-			for _, or := range ownersRaw {
-				if m, ok := or.(bson.M); ok {
-					var u User
-					if idv, ok := m["_id"].(primitive.ObjectID); ok {
-						u.ID = idv.Hex()
-					}
-					if n, ok := m["name"].(string); ok {
-						u.Name = n
-					}
-					if un, ok := m["username"].(string); ok {
-						u.Username = un
-					}
-					if r, ok := m["role"].(string); ok {
-						u.Role = UserRole(r)
-					}
-					owners = append(owners, u)
-				}
-			}
-			p.Owners = owners
-		}
-		cacheSet(ctx, cacheKey, &p, cacheTTLPerson)
-		return &p, nil
+		p := doc.person()
+		cacheSet(ctx, cacheKey, p, cacheTTLPerson)
+		return p, nil
 	}
 	return nil, mongo.ErrNoDocuments
 }
 
+// personFields is the stored form of p's editable fields, with its search keys.
+func personFields(p *Person) bson.M {
+	return bson.M{
+		"name":       p.Name,
+		"nickname":   p.Nickname,
+		"address":    p.Address,
+		"status":     p.Status,
+		"gender":     p.Gender,
+		"birthDate":  p.BirthDate,
+		"phone":      p.Phone,
+		"photoUrl":   p.PhotoURL,
+		"ownedBy":    objectIDs(p.OwnedBy),
+		"searchKeys": personSearchKeys(p.Name, p.Nickname),
+	}
+}
+
 func createPersonRepo(ctx context.Context, p *Person) (*Person, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/createPersonRepo").End()
+	defer startSpan(ctx, "store/createPersonRepo").End()
 	col := MongoDB.Collection("people")
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Convert ownedBy string array to ObjectID array
-	ownedByOIDs := []primitive.ObjectID{}
-	for _, ownerID := range p.OwnedBy {
-		if oid, err := primitive.ObjectIDFromHex(ownerID); err == nil {
-			ownedByOIDs = append(ownedByOIDs, oid)
-		}
-	}
-
-	doc := bson.M{
-		"name":      p.Name,
-		"nickname":  p.Nickname,
-		"address":   p.Address,
-		"status":    p.Status,
-		"gender":    p.Gender,
-		"birthDate": p.BirthDate,
-		"phone":     p.Phone,
-		"photoUrl":  p.PhotoURL,
-		"ownedBy":   ownedByOIDs,
+	oid := primitive.NewObjectID()
+	doc := personFields(p)
+	doc["_id"] = oid
+	doc["version"] = 1
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "people", oid.Hex()); err != nil {
+		return nil, err
 	}
-	res, err := col.InsertOne(ctx, doc)
-	if err != nil {
+	if _, err := col.InsertOne(ctx, doc); err != nil {
 		return nil, err
 	}
-	oid := res.InsertedID.(primitive.ObjectID)
+	tracker.record(ctx)
 	created, err := getPersonByIdRepo(ctx, oid.Hex())
 	if err == nil {
 		cacheDelPattern(ctx, "ft:people:*")
@@ -463,7 +440,7 @@ func createPersonRepo(ctx context.Context, p *Person) (*Person, error) {
 }
 
 func updatePersonRepo(ctx context.Context, p *Person) (*Person, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/updatePersonRepo").End()
+	defer startSpan(ctx, "store/updatePersonRepo").End()
 	col := MongoDB.Collection("people")
 	oid, err := primitive.ObjectIDFromHex(p.ID)
 	if err != nil {
@@ -472,30 +449,23 @@ func updatePersonRepo(ctx context.Context, p *Person) (*Person, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// Convert ownedBy string array to ObjectID array
-	ownedByOIDs := []primitive.ObjectID{}
-	for _, ownerID := range p.OwnedBy {
-		if oid, err := primitive.ObjectIDFromHex(ownerID); err == nil {
-			ownedByOIDs = append(ownedByOIDs, oid)
-		}
-	}
-
-	update := bson.M{"$set": bson.M{
-		"name":      p.Name,
-		"nickname":  p.Nickname,
-		"address":   p.Address,
-		"status":    p.Status,
-		"gender":    p.Gender,
-		"birthDate": p.BirthDate,
-		"phone":     p.Phone,
-		"photoUrl":  p.PhotoURL,
-		"ownedBy":   ownedByOIDs,
-	}}
+	update := bson.M{"$set": personFields(p), "$inc": bson.M{"version": 1}}
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "people", p.ID); err != nil {
+		return nil, err
+	}
+	// the write only applies to the version p was read at
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 	var out Person
-	if err := col.FindOneAndUpdate(ctx, bson.M{"_id": oid}, update, opts).Decode(&out); err != nil {
+	if err := col.FindOneAndUpdate(ctx, bson.M{"_id": oid, "version": versionMatch(p.Version)}, update, opts).Decode(&out); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if n, cerr := col.CountDocuments(ctx, bson.M{"_id": oid}); cerr == nil && n > 0 {
+				return nil, errVersionConflict
+			}
+		}
 		return nil, err
 	}
+	tracker.record(ctx)
 	out.ID = p.ID
 	cacheDel(ctx, cacheKeyPerson(p.ID))
 	cacheDelPattern(ctx, "ft:people:*")
@@ -503,33 +473,8 @@ func updatePersonRepo(ctx context.Context, p *Person) (*Person, error) {
 	return &out, nil
 }
 
-func deletePersonRepo(ctx context.Context, id string) (*Person, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/deletePersonRepo").End()
-	col := MongoDB.Collection("people")
-	oid, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	// Soft delete: set deleted flag instead of removing
-	update := bson.M{"$set": bson.M{"deleted": true, "deletedAt": time.Now()}}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
-	var out Person
-	filter := bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}
-	if err := col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&out); err != nil {
-		return nil, err
-	}
-	out.ID = id
-	cacheDel(ctx, cacheKeyPerson(id))
-	cacheDelPattern(ctx, "ft:people:*")
-	cacheDelPattern(ctx, "ft:relationships:*")
-	return &out, nil
-}
-
 func getAllFamiliesRepo(ctx context.Context, ownedBy []string) ([]*Family, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/getAllFamiliesRepo").End()
+	defer startSpan(ctx, "store/getAllFamiliesRepo").End()
 
 	cacheKey := cacheKeyFamilies(ownedBy)
 	if cached, ok := cacheGet[[]*Family](ctx, cacheKey); ok {
@@ -556,8 +501,29 @@ func getAllFamiliesRepo(ctx context.Context, ownedBy []string) ([]*Family, error
 	}
 
 	// Aggregation pipeline with $lookup to populate person
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: matchFilter}},
+	pipeline := append(mongo.Pipeline{{{Key: "$match", Value: matchFilter}}}, familyPersonLookup()...)
+
+	cur, err := col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	res := []*Family{}
+	for cur.Next(ctx) {
+		var doc familyDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		res = append(res, doc.family())
+	}
+	cacheSet(ctx, cacheKey, res, cacheTTLFamilies)
+	return res, nil
+}
+
+// familyPersonLookup populates "personDetails" with the family's root person.
+func familyPersonLookup() mongo.Pipeline {
+	return mongo.Pipeline{
 		{{Key: "$lookup", Value: bson.D{
 			{Key: "from", Value: "people"},
 			{Key: "localField", Value: "person"},
@@ -569,85 +535,51 @@ func getAllFamiliesRepo(ctx context.Context, ownedBy []string) ([]*Family, error
 			{Key: "preserveNullAndEmptyArrays", Value: true},
 		}}},
 	}
+}
 
-	cur, err := col.Aggregate(ctx, pipeline)
+// listFamiliesRepo returns one page of families. The root person is only populated when q
+// includes it; otherwise it is reduced to its ID. Pages are not cached.
+func listFamiliesRepo(ctx context.Context, ownedBy []string, q *listQuery) ([]*Family, string, error) {
+	defer startSpan(ctx, "store/listFamiliesRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	scope := bson.M{"deleted": bson.M{"$ne": true}}
+	if ownedBy != nil {
+		scope["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	pipeline := q.pipeline(scope)
+	if q.Include["person"] {
+		pipeline = append(pipeline, familyPersonLookup()...)
+	}
+	if proj := q.projection("personDetails"); proj != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: proj}})
+	}
+	docs, err := aggregateDocs(ctx, MongoDB.Collection("families"), pipeline)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	defer cur.Close(ctx)
-
+	docs, next := q.page(docs)
 	res := []*Family{}
-	for cur.Next(ctx) {
-		var doc bson.M
-		if err := cur.Decode(&doc); err != nil {
-			continue
+	for _, doc := range docs {
+		f := decodeFamilyDoc(doc)
+		if pid, ok := doc["person"].(primitive.ObjectID); ok && f.Person == nil {
+			f.Person = &Person{ID: pid.Hex()}
 		}
-		var f Family
-		if idv, ok := doc["_id"].(primitive.ObjectID); ok {
-			f.ID = idv.Hex()
-		}
-		if n, ok := doc["name"].(string); ok {
-			f.Name = n
-		}
-		if ob, ok := doc["ownedBy"].(primitive.A); ok {
-			ids := []string{}
-			for _, it := range ob {
-				switch vv := it.(type) {
-				case primitive.ObjectID:
-					ids = append(ids, vv.Hex())
-				case string:
-					ids = append(ids, vv)
-				}
-			}
-			f.OwnedBy = ids
-		}
-
-		// Populate person from personDetails
-		if pd, ok := doc["personDetails"].(bson.M); ok {
-			var p Person
-			if idv, ok := pd["_id"].(primitive.ObjectID); ok {
-				p.ID = idv.Hex()
-			}
-			if n, ok := pd["name"].(string); ok {
-				p.Name = n
-			}
-			if nick, ok := pd["nickname"].(string); ok {
-				p.Nickname = nick
-			}
-			if address, ok := pd["address"].(string); ok {
-				p.Address = address
-			}
-			if ph, ok := pd["photoUrl"].(string); ok {
-				p.PhotoURL = ph
-			}
-			if bd, ok := pd["birthDate"].(primitive.DateTime); ok {
-				p.BirthDate = bd.Time()
-			} else if bdStr, ok := pd["birthDate"].(string); ok {
-				if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-					p.BirthDate = t
-				}
-			}
-			if phn, ok := pd["phone"].(string); ok {
-				p.Phone = phn
-			}
-			if st, ok := pd["status"].(string); ok {
-				p.Status = st
-			}
-			if gd, ok := pd["gender"].(string); ok {
-				p.Gender = gd
-			}
-			f.Person = &p
-		}
-
-		res = append(res, &f)
+		res = append(res, f)
 	}
-	cacheSet(ctx, cacheKey, res, cacheTTLFamilies)
-	return res, nil
+	return res, next, nil
+}
+
+// decodeFamilyDoc maps a raw families document to a Family, populating the root person
+// when the pipeline looked it up as personDetails.
+func decodeFamilyDoc(doc bson.M) *Family {
+	return decodeM[familyDocument](doc).family()
 }
 
 // getFamilyByIdRepo fetches a single family by ID with person populated, using the cache.
 func getFamilyByIdRepo(ctx context.Context, id string) (*Family, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/getFamilyByIdRepo").End()
+	defer startSpan(ctx, "store/getFamilyByIdRepo").End()
 
 	cacheKey := cacheKeyFamily(id)
 	if cached, ok := cacheGet[*Family](ctx, cacheKey); ok {
@@ -662,19 +594,7 @@ func getFamilyByIdRepo(ctx context.Context, id string) (*Family, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}}},
-		{{Key: "$lookup", Value: bson.D{
-			{Key: "from", Value: "people"},
-			{Key: "localField", Value: "person"},
-			{Key: "foreignField", Value: "_id"},
-			{Key: "as", Value: "personDetails"},
-		}}},
-		{{Key: "$unwind", Value: bson.D{
-			{Key: "path", Value: "$personDetails"},
-			{Key: "preserveNullAndEmptyArrays", Value: true},
-		}}},
-	}
+	pipeline := append(mongo.Pipeline{{{Key: "$match", Value: bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}}}}, familyPersonLookup()...)
 
 	cur, err := col.Aggregate(ctx, pipeline)
 	if err != nil {
@@ -686,102 +606,115 @@ func getFamilyByIdRepo(ctx context.Context, id string) (*Family, error) {
 		return nil, mongo.ErrNoDocuments
 	}
 
-	var doc bson.M
+	var doc familyDocument
 	if err := cur.Decode(&doc); err != nil {
 		return nil, err
 	}
 
-	var out Family
-	if idv, ok := doc["_id"].(primitive.ObjectID); ok {
-		out.ID = idv.Hex()
+	out := doc.family()
+	cacheSet(ctx, cacheKey, out, cacheTTLFamily)
+	return out, nil
+}
+
+func createFamilyRepo(ctx context.Context, f *Family) (*Family, error) {
+	defer startSpan(ctx, "store/createFamilyRepo").End()
+	col := MongoDB.Collection("families")
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	oid := primitive.NewObjectID()
+	doc, err := familyFields(f)
+	if err != nil {
+		return nil, err
 	}
-	if n, ok := doc["name"].(string); ok {
-		out.Name = n
+	doc["_id"] = oid
+	doc["version"] = 1
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "families", oid.Hex()); err != nil {
+		return nil, err
 	}
-	if ob, ok := doc["ownedBy"].(primitive.A); ok {
-		ids := []string{}
-		for _, it := range ob {
-			switch vv := it.(type) {
-			case primitive.ObjectID:
-				ids = append(ids, vv.Hex())
-			case string:
-				ids = append(ids, vv)
-			}
-		}
-		out.OwnedBy = ids
+	if _, err := col.InsertOne(ctx, doc); err != nil {
+		return nil, err
 	}
-	if pd, ok := doc["personDetails"].(bson.M); ok {
-		var p Person
-		if idv, ok := pd["_id"].(primitive.ObjectID); ok {
-			p.ID = idv.Hex()
-		}
-		if n, ok := pd["name"].(string); ok {
-			p.Name = n
-		}
-		if nick, ok := pd["nickname"].(string); ok {
-			p.Nickname = nick
-		}
-		if address, ok := pd["address"].(string); ok {
-			p.Address = address
-		}
-		if ph, ok := pd["photoUrl"].(string); ok {
-			p.PhotoURL = ph
+	tracker.record(ctx)
+	cacheDelPattern(ctx, "ft:families:*")
+	return getFamilyByIdRepo(ctx, oid.Hex())
+}
+
+// familyFields is the stored form of f's editable fields. person mirrors the first
+// founder so lookups and older readers keep working.
+func familyFields(f *Family) (bson.M, error) {
+	if len(f.Founders) == 0 {
+		return nil, errors.New("family needs at least one founder")
+	}
+	founders := bson.A{}
+	for _, c := range f.Founders {
+		person, err := primitive.ObjectIDFromHex(c.Person)
+		if err != nil {
+			return nil, err
 		}
-		if bd, ok := pd["birthDate"].(primitive.DateTime); ok {
-			p.BirthDate = bd.Time()
-		} else if bdStr, ok := pd["birthDate"].(string); ok {
-			if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-				p.BirthDate = t
+		couple := bson.M{"person": person}
+		if c.Spouse != "" {
+			spouse, err := primitive.ObjectIDFromHex(c.Spouse)
+			if err != nil {
+				return nil, err
 			}
+			couple["spouse"] = spouse
 		}
-		if phn, ok := pd["phone"].(string); ok {
-			p.Phone = phn
-		}
-		if st, ok := pd["status"].(string); ok {
-			p.Status = st
-		}
-		if gd, ok := pd["gender"].(string); ok {
-			p.Gender = gd
-		}
-		out.Person = &p
+		founders = append(founders, couple)
 	}
-
-	cacheSet(ctx, cacheKey, &out, cacheTTLFamily)
-	return &out, nil
+	fields := bson.M{
+		"name":        f.Name,
+		"description": f.Description,
+		"coverUrl":    f.CoverURL,
+		"person":      founders[0].(bson.M)["person"],
+		"founders":    founders,
+		"membership":  f.Membership,
+		"members":     objectIDs(f.Members),
+		"ownedBy":     objectIDs(f.OwnedBy),
+	}
+	return fields, nil
 }
 
-func createFamilyRepo(ctx context.Context, f *Family) (*Family, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/createFamilyRepo").End()
+// updateFamilyRepo replaces the editable fields of f, conditional on f.Version like
+// updatePersonRepo.
+func updateFamilyRepo(ctx context.Context, f *Family) (*Family, error) {
+	defer startSpan(ctx, "store/updateFamilyRepo").End()
 	col := MongoDB.Collection("families")
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-
-	// Convert person ID to ObjectID for storage
-	personOID, err := primitive.ObjectIDFromHex(f.Person.ID)
+	oid, err := primitive.ObjectIDFromHex(f.ID)
 	if err != nil {
 		return nil, err
 	}
-
-	// Convert ownedBy string array to ObjectID array
-	ownedByOIDs := []primitive.ObjectID{}
-	for _, ownerID := range f.OwnedBy {
-		if oid, err := primitive.ObjectIDFromHex(ownerID); err == nil {
-			ownedByOIDs = append(ownedByOIDs, oid)
-		}
+	fields, err := familyFields(f)
+	if err != nil {
+		return nil, err
 	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
 
-	doc := bson.M{"name": f.Name, "person": personOID, "ownedBy": ownedByOIDs}
-	res, err := col.InsertOne(ctx, doc)
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "families", f.ID); err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": oid, "deleted": bson.M{"$ne": true}, "version": versionMatch(f.Version)}
+	res, err := col.UpdateOne(ctx, filter, bson.M{"$set": fields, "$inc": bson.M{"version": 1}})
 	if err != nil {
 		return nil, err
 	}
-	oid := res.InsertedID.(primitive.ObjectID)
+	if res.MatchedCount == 0 {
+		if n, cerr := col.CountDocuments(ctx, bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}); cerr == nil && n > 0 {
+			return nil, errVersionConflict
+		}
+		return nil, mongo.ErrNoDocuments
+	}
+	tracker.record(ctx)
+	cacheDel(ctx, cacheKeyFamily(f.ID))
 	cacheDelPattern(ctx, "ft:families:*")
-	return getFamilyByIdRepo(ctx, oid.Hex())
+	return getFamilyByIdRepo(ctx, f.ID)
 }
 
 func deleteFamilyRepo(ctx context.Context, id string) (*Family, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/deleteFamilyRepo").End()
+	defer startSpan(ctx, "store/deleteFamilyRepo").End()
 
 	out, err := getFamilyByIdRepo(ctx, id)
 	if err != nil {
@@ -796,10 +729,15 @@ func deleteFamilyRepo(ctx context.Context, id string) (*Family, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	update := bson.M{"$set": bson.M{"deleted": true, "deletedAt": time.Now()}}
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "families", id); err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{"deleted": true, "deletedAt": time.Now()}, "$inc": bson.M{"version": 1}}
 	if _, err = col.UpdateOne(ctx, bson.M{"_id": oid}, update); err != nil {
 		return nil, err
 	}
+	tracker.record(ctx)
 
 	cacheDel(ctx, cacheKeyFamily(id))
 	cacheDelPattern(ctx, "ft:families:*")
@@ -807,7 +745,7 @@ func deleteFamilyRepo(ctx context.Context, id string) (*Family, error) {
 }
 
 func repoFindUsers(ctx context.Context, filter interface{}) ([]User, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/repoFindUsers").End()
+	defer startSpan(ctx, "store/repoFindUsers").End()
 
 	cacheKey := cacheKeyUsersList()
 	if cached, ok := cacheGet[[]User](ctx, cacheKey); ok {
@@ -834,23 +772,11 @@ func repoFindUsers(ctx context.Context, filter interface{}) ([]User, error) {
 	defer cur.Close(ctx)
 	res := []User{}
 	for cur.Next(ctx) {
-		var doc bson.M
+		var doc userDocument
 		if err := cur.Decode(&doc); err != nil {
 			continue
 		}
-		var u User
-		if idv, ok := doc["_id"].(primitive.ObjectID); ok {
-			u.ID = idv.Hex()
-		}
-		if n, ok := doc["name"].(string); ok {
-			u.Name = n
-		}
-		if un, ok := doc["username"].(string); ok {
-			u.Username = un
-		}
-		if r, ok := doc["role"].(string); ok {
-			u.Role = UserRole(r)
-		}
+		u := doc.user()
 		u.Password = ""
 		res = append(res, u)
 	}
@@ -858,10 +784,46 @@ func repoFindUsers(ctx context.Context, filter interface{}) ([]User, error) {
 	return res, nil
 }
 
+// listUsersRepo returns one page of users, without password hashes. Pages are not cached.
+func listUsersRepo(ctx context.Context, q *listQuery) ([]User, string, error) {
+	defer startSpan(ctx, "store/listUsersRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	pipeline := q.pipeline(bson.M{"deleted": bson.M{"$ne": true}})
+	proj := q.projection()
+	if proj == nil {
+		proj = bson.D{{Key: "password", Value: 0}}
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: proj}})
+	docs, err := aggregateDocs(ctx, MongoDB.Collection("users"), pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	docs, next := q.page(docs)
+	res := []User{}
+	for _, doc := range docs {
+		res = append(res, decodeUserListDoc(doc))
+	}
+	return res, next, nil
+}
+
+// decodeUserListDoc maps a raw users document to a User without its password hash.
+func decodeUserListDoc(doc bson.M) User {
+	u := decodeM[userDocument](doc).user()
+	u.Password = ""
+	return u
+}
 
 // Relationship repository functions
+// decodeRelationshipDoc maps a raw relationships document to a Relationship, populating
+// fromDetails and toDetails when the pipeline looked them up.
+func decodeRelationshipDoc(doc bson.M) *Relationship {
+	return decodeM[relationshipDocument](doc).relationship()
+}
+
 func getRelationshipsByPersonIdRepo(ctx context.Context, personId string) ([]*Relationship, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/getRelationshipsByPersonIdRepo").End()
+	defer startSpan(ctx, "store/getRelationshipsByPersonIdRepo").End()
 
 	cacheKey := cacheKeyRelationships(personId)
 	if cached, ok := cacheGet[[]*Relationship](ctx, cacheKey); ok {
@@ -888,12 +850,8 @@ func getRelationshipsByPersonIdRepo(ctx context.Context, personId string) ([]*Re
 		ors = append(ors, bson.M{"from": oid}, bson.M{"to": oid})
 	}
 
-	// Debug: log the query
-	debug := os.Getenv("TREE_DEBUG") == "1"
-	if debug {
-		fmt.Printf("[STORE] getRelationshipsByPersonId: personId=%s oidOK=%v\n", personId, oidOK)
-		fmt.Printf("[STORE] query: {$or: %v}\n", ors)
-	}
+	log := logger(logStore)
+	log.DebugContext(ctx, "loading relationships", "person_id", personId, "object_id", oidOK, "query", fmt.Sprint(ors))
 
 	match := bson.D{
 		{Key: "$match", Value: bson.M{
@@ -916,220 +874,1950 @@ func getRelationshipsByPersonIdRepo(ctx context.Context, personId string) ([]*Re
 	defer cur.Close(ctx)
 	res := []*Relationship{}
 	for cur.Next(ctx) {
-		var doc bson.M
+		var doc relationshipDocument
 		if err := cur.Decode(&doc); err != nil {
 			continue
 		}
-		var r Relationship
-		if v, ok := doc["_id"].(primitive.ObjectID); ok {
-			r.ID = v.Hex()
-		}
-		// Handle from field as both ObjectID and string
-		if v, ok := doc["from"].(primitive.ObjectID); ok {
-			r.From = v.Hex()
-		} else if v, ok := doc["from"].(string); ok {
-			r.From = v
-		}
-		// Handle to field as both ObjectID and string
-		if v, ok := doc["to"].(primitive.ObjectID); ok {
-			r.To = v.Hex()
-		} else if v, ok := doc["to"].(string); ok {
-			r.To = v
-		}
-		if v, ok := doc["type"].(string); ok {
-			r.Type = v
-		}
-		if v, ok := doc["order"].(int32); ok {
-			r.Order = int(v)
-		}
-		// fromDetails array -> take first
-		if fd, ok := doc["fromDetails"].(primitive.A); ok && len(fd) > 0 {
-			if m, ok := fd[0].(bson.M); ok {
-				var p Person
-				if idv, ok := m["_id"].(primitive.ObjectID); ok {
-					p.ID = idv.Hex()
-				}
-				if n, ok := m["name"].(string); ok {
-					p.Name = n
-				}
-				if nick, ok := m["nickname"].(string); ok {
-					p.Nickname = nick
-				}
-				if address, ok := m["address"].(string); ok {
-					p.Address = address
-				}
-				if ph, ok := m["photoUrl"].(string); ok {
-					p.PhotoURL = ph
-				}
-				if bd, ok := m["birthDate"].(primitive.DateTime); ok {
-					p.BirthDate = bd.Time()
-				} else if bdStr, ok := m["birthDate"].(string); ok {
-					if t, err := time.Parse(time.RFC3339, bdStr); err == nil {
-						p.BirthDate = t
-					}
-				}
-				if phn, ok := m["phone"].(string); ok {
-					p.Phone = phn
-				}
-				if st, ok := m["status"].(string); ok {
-					p.Status = st
-				}
-				if gd, ok := m["gender"].(string); ok {
-					p.Gender = gd
-				}
-				r.FromDetails = &p
-			}
-		}
-		if td, ok := doc["toDetails"].(primitive.A); ok && len(td) > 0 {
-			if m, ok := td[0].(bson.M); ok {
-				var p Person
-				if idv, ok := m["_id"].(primitive.ObjectID); ok {
-					p.ID = idv.Hex()
-				}
-				if n, ok := m["name"].(string); ok {
-					p.Name = n
-				}
-				if nick, ok := m["nickname"].(string); ok {
-					p.Nickname = nick
-				}
-				if address, ok := m["address"].(string); ok {
-					p.Address = address
-				}
-				if ph, ok := m["photoUrl"].(string); ok {
-					p.PhotoURL = ph
-				}
-				if bd, ok := m["birthDate"].(primitive.DateTime); ok {
-					p.BirthDate = bd.Time()
-				}
-				if phn, ok := m["phone"].(string); ok {
-					p.Phone = phn
-				}
-				if st, ok := m["status"].(string); ok {
-					p.Status = st
-				}
-				if gd, ok := m["gender"].(string); ok {
-					p.Gender = gd
-				}
-				r.ToDetails = &p
-			}
-		}
-		res = append(res, &r)
+		res = append(res, doc.relationship())
 	}
 
-	if debug {
-		fmt.Printf("[STORE] getRelationshipsByPersonId: found %d relationships\n", len(res))
+	if log.Enabled(ctx, slog.LevelDebug) {
+		edges := make([]string, 0, len(res))
 		for _, r := range res {
-			fmt.Printf("[STORE]   rel: from=%s to=%s type=%s\n", r.From, r.To, r.Type)
+			edges = append(edges, r.From+" "+r.Type+" "+r.To)
 		}
+		log.DebugContext(ctx, "loaded relationships", "person_id", personId, "count", len(res), "edges", edges)
 	}
 
 	cacheSet(ctx, cacheKey, res, cacheTTLRelationships)
 	return res, nil
 }
 
-func insertManyRelationshipsRepo(ctx context.Context, rels []Relationship) error {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/insertManyRelationshipsRepo").End()
-	if len(rels) == 0 {
-		return nil
+// relationshipDoc is the stored form of a new relationship edge.
+func relationshipDoc(r Relationship, oid, from, to primitive.ObjectID) bson.M {
+	doc := bson.M{"_id": oid, "from": from, "to": to, "type": r.Type, "order": r.Order, "version": 1}
+	if r.Subtype != "" {
+		doc["subtype"] = r.Subtype
 	}
-	col := MongoDB.Collection("relationships")
-	docs := make([]interface{}, 0, len(rels))
-	for _, r := range rels {
-		// Convert from and to string IDs to ObjectIDs
+	if r.Marriage != nil {
+		doc["marriage"] = marriageDoc(r.Marriage)
+	}
+	return doc
+}
+
+// newRelationshipChange reports r with the given action.
+func newRelationshipChange(r Relationship, action string) RelationshipChange {
+	return RelationshipChange{ID: r.ID, From: r.From, To: r.To, Type: r.Type, Subtype: r.Subtype, Order: r.Order, Marriage: r.Marriage, Version: r.Version, Action: action}
+}
+
+// applyRelationshipChangesRepo writes the inserts, order/subtype/marriage updates and soft deletes of a
+// relationship upsert as one ordered BulkWrite. The write runs inside a transaction when
+// the server supports it, so a parent/child pair is never left without its inverse.
+func applyRelationshipChangesRepo(ctx context.Context, inserts, updates, deletes []Relationship) ([]RelationshipChange, error) {
+	defer startSpan(ctx, "store/applyRelationshipChangesRepo").End()
+	changes := []RelationshipChange{}
+	models := []mongo.WriteModel{}
+	now := time.Now()
+
+	for _, r := range inserts {
 		fromOID, errFrom := primitive.ObjectIDFromHex(r.From)
 		toOID, errTo := primitive.ObjectIDFromHex(r.To)
 		if errFrom != nil || errTo != nil {
-			continue // Skip invalid IDs
+			return nil, fmt.Errorf("invalid from or to ID")
+		}
+		oid := primitive.NewObjectID()
+		r.ID = oid.Hex()
+		r.Version = 1
+		doc := relationshipDoc(r, oid, fromOID, toOID)
+		models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		changes = append(changes, newRelationshipChange(r, "inserted"))
+	}
+	for _, r := range updates {
+		oid, err := primitive.ObjectIDFromHex(r.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relationship ID")
+		}
+		update := bson.M{"$set": bson.M{"order": r.Order, "subtype": r.Subtype}, "$inc": bson.M{"version": 1}}
+		if r.Marriage != nil {
+			update["$set"].(bson.M)["marriage"] = marriageDoc(r.Marriage)
+		} else {
+			update["$unset"] = bson.M{"marriage": ""}
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": oid, "version": versionMatch(r.Version)}).
+			SetUpdate(update))
+		r.Version++
+		changes = append(changes, newRelationshipChange(r, "updated"))
+	}
+	for _, r := range deletes {
+		oid, err := primitive.ObjectIDFromHex(r.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relationship ID")
+		}
+		// Soft delete: set deleted flag instead of removing
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": oid, "deleted": bson.M{"$ne": true}, "version": versionMatch(r.Version)}).
+			SetUpdate(bson.M{"$set": bson.M{"deleted": true, "deletedAt": now}, "$inc": bson.M{"version": 1}}))
+		r.Version++
+		changes = append(changes, newRelationshipChange(r, "deleted"))
+	}
+	if len(models) == 0 {
+		return changes, nil
+	}
+
+	col := MongoDB.Collection("relationships")
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tracker := newRevisionTracker("")
+	ids := []string{}
+	for _, ch := range changes {
+		ids = append(ids, ch.ID)
+	}
+	if err := tracker.watch(ctx, "relationships", ids...); err != nil {
+		return nil, err
+	}
+	// Compare versions before writing: without a transaction there is nothing to roll back,
+	// so a conflict found by the bulk write would come after the other edges were written.
+	stored := tracker.before["relationships"]
+	for _, r := range updates {
+		if doc := stored[r.ID]; doc == nil || versionOf(doc) != r.Version {
+			return nil, errVersionConflict
 		}
-		docs = append(docs, bson.M{"from": fromOID, "to": toOID, "type": r.Type, "order": r.Order})
 	}
-	if len(docs) == 0 {
-		return nil
+	for _, r := range deletes {
+		if doc := stored[r.ID]; doc == nil || doc["deleted"] == true || versionOf(doc) != r.Version {
+			return nil, errVersionConflict
+		}
 	}
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-	defer cancel()
-	_, err := col.InsertMany(ctx, docs)
-	if err == nil {
-		// Invalidate cached relationships and individual person data for every person involved.
-		seen := map[string]struct{}{}
-		for _, r := range rels {
-			if _, ok := seen[r.From]; !ok {
-				cacheDel(ctx, cacheKeyRelationships(r.From), cacheKeyPerson(r.From))
-				seen[r.From] = struct{}{}
+	err := withTransaction(ctx, func(ctx context.Context) error {
+		res, err := col.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+		if err != nil {
+			return err
+		}
+		// an update or delete that matched nothing lost a race with another writer since the
+		// check above. In a transaction, abort so the whole upsert rolls back; without one
+		// the other edges are already written, which is not a clean conflict.
+		if res.MatchedCount < int64(len(updates)+len(deletes)) {
+			if mongo.SessionFromContext(ctx) == nil {
+				tracker.record(ctx)
+				return errRelationshipsPartlyWritten
 			}
-			if _, ok := seen[r.To]; !ok {
-				cacheDel(ctx, cacheKeyRelationships(r.To), cacheKeyPerson(r.To))
-				seen[r.To] = struct{}{}
+			return errVersionConflict
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRelationshipsPartlyWritten) {
+		return nil, err
+	}
+
+	// Invalidate cached relationships and person data for every person involved.
+	seen := map[string]struct{}{}
+	for _, ch := range changes {
+		for _, pid := range []string{ch.From, ch.To} {
+			if _, ok := seen[pid]; !ok {
+				cacheDel(ctx, cacheKeyRelationships(pid), cacheKeyPerson(pid))
+				seen[pid] = struct{}{}
 			}
 		}
-		cacheDelPattern(ctx, "ft:people:*")
 	}
-	return err
+	cacheDelPattern(ctx, "ft:people:*")
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
 }
 
-func updateRelationshipRepo(ctx context.Context, r Relationship) (*Relationship, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/updateRelationshipRepo").End()
-	col := MongoDB.Collection("relationships")
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+// auditPerson is the subset of a person document the data-quality audit needs. Unlike the
+// other person queries it includes soft-deleted documents.
+type auditPerson struct {
+	ID        string
+	Name      string
+	BirthDate time.Time
+	Deleted   bool
+}
+
+// auditRelationship is a live relationship document as stored, remembering whether
+// from/to were legacy string IDs.
+type auditRelationship struct {
+	Relationship
+	LegacyIDs bool
+}
+
+// auditFamily is a live family document with its raw person reference.
+type auditFamily struct {
+	ID       string
+	Name     string
+	Founders []FamilyCouple
+	Members  []string
+}
+
+// scanAuditDataRepo loads every person (deleted included), live relationship and live
+// family for the data-quality audit, bypassing the cache.
+func scanAuditDataRepo(ctx context.Context) ([]auditPerson, []auditRelationship, []auditFamily, error) {
+	defer startSpan(ctx, "store/scanAuditDataRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	// Convert relationship ID to ObjectID
-	id, err := primitive.ObjectIDFromHex(r.ID)
+	people := []auditPerson{}
+	cur, err := MongoDB.Collection("people").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "birthDate": 1, "deleted": 1}))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for cur.Next(ctx) {
+		var doc personDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		people = append(people, auditPersonOf(&doc))
+	}
+	err = cur.Err()
+	cur.Close(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("invalid relationship ID")
+		return nil, nil, nil, err
 	}
 
-	// Convert from and to string IDs to ObjectIDs
-	fromOID, errFrom := primitive.ObjectIDFromHex(r.From)
-	toOID, errTo := primitive.ObjectIDFromHex(r.To)
-	if errFrom != nil || errTo != nil {
-		return nil, fmt.Errorf("invalid from or to ID")
+	rels := []auditRelationship{}
+	cur, err = MongoDB.Collection("relationships").Find(ctx, bson.M{"deleted": bson.M{"$ne": true}}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		rels = append(rels, auditRelationshipOf(doc))
+	}
+	err = cur.Err()
+	cur.Close(ctx)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	filter := bson.M{"_id": id}
-	update := bson.M{"$set": bson.M{"from": fromOID, "to": toOID, "type": r.Type, "order": r.Order}}
-	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	var out Relationship
-	if err := col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&out); err != nil {
-		return nil, err
+	families := []auditFamily{}
+	cur, err = MongoDB.Collection("families").Find(ctx, bson.M{"deleted": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	out.ID = r.ID
-	cacheDel(ctx,
-		cacheKeyRelationships(r.From), cacheKeyRelationships(r.To),
-		cacheKeyPerson(r.From), cacheKeyPerson(r.To),
-	)
-	cacheDelPattern(ctx, "ft:people:*")
-	return &out, nil
+	for cur.Next(ctx) {
+		var doc familyDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		families = append(families, auditFamilyOf(&doc))
+	}
+	err = cur.Err()
+	cur.Close(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return people, rels, families, nil
 }
 
-func deleteRelationshipsRepo(ctx context.Context, ids []string) error {
-	defer newrelic.StartSegment(newrelic.FromContext(ctx), "store/deleteRelationshipsRepo").End()
-	if len(ids) == 0 {
+func auditPersonOf(doc *personDocument) auditPerson {
+	return auditPerson{ID: string(doc.ID), Name: doc.Name, BirthDate: doc.BirthDate.Time, Deleted: doc.Deleted}
+}
+
+// auditRelationshipOf flags edges that still store from or to as strings.
+func auditRelationshipOf(doc bson.M) auditRelationship {
+	_, legacyFrom := doc["from"].(string)
+	_, legacyTo := doc["to"].(string)
+	return auditRelationship{Relationship: *decodeRelationshipDoc(doc), LegacyIDs: legacyFrom || legacyTo}
+}
+
+func auditFamilyOf(doc *familyDocument) auditFamily {
+	f := doc.family()
+	return auditFamily{ID: f.ID, Name: f.Name, Founders: f.Founders, Members: f.Members}
+}
+
+// convertRelationshipIDsRepo rewrites legacy string from/to values of the given
+// relationships as ObjectIDs.
+func convertRelationshipIDsRepo(ctx context.Context, rels []Relationship) error {
+	defer startSpan(ctx, "store/convertRelationshipIDsRepo").End()
+	if len(rels) == 0 {
 		return nil
 	}
-	col := MongoDB.Collection("relationships")
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	models := []mongo.WriteModel{}
+	for _, r := range rels {
+		oid, errID := primitive.ObjectIDFromHex(r.ID)
+		fromOID, errFrom := primitive.ObjectIDFromHex(r.From)
+		toOID, errTo := primitive.ObjectIDFromHex(r.To)
+		if errID != nil || errFrom != nil || errTo != nil {
+			return fmt.Errorf("invalid relationship %s", r.ID)
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": oid}).
+			SetUpdate(bson.M{"$set": bson.M{"from": fromOID, "to": toOID}, "$inc": bson.M{"version": 1}}))
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
+	tracker := newRevisionTracker("audit")
+	ids := []string{}
+	for _, r := range rels {
+		ids = append(ids, r.ID)
+	}
+	if err := tracker.watch(ctx, "relationships", ids...); err != nil {
+		return err
+	}
+	if _, err := MongoDB.Collection("relationships").BulkWrite(ctx, models); err != nil {
+		return err
+	}
+	tracker.record(ctx)
+	for _, pattern := range []string{"ft:relationships:*", "ft:person:*", "ft:people:*"} {
+		cacheDelPattern(ctx, pattern)
+	}
+	return nil
+}
+
+// objectIDExpr converts a legacy hex string reference to an ObjectID inside an update
+// pipeline. ObjectIDs, strings that aren't IDs and missing fields are left as they are.
+func objectIDExpr(path string) bson.M {
+	return bson.M{"$convert": bson.M{"input": path, "to": "objectId", "onError": path, "onNull": path}}
+}
 
-	// Convert string IDs to ObjectIDs
-	objIDs := []primitive.ObjectID{}
-	for _, idStr := range ids {
-		if oid, err := primitive.ObjectIDFromHex(idStr); err == nil {
-			objIDs = append(objIDs, oid)
+// dateExpr converts a legacy date string to a DateTime inside an update pipeline, leaving
+// anything else as it is.
+func dateExpr(path string) bson.M {
+	return bson.M{"$convert": bson.M{"input": path, "to": "date", "onError": path, "onNull": path}}
+}
+
+// mapExpr applies in to every element of the array at path, if there is one.
+func mapExpr(path string, in interface{}) bson.M {
+	return bson.M{"$cond": bson.A{bson.M{"$isArray": path}, bson.M{"$map": bson.M{"input": path, "in": in}}, path}}
+}
+
+// legacyUpdate is a pipeline update applied to every document a filter matches.
+type legacyUpdate struct {
+	collection string
+	filter     bson.M
+	set        bson.M
+}
+
+// applyLegacyUpdates runs updates in order and counts the documents they changed. Like
+// the search key backfill, they don't bump versions or record revisions: only the stored
+// representation changes.
+func applyLegacyUpdates(ctx context.Context, updates []legacyUpdate) (int64, error) {
+	var changed int64
+	for _, u := range updates {
+		res, err := MongoDB.Collection(u.collection).UpdateMany(ctx, u.filter, mongo.Pipeline{{{Key: "$set", Value: u.set}}})
+		if err != nil {
+			return changed, fmt.Errorf("%s: %w", u.collection, err)
 		}
+		changed += res.ModifiedCount
 	}
-	if len(objIDs) == 0 {
-		return nil
-	}
+	return changed, nil
+}
 
-	// Soft delete: set deleted flag instead of removing
-	update := bson.M{"$set": bson.M{"deleted": true, "deletedAt": time.Now()}}
-	_, err := col.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objIDs}}, update)
-	if err == nil {
-		// Person IDs are unknown from relationship IDs alone — flush all related caches in one pass each.
-		for _, pattern := range []string{"ft:relationships:*", "ft:person:*", "ft:people:*"} {
-			cacheDelPattern(ctx, pattern)
+// convertLegacyIDsRepo rewrites the string references left by the Node backend as
+// ObjectIDs: relationship ends, owners, family founders and members.
+func convertLegacyIDsRepo(ctx context.Context) (int64, error) {
+	defer startSpan(ctx, "store/convertLegacyIDsRepo").End()
+	isString := bson.M{"$type": "string"}
+	idArray := func(path string) bson.M { return mapExpr(path, objectIDExpr("$$this")) }
+	return applyLegacyUpdates(ctx, []legacyUpdate{
+		{
+			"relationships",
+			bson.M{"$or": bson.A{bson.M{"from": isString}, bson.M{"to": isString}}},
+			bson.M{"from": objectIDExpr("$from"), "to": objectIDExpr("$to")},
+		},
+		{
+			"people",
+			bson.M{"ownedBy": isString},
+			bson.M{"ownedBy": idArray("$ownedBy")},
+		},
+		{
+			"families",
+			bson.M{"$or": bson.A{
+				bson.M{"person": isString}, bson.M{"ownedBy": isString}, bson.M{"members": isString},
+				bson.M{"founders.person": isString}, bson.M{"founders.spouse": isString},
+			}},
+			bson.M{
+				"person":  objectIDExpr("$person"),
+				"ownedBy": idArray("$ownedBy"),
+				"members": idArray("$members"),
+				"founders": mapExpr("$founders", bson.M{"$mergeObjects": bson.A{"$$this", bson.M{
+					"person": objectIDExpr("$$this.person"),
+					"spouse": objectIDExpr("$$this.spouse"),
+				}}}),
+			},
+		},
+	})
+}
+
+// convertLegacyDatesRepo rewrites date strings as DateTimes: birth dates, marriage dates
+// and deletion times.
+func convertLegacyDatesRepo(ctx context.Context) (int64, error) {
+	defer startSpan(ctx, "store/convertLegacyDatesRepo").End()
+	isString := bson.M{"$type": "string"}
+	return applyLegacyUpdates(ctx, []legacyUpdate{
+		{
+			"people",
+			bson.M{"$or": bson.A{bson.M{"birthDate": isString}, bson.M{"deletedAt": isString}}},
+			bson.M{"birthDate": dateExpr("$birthDate"), "deletedAt": dateExpr("$deletedAt")},
+		},
+		{
+			"relationships",
+			bson.M{"$or": bson.A{bson.M{"marriage.startDate": isString}, bson.M{"marriage.endDate": isString}, bson.M{"deletedAt": isString}}},
+			bson.M{
+				"marriage": bson.M{"$cond": bson.A{
+					bson.M{"$eq": bson.A{bson.M{"$type": "$marriage"}, "object"}},
+					bson.M{"$mergeObjects": bson.A{"$marriage", bson.M{
+						"startDate": dateExpr("$marriage.startDate"),
+						"endDate":   dateExpr("$marriage.endDate"),
+					}}},
+					"$marriage",
+				}},
+				"deletedAt": dateExpr("$deletedAt"),
+			},
+		},
+		{
+			"families",
+			bson.M{"deletedAt": isString},
+			bson.M{"deletedAt": dateExpr("$deletedAt")},
+		},
+	})
+}
+
+// appliedMigrationsRepo reads the migrations collection, keyed by version.
+func appliedMigrationsRepo(ctx context.Context) (map[int]MigrationStatus, error) {
+	cur, err := MongoDB.Collection("migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	applied := map[int]MigrationStatus{}
+	for cur.Next(ctx) {
+		var doc struct {
+			Version   docInt  `bson:"_id"`
+			Name      string  `bson:"name"`
+			AppliedAt docTime `bson:"appliedAt"`
+			Changed   docInt  `bson:"changed"`
 		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		at := doc.AppliedAt.Time
+		applied[int(doc.Version)] = MigrationStatus{Version: int(doc.Version), Name: doc.Name, AppliedAt: &at, Changed: int64(doc.Changed)}
 	}
+	return applied, cur.Err()
+}
+
+// recordMigrationRepo marks a migration applied.
+func recordMigrationRepo(ctx context.Context, st MigrationStatus) error {
+	_, err := MongoDB.Collection("migrations").UpdateOne(ctx,
+		bson.M{"_id": st.Version},
+		bson.M{"$set": bson.M{"name": st.Name, "appliedAt": st.AppliedAt, "changed": st.Changed}},
+		options.Update().SetUpsert(true))
 	return err
 }
+
+// objectIDs converts hex IDs to ObjectIDs, skipping invalid ones.
+func objectIDs(ids []string) []primitive.ObjectID {
+	out := []primitive.ObjectID{}
+	for _, id := range ids {
+		if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+			out = append(out, oid)
+		}
+	}
+	return out
+}
+
+// relationshipIDs lists the IDs of rels.
+func relationshipIDs(rels []Relationship) []string {
+	ids := []string{}
+	for _, r := range rels {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// relationshipLogDocs encodes the edges of a merge log as {_id, from, to, type} documents.
+func relationshipLogDocs(rels []Relationship) bson.A {
+	out := bson.A{}
+	for _, r := range rels {
+		doc := bson.M{"type": r.Type}
+		for k, v := range map[string]string{"_id": r.ID, "from": r.From, "to": r.To} {
+			if oid, err := primitive.ObjectIDFromHex(v); err == nil {
+				doc[k] = oid
+			} else {
+				doc[k] = v
+			}
+		}
+		out = append(out, doc)
+	}
+	return out
+}
+
+// mergedFamilyFields encodes who founds and belongs to a family as the stored person,
+// founders and members fields.
+func mergedFamilyFields(f MergedFamily) (bson.M, error) {
+	fields, err := familyFields(&Family{Founders: f.Founders, Members: f.Members})
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"person": fields["person"], "founders": fields["founders"], "members": fields["members"]}, nil
+}
+
+// mergedFamilyDocs encodes the families of a merge log as {_id, founders, members}
+// documents.
+func mergedFamilyDocs(families []MergedFamily) (bson.A, error) {
+	out := bson.A{}
+	for _, f := range families {
+		oid, err := primitive.ObjectIDFromHex(f.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid family ID")
+		}
+		doc, err := mergedFamilyFields(f)
+		if err != nil {
+			return nil, err
+		}
+		delete(doc, "person")
+		doc["_id"] = oid
+		out = append(out, doc)
+	}
+	return out, nil
+}
+
+// mergeLogDoc encodes a merge log for the merges collection.
+func mergeLogDoc(log *MergeLog) (bson.M, error) {
+	winner, err := primitive.ObjectIDFromHex(log.Winner)
+	if err != nil {
+		return nil, err
+	}
+	loser, err := primitive.ObjectIDFromHex(log.Loser)
+	if err != nil {
+		return nil, err
+	}
+	familiesBefore, err := mergedFamilyDocs(log.FamiliesBefore)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{
+		"winner":         winner,
+		"loser":          loser,
+		"mergedBy":       log.MergedBy,
+		"mergedAt":       log.MergedAt,
+		"winnerOwnedBy":  objectIDs(log.WinnerOwnedBy),
+		"repointed":      relationshipLogDocs(log.Repointed),
+		"removed":        relationshipLogDocs(log.Removed),
+		"families":       objectIDs(log.Families),
+		"familiesBefore": familiesBefore,
+	}, nil
+}
+
+// mergedFamilyModels sets the person, founders and members of each family in families.
+func mergedFamilyModels(families []MergedFamily) ([]mongo.WriteModel, error) {
+	models := []mongo.WriteModel{}
+	for _, f := range families {
+		oid, err := primitive.ObjectIDFromHex(f.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid family ID")
+		}
+		set, err := mergedFamilyFields(f)
+		if err != nil {
+			return nil, err
+		}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": oid}).SetUpdate(bson.M{"$set": set, "$inc": bson.M{"version": 1}}))
+	}
+	return models, nil
+}
+
+// mergePeopleRepo applies a planned merge in one transaction: the loser's edges are moved
+// to the winner or soft-deleted, the winner replaces the loser in their families, the winner gets ownedBy, the
+// loser is soft-deleted and the log is stored. log.ID is set on success.
+func mergePeopleRepo(ctx context.Context, log *MergeLog, ownedBy []string) error {
+	defer startSpan(ctx, "store/mergePeopleRepo").End()
+	winnerOID, err := primitive.ObjectIDFromHex(log.Winner)
+	if err != nil {
+		return err
+	}
+	loserOID, err := primitive.ObjectIDFromHex(log.Loser)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	relModels := []mongo.WriteModel{}
+	for _, r := range log.Repointed {
+		oid, err := primitive.ObjectIDFromHex(r.ID)
+		if err != nil {
+			return fmt.Errorf("invalid relationship ID")
+		}
+		set := bson.M{}
+		if r.From == log.Loser {
+			set["from"] = winnerOID
+		}
+		if r.To == log.Loser {
+			set["to"] = winnerOID
+		}
+		relModels = append(relModels, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": oid}).SetUpdate(bson.M{"$set": set, "$inc": bson.M{"version": 1}}))
+	}
+	removed := relationshipIDs(log.Removed)
+	if len(removed) > 0 {
+		relModels = append(relModels, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{"_id": bson.M{"$in": objectIDs(removed)}}).
+			SetUpdate(bson.M{"$set": bson.M{"deleted": true, "deletedAt": now}, "$inc": bson.M{"version": 1}}))
+	}
+
+	merged := []MergedFamily{}
+	for _, f := range log.FamiliesBefore {
+		merged = append(merged, mergeFamilyPeople(f, log.Loser, log.Winner))
+	}
+	familyModels, err := mergedFamilyModels(merged)
+	if err != nil {
+		return err
+	}
+	logDoc, err := mergeLogDoc(log)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tracker, err := watchMergeRepo(ctx, log)
+	if err != nil {
+		return err
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		if len(relModels) > 0 {
+			if _, err := MongoDB.Collection("relationships").BulkWrite(ctx, relModels); err != nil {
+				return err
+			}
+		}
+		if len(familyModels) > 0 {
+			if _, err := MongoDB.Collection("families").BulkWrite(ctx, familyModels); err != nil {
+				return err
+			}
+		}
+		people := MongoDB.Collection("people")
+		if _, err := people.UpdateOne(ctx, bson.M{"_id": winnerOID}, bson.M{"$set": bson.M{"ownedBy": objectIDs(ownedBy)}, "$inc": bson.M{"version": 1}}); err != nil {
+			return err
+		}
+		if _, err := people.UpdateOne(ctx, bson.M{"_id": loserOID}, bson.M{"$set": bson.M{"deleted": true, "deletedAt": now, "mergedInto": winnerOID}, "$inc": bson.M{"version": 1}}); err != nil {
+			return err
+		}
+		res, err := MongoDB.Collection("merges").InsertOne(ctx, logDoc)
+		if err != nil {
+			return err
+		}
+		log.ID = res.InsertedID.(primitive.ObjectID).Hex()
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invalidateGraphCaches(ctx)
+	return nil
+}
+
+// revertMergeRepo restores everything a merge changed and marks its log as reverted.
+func revertMergeRepo(ctx context.Context, log *MergeLog) error {
+	defer startSpan(ctx, "store/revertMergeRepo").End()
+	mergeOID, err := primitive.ObjectIDFromHex(log.ID)
+	if err != nil {
+		return err
+	}
+	winnerOID, err := primitive.ObjectIDFromHex(log.Winner)
+	if err != nil {
+		return err
+	}
+	loserOID, err := primitive.ObjectIDFromHex(log.Loser)
+	if err != nil {
+		return err
+	}
+
+	relModels := []mongo.WriteModel{}
+	for _, doc := range relationshipLogDocs(log.Repointed) {
+		d := doc.(bson.M)
+		relModels = append(relModels, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": d["_id"]}).
+			SetUpdate(bson.M{"$set": bson.M{"from": d["from"], "to": d["to"]}, "$inc": bson.M{"version": 1}}))
+	}
+	removed := relationshipIDs(log.Removed)
+	if len(removed) > 0 {
+		relModels = append(relModels, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{"_id": bson.M{"$in": objectIDs(removed)}}).
+			SetUpdate(trashRestoreUpdate))
+	}
+	familyModels, err := mergedFamilyModels(log.FamiliesBefore)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tracker, err := watchMergeRepo(ctx, log)
+	if err != nil {
+		return err
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		if len(relModels) > 0 {
+			if _, err := MongoDB.Collection("relationships").BulkWrite(ctx, relModels); err != nil {
+				return err
+			}
+		}
+		if len(familyModels) > 0 {
+			if _, err := MongoDB.Collection("families").BulkWrite(ctx, familyModels); err != nil {
+				return err
+			}
+		} else if len(log.Families) > 0 {
+			// logs without FamiliesBefore only moved the person
+			if _, err := MongoDB.Collection("families").UpdateMany(ctx,
+				bson.M{"_id": bson.M{"$in": objectIDs(log.Families)}},
+				bson.M{"$set": bson.M{"person": loserOID}, "$inc": bson.M{"version": 1}}); err != nil {
+				return err
+			}
+		}
+		people := MongoDB.Collection("people")
+		if _, err := people.UpdateOne(ctx, bson.M{"_id": winnerOID}, bson.M{"$set": bson.M{"ownedBy": objectIDs(log.WinnerOwnedBy)}, "$inc": bson.M{"version": 1}}); err != nil {
+			return err
+		}
+		if _, err := people.UpdateOne(ctx, bson.M{"_id": loserOID}, bson.M{"$set": bson.M{"deleted": false}, "$unset": bson.M{"deletedAt": "", "mergedInto": ""}, "$inc": bson.M{"version": 1}}); err != nil {
+			return err
+		}
+		if _, err := MongoDB.Collection("merges").UpdateOne(ctx, bson.M{"_id": mergeOID}, bson.M{"$set": bson.M{"revertedAt": time.Now()}}); err != nil {
+			return err
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invalidateGraphCaches(ctx)
+	return nil
+}
+
+// watchMergeRepo snapshots everything a merge or its revert touches.
+func watchMergeRepo(ctx context.Context, log *MergeLog) (*revisionTracker, error) {
+	reason := "merge"
+	if log.ID != "" {
+		reason = "merge-revert:" + log.ID
+	}
+	tracker := newRevisionTracker(reason)
+	rels := relationshipIDs(append(append([]Relationship{}, log.Repointed...), log.Removed...))
+	if err := tracker.watch(ctx, "people", log.Winner, log.Loser); err != nil {
+		return nil, err
+	}
+	if err := tracker.watch(ctx, "relationships", rels...); err != nil {
+		return nil, err
+	}
+	if err := tracker.watch(ctx, "families", log.Families...); err != nil {
+		return nil, err
+	}
+	return tracker, nil
+}
+
+// getMergeLogRepo fetches a merge log by ID.
+func getMergeLogRepo(ctx context.Context, id string) (*MergeLog, error) {
+	defer startSpan(ctx, "store/getMergeLogRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var doc bson.M
+	if err := MongoDB.Collection("merges").FindOne(ctx, bson.M{"_id": oid}).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return decodeMergeLog(doc), nil
+}
+
+// decodeMergeLog converts a document of the merges collection.
+func decodeMergeLog(doc bson.M) *MergeLog {
+	hex := func(v interface{}) string {
+		switch vv := v.(type) {
+		case primitive.ObjectID:
+			return vv.Hex()
+		case string:
+			return vv
+		}
+		return ""
+	}
+	hexes := func(v interface{}) []string {
+		out := []string{}
+		if arr, ok := v.(primitive.A); ok {
+			for _, it := range arr {
+				out = append(out, hex(it))
+			}
+		}
+		return out
+	}
+	rels := func(v interface{}) []Relationship {
+		out := []Relationship{}
+		if arr, ok := v.(primitive.A); ok {
+			for _, it := range arr {
+				if m, ok := it.(bson.M); ok {
+					r := Relationship{ID: hex(m["_id"]), From: hex(m["from"]), To: hex(m["to"]), Version: versionOf(m)}
+					if t, ok := m["type"].(string); ok {
+						r.Type = t
+					}
+					out = append(out, r)
+				}
+			}
+		}
+		return out
+	}
+
+	log := MergeLog{
+		ID:             hexID(doc["_id"]),
+		Winner:         hex(doc["winner"]),
+		Loser:          hex(doc["loser"]),
+		WinnerOwnedBy:  hexes(doc["winnerOwnedBy"]),
+		Repointed:      rels(doc["repointed"]),
+		Removed:        rels(doc["removed"]),
+		Families:       hexes(doc["families"]),
+		FamiliesBefore: []MergedFamily{},
+	}
+	if arr, ok := doc["familiesBefore"].(primitive.A); ok {
+		for _, it := range arr {
+			if m, ok := it.(bson.M); ok {
+				f := decodeM[familyDocument](m).family()
+				log.FamiliesBefore = append(log.FamiliesBefore, MergedFamily{ID: f.ID, Founders: f.Founders, Members: f.Members})
+			}
+		}
+	}
+	if v, ok := doc["mergedBy"].(string); ok {
+		log.MergedBy = v
+	}
+	if v, ok := doc["mergedAt"].(primitive.DateTime); ok {
+		log.MergedAt = v.Time()
+	}
+	if v, ok := doc["revertedAt"].(primitive.DateTime); ok {
+		t := v.Time()
+		log.RevertedAt = &t
+	}
+	return &log
+}
+
+// invalidateGraphCaches drops every cached person, relationship and family entry. Used by
+// writes that touch many documents at once.
+func invalidateGraphCaches(ctx context.Context) {
+	for _, pattern := range []string{"ft:person:*", "ft:people:*", "ft:relationships:*", "ft:families:*", "ft:family:*"} {
+		cacheDelPattern(ctx, pattern)
+	}
+}
+
+// familiesByPersonFilter matches the live families oid founds, co-founds as a spouse or is
+// an explicit member of. The stored person, which mirrors the first founder, still covers
+// families from before founding couples.
+func familiesByPersonFilter(oid primitive.ObjectID) bson.M {
+	return bson.M{
+		"$or": bson.A{
+			bson.M{"person": oid},
+			bson.M{"founders.person": oid},
+			bson.M{"founders.spouse": oid},
+			bson.M{"members": oid},
+		},
+		"deleted": bson.M{"$ne": true},
+	}
+}
+
+// getFamiliesByPersonRepo returns the live families personID founds, co-founds or is an
+// explicit member of, without the person populated.
+func getFamiliesByPersonRepo(ctx context.Context, personID string) ([]*Family, error) {
+	defer startSpan(ctx, "store/getFamiliesByPersonRepo").End()
+	oid, err := primitive.ObjectIDFromHex(personID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	cur, err := MongoDB.Collection("families").Find(ctx, familiesByPersonFilter(oid))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	res := []*Family{}
+	for cur.Next(ctx) {
+		var doc familyDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		res = append(res, doc.family())
+	}
+	return res, nil
+}
+
+// deletePersonCascadeRepo soft-deletes a person together with the given relationships and
+// families in one transaction.
+func deletePersonCascadeRepo(ctx context.Context, id string, relationshipIDs, familyIDs []string) error {
+	defer startSpan(ctx, "store/deletePersonCascadeRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "people", id); err != nil {
+		return err
+	}
+	if err := tracker.watch(ctx, "relationships", relationshipIDs...); err != nil {
+		return err
+	}
+	if err := tracker.watch(ctx, "families", familyIDs...); err != nil {
+		return err
+	}
+
+	// Soft delete: set deleted flag instead of removing
+	update := bson.M{"$set": bson.M{"deleted": true, "deletedAt": time.Now()}, "$inc": bson.M{"version": 1}}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		res, err := MongoDB.Collection("people").UpdateOne(ctx, bson.M{"_id": oid, "deleted": bson.M{"$ne": true}}, update)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		if len(relationshipIDs) > 0 {
+			if _, err := MongoDB.Collection("relationships").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(relationshipIDs)}, "deleted": bson.M{"$ne": true}}, update); err != nil {
+				return err
+			}
+		}
+		if len(familyIDs) > 0 {
+			if _, err := MongoDB.Collection("families").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(familyIDs)}, "deleted": bson.M{"$ne": true}}, update); err != nil {
+				return err
+			}
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invalidateGraphCaches(ctx)
+	return nil
+}
+
+// errVersionConflict is returned when a conditional write finds the document at a different
+// version than the one it was read at.
+var errVersionConflict = errors.New("document was modified concurrently")
+
+// errRelationshipsPartlyWritten is returned when, without a transaction, an edge changed
+// between the version check and the write, so only the other edges were written.
+var errRelationshipsPartlyWritten = errors.New("relationships were modified while being written; some changes were applied")
+
+// versionOf reads the version counter of a stored document; documents written before
+// versioning was introduced are version 0.
+func versionOf(doc bson.M) int {
+	switch v := doc["version"].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 0
+}
+
+// versionMatch is the filter value matching documents at version v, treating a missing
+// version field as 0.
+func versionMatch(v int) interface{} {
+	if v == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return v
+}
+
+// trashRestoreUpdate undoes a soft delete.
+var trashRestoreUpdate = bson.M{"$set": bson.M{"deleted": false}, "$unset": bson.M{"deletedAt": ""}, "$inc": bson.M{"version": 1}}
+
+// hexID returns the hex form of an ObjectID or legacy string reference.
+func hexID(v interface{}) string {
+	switch vv := v.(type) {
+	case primitive.ObjectID:
+		return vv.Hex()
+	case string:
+		return vv
+	}
+	return ""
+}
+
+// idForms returns the ObjectID and legacy string forms of id for from/to queries.
+func idForms(id string) bson.A {
+	forms := bson.A{id}
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		forms = append(forms, oid)
+	}
+	return forms
+}
+
+// deletedAtOf reads the deletedAt timestamp of a soft-deleted document.
+func deletedAtOf(doc bson.M) *time.Time {
+	if v, ok := doc["deletedAt"].(primitive.DateTime); ok {
+		t := v.Time()
+		return &t
+	}
+	return nil
+}
+
+// ownedPersonIDsRepo returns the IDs of every person owned by one of ownedBy, soft-deleted
+// people included.
+func ownedPersonIDsRepo(ctx context.Context, ownedBy []string) ([]string, error) {
+	defer startSpan(ctx, "store/ownedPersonIDsRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	cur, err := MongoDB.Collection("people").Find(ctx, bson.M{"ownedBy": bson.M{"$in": objectIDs(ownedBy)}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	ids := []string{}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err == nil {
+			ids = append(ids, hexID(doc["_id"]))
+		}
+	}
+	return ids, nil
+}
+
+// listTrashRepo returns the soft-deleted people, families and relationships visible to
+// ownedBy (everything when empty), most recently deleted first. Relationships are visible
+// when either end is a person owned by ownedBy.
+func listTrashRepo(ctx context.Context, ownedBy []string) (*Trash, error) {
+	defer startSpan(ctx, "store/listTrashRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	owned := []string{}
+	if len(ownedBy) > 0 {
+		var err error
+		if owned, err = ownedPersonIDsRepo(ctx, ownedBy); err != nil {
+			return nil, err
+		}
+	}
+	filters := trashFilters(ownedBy, owned)
+	opts := options.Find().SetSort(bson.D{{Key: "deletedAt", Value: -1}})
+
+	trash := &Trash{People: []*Person{}, Families: []*Family{}, Relationships: []*Relationship{}}
+
+	cur, err := MongoDB.Collection("people").Find(ctx, filters["people"], opts)
+	if err != nil {
+		return nil, err
+	}
+	for cur.Next(ctx) {
+		var doc personDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		trash.People = append(trash.People, trashPerson(&doc))
+	}
+	cur.Close(ctx)
+
+	cur, err = MongoDB.Collection("families").Find(ctx, filters["families"], opts)
+	if err != nil {
+		return nil, err
+	}
+	for cur.Next(ctx) {
+		var doc familyDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		trash.Families = append(trash.Families, trashFamily(&doc))
+	}
+	cur.Close(ctx)
+
+	cur, err = MongoDB.Collection("relationships").Find(ctx, filters["relationships"], opts)
+	if err != nil {
+		return nil, err
+	}
+	for cur.Next(ctx) {
+		var doc relationshipDocument
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		trash.Relationships = append(trash.Relationships, doc.relationship())
+	}
+	cur.Close(ctx)
+
+	return trash, nil
+}
+
+// trashFilters returns the query of each trash collection for ownedBy (everything when
+// empty). owned are the people owned by ownedBy, deleted ones included; relationships are
+// visible through them.
+func trashFilters(ownedBy, owned []string) map[string]bson.M {
+	filters := map[string]bson.M{}
+	for _, name := range trashCollections {
+		filters[name] = bson.M{"deleted": true}
+	}
+	if len(ownedBy) > 0 {
+		filters["people"]["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+		filters["families"]["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+		forms := bson.A{}
+		for _, id := range owned {
+			forms = append(forms, idForms(id)...)
+		}
+		filters["relationships"]["$or"] = bson.A{bson.M{"from": bson.M{"$in": forms}}, bson.M{"to": bson.M{"$in": forms}}}
+	}
+	return filters
+}
+
+// trashPerson converts a deleted person for the trash listing.
+func trashPerson(doc *personDocument) *Person {
+	p := doc.person()
+	if p.OwnedBy == nil {
+		p.OwnedBy = []string{}
+	}
+	return p
+}
+
+// trashFamily converts a deleted family for the trash listing, with only the ID of its
+// person.
+func trashFamily(doc *familyDocument) *Family {
+	f := doc.family()
+	if doc.Person != "" {
+		f.Person = &Person{ID: string(doc.Person)}
+	}
+	if f.OwnedBy == nil {
+		f.OwnedBy = []string{}
+	}
+	return f
+}
+
+// livePeopleRepo returns which of ids are live (not soft-deleted) people.
+func livePeopleRepo(ctx context.Context, ids []string) (map[string]bool, error) {
+	live := map[string]bool{}
+	if len(ids) == 0 {
+		return live, nil
+	}
+	cur, err := MongoDB.Collection("people").Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}, "deleted": bson.M{"$ne": true}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err == nil {
+			live[hexID(doc["_id"])] = true
+		}
+	}
+	return live, nil
+}
+
+// restorePersonRepo undeletes a person together with the relationships and families that
+// were soft-deleted with them (same deletedAt), skipping edges and families whose other
+// end or other founders are still deleted. ownedBy, when set, restricts the restore to
+// people owned by one of those users.
+func restorePersonRepo(ctx context.Context, id string, ownedBy []string) (*TrashRestore, error) {
+	defer startSpan(ctx, "store/restorePersonRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": oid, "deleted": true}
+	if len(ownedBy) > 0 {
+		filter["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	var doc bson.M
+	if err := MongoDB.Collection("people").FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil, err
+	}
+	restored := &TrashRestore{People: []string{id}, Families: []string{}, Relationships: []string{}}
+	deletedAt := deletedAtOf(doc)
+
+	if deletedAt != nil {
+		cur, err := MongoDB.Collection("relationships").Find(ctx, bson.M{
+			"deleted":   true,
+			"deletedAt": *deletedAt,
+			"$or":       bson.A{bson.M{"from": bson.M{"$in": idForms(id)}}, bson.M{"to": bson.M{"$in": idForms(id)}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		type edge struct{ id, other string }
+		edges := []edge{}
+		others := []string{}
+		for cur.Next(ctx) {
+			var rd bson.M
+			if err := cur.Decode(&rd); err != nil {
+				continue
+			}
+			other := hexID(rd["to"])
+			if other == id {
+				other = hexID(rd["from"])
+			}
+			edges = append(edges, edge{hexID(rd["_id"]), other})
+			others = append(others, other)
+		}
+		cur.Close(ctx)
+		live, err := livePeopleRepo(ctx, others)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range edges {
+			if live[e.other] {
+				restored.Relationships = append(restored.Relationships, e.id)
+			}
+		}
+
+		// families they founded or co-founded, unless another founder is still deleted
+		familyFilter := bson.M{
+			"$or":       bson.A{bson.M{"person": oid}, bson.M{"founders.person": oid}, bson.M{"founders.spouse": oid}},
+			"deleted":   true,
+			"deletedAt": *deletedAt,
+		}
+		cur, err = MongoDB.Collection("families").Find(ctx, familyFilter, options.Find().SetProjection(bson.M{"person": 1, "founders": 1}))
+		if err != nil {
+			return nil, err
+		}
+		families := []*Family{}
+		coFounders := []string{}
+		for cur.Next(ctx) {
+			var fd familyDocument
+			if err := cur.Decode(&fd); err == nil {
+				f := fd.family()
+				families = append(families, f)
+				coFounders = append(coFounders, slices.DeleteFunc(f.founderIDs(), func(p string) bool { return p == id })...)
+			}
+		}
+		cur.Close(ctx)
+		liveFounders, err := livePeopleRepo(ctx, coFounders)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range families {
+			if !slices.ContainsFunc(f.founderIDs(), func(p string) bool { return p != id && !liveFounders[p] }) {
+				restored.Families = append(restored.Families, f.ID)
+			}
+		}
+	}
+
+	tracker := newRevisionTracker("")
+	for collection, ids := range map[string][]string{"people": restored.People, "relationships": restored.Relationships, "families": restored.Families} {
+		if err := tracker.watch(ctx, collection, ids...); err != nil {
+			return nil, err
+		}
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := MongoDB.Collection("people").UpdateOne(ctx, bson.M{"_id": oid}, trashRestoreUpdate); err != nil {
+			return err
+		}
+		if len(restored.Relationships) > 0 {
+			if _, err := MongoDB.Collection("relationships").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(restored.Relationships)}}, trashRestoreUpdate); err != nil {
+				return err
+			}
+		}
+		if len(restored.Families) > 0 {
+			if _, err := MongoDB.Collection("families").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(restored.Families)}}, trashRestoreUpdate); err != nil {
+				return err
+			}
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateGraphCaches(ctx)
+	return restored, nil
+}
+
+// restoreFamilyRepo undeletes a family. Its founders and founding spouses must be live.
+func restoreFamilyRepo(ctx context.Context, id string, ownedBy []string) (*TrashRestore, error) {
+	defer startSpan(ctx, "store/restoreFamilyRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": oid, "deleted": true}
+	if len(ownedBy) > 0 {
+		filter["ownedBy"] = bson.M{"$in": objectIDs(ownedBy)}
+	}
+	var doc bson.M
+	if err := MongoDB.Collection("families").FindOne(ctx, filter).Decode(&doc); err != nil {
+		return nil, err
+	}
+	founders := decodeM[familyDocument](doc).family().founderIDs()
+	live, err := livePeopleRepo(ctx, founders)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range founders {
+		if !live[p] {
+			return nil, errTrashDependencyDeleted
+		}
+	}
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "families", id); err != nil {
+		return nil, err
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := MongoDB.Collection("families").UpdateOne(ctx, bson.M{"_id": oid}, trashRestoreUpdate); err != nil {
+			return err
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateGraphCaches(ctx)
+	return &TrashRestore{People: []string{}, Families: []string{id}, Relationships: []string{}}, nil
+}
+
+// restoreRelationshipRepo undeletes a relationship and its inverse edge when that was
+// deleted at the same time. Both people must be live. ownedBy, when set, requires one end
+// to be owned by one of those users.
+func restoreRelationshipRepo(ctx context.Context, id string, ownedBy []string) (*TrashRestore, error) {
+	defer startSpan(ctx, "store/restoreRelationshipRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	col := MongoDB.Collection("relationships")
+	var doc bson.M
+	if err := col.FindOne(ctx, bson.M{"_id": oid, "deleted": true}).Decode(&doc); err != nil {
+		return nil, err
+	}
+	from, to := hexID(doc["from"]), hexID(doc["to"])
+	if len(ownedBy) > 0 {
+		owned, err := ownedPersonIDsRepo(ctx, ownedBy)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(owned, from) && !slices.Contains(owned, to) {
+			return nil, mongo.ErrNoDocuments
+		}
+	}
+	live, err := livePeopleRepo(ctx, []string{from, to})
+	if err != nil {
+		return nil, err
+	}
+	if !live[from] || !live[to] {
+		return nil, errTrashDependencyDeleted
+	}
+
+	ids := []string{id}
+	typ, _ := doc["type"].(string)
+	if invType := inverseRelationshipType(typ); invType != "" {
+		invFilter := bson.M{"from": bson.M{"$in": idForms(to)}, "to": bson.M{"$in": idForms(from)}, "type": invType, "deleted": true}
+		if deletedAt := deletedAtOf(doc); deletedAt != nil {
+			invFilter["deletedAt"] = *deletedAt
+		}
+		var inv bson.M
+		if err := col.FindOne(ctx, invFilter).Decode(&inv); err == nil {
+			ids = append(ids, hexID(inv["_id"]))
+		}
+	}
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, "relationships", ids...); err != nil {
+		return nil, err
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		if _, err := col.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}}, trashRestoreUpdate); err != nil {
+			return err
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cacheDel(ctx, cacheKeyRelationships(from), cacheKeyRelationships(to), cacheKeyPerson(from), cacheKeyPerson(to))
+	cacheDelPattern(ctx, "ft:people:*")
+	return &TrashRestore{People: []string{}, Families: []string{}, Relationships: ids}, nil
+}
+
+// purgeTrashRepo permanently deletes a soft-deleted document. Purging a person also
+// removes their soft-deleted relationships. Like purgeExpiredTrashRepo, it keeps what
+// unreverted merges still need: such a document is refused with errTrashMergeHeld, and
+// such edges of a purged person are left alone.
+func purgeTrashRepo(ctx context.Context, kind, id string) error {
+	defer startSpan(ctx, "store/purgeTrashRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	held, err := mergeHeldDocsRepo(ctx)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(held[kind], id) {
+		return errTrashMergeHeld
+	}
+	tracker := newRevisionTracker("")
+	if err := tracker.watch(ctx, kind, id); err != nil {
+		return err
+	}
+	relIDs := []string{}
+	if kind == "people" {
+		cur, err := MongoDB.Collection("relationships").Find(ctx, bson.M{
+			"_id":     bson.M{"$nin": objectIDs(held["relationships"])},
+			"deleted": true,
+			"$or":     bson.A{bson.M{"from": bson.M{"$in": idForms(id)}}, bson.M{"to": bson.M{"$in": idForms(id)}}},
+		}, options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return err
+		}
+		for cur.Next(ctx) {
+			var doc bson.M
+			if err := cur.Decode(&doc); err == nil {
+				relIDs = append(relIDs, hexID(doc["_id"]))
+			}
+		}
+		cur.Close(ctx)
+		if err := tracker.watch(ctx, "relationships", relIDs...); err != nil {
+			return err
+		}
+	}
+
+	res, err := MongoDB.Collection(kind).DeleteOne(ctx, bson.M{"_id": oid, "deleted": true})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	if len(relIDs) > 0 {
+		if _, err := MongoDB.Collection("relationships").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(relIDs)}, "deleted": true}); err != nil {
+			return err
+		}
+	}
+	tracker.record(ctx)
+	return nil
+}
+
+// purgeBatchSize caps how many documents the retention job snapshots and deletes at once.
+const purgeBatchSize = 500
+
+// purgeExpiredTrashRepo permanently deletes people, families and relationships that were
+// soft-deleted before the cutoff, returning the count per collection. Losers of merges
+// that haven't been reverted and the edges those merges moved or removed are kept, so the
+// merges can still be reverted. Purges are recorded in the history like purgeTrashRepo's.
+func purgeExpiredTrashRepo(ctx context.Context, before time.Time) (map[string]int64, error) {
+	defer startSpan(ctx, "store/purgeExpiredTrashRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	held, err := mergeHeldDocsRepo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, name := range trashCollections {
+		filter := bson.M{"deleted": true, "deletedAt": bson.M{"$lt": before}}
+		if ids := held[name]; len(ids) > 0 {
+			filter["_id"] = bson.M{"$nin": objectIDs(ids)}
+		}
+		col := MongoDB.Collection(name)
+		for {
+			cur, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(purgeBatchSize))
+			if err != nil {
+				return counts, err
+			}
+			ids := []string{}
+			for cur.Next(ctx) {
+				var doc bson.M
+				if err := cur.Decode(&doc); err == nil {
+					ids = append(ids, hexID(doc["_id"]))
+				}
+			}
+			err = cur.Err()
+			cur.Close(ctx)
+			if err != nil {
+				return counts, err
+			}
+			if len(ids) == 0 {
+				break
+			}
+			tracker := newRevisionTracker("")
+			if err := tracker.watch(ctx, name, ids...); err != nil {
+				return counts, err
+			}
+			res, err := col.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}, "deleted": true})
+			if err != nil {
+				return counts, err
+			}
+			tracker.record(ctx)
+			counts[name] += res.DeletedCount
+			if len(ids) < purgeBatchSize || res.DeletedCount == 0 {
+				break
+			}
+		}
+	}
+	return counts, nil
+}
+
+// mergeHeldDocsRepo returns, per collection, the IDs of the documents that merges not yet
+// reverted would need back to be reverted: their losers and the edges they touched.
+func mergeHeldDocsRepo(ctx context.Context) (map[string][]string, error) {
+	cur, err := MongoDB.Collection("merges").Find(ctx,
+		bson.M{"revertedAt": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"loser": 1, "repointed._id": 1, "removed._id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	held := map[string][]string{}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		addMergeHeld(held, doc)
+	}
+	return held, cur.Err()
+}
+
+// addMergeHeld adds the loser and the edges of an unreverted merge to held.
+func addMergeHeld(held map[string][]string, merge bson.M) {
+	held["people"] = append(held["people"], hexID(merge["loser"]))
+	for _, key := range []string{"repointed", "removed"} {
+		edges, _ := merge[key].(bson.A)
+		for _, e := range edges {
+			if m, ok := e.(bson.M); ok {
+				held["relationships"] = append(held["relationships"], hexID(m["_id"]))
+			}
+		}
+	}
+}
+
+// revisionTracker snapshots documents before a mutation so that record can store a
+// revision for every one of them the mutation changed.
+type revisionTracker struct {
+	reason string
+	before map[string]map[string]bson.M // collection -> document ID -> snapshot (nil when missing)
+}
+
+func newRevisionTracker(reason string) *revisionTracker {
+	return &revisionTracker{reason: reason, before: map[string]map[string]bson.M{}}
+}
+
+// loadDocsRepo fetches the stored documents with the given IDs, soft-deleted ones included.
+func loadDocsRepo(ctx context.Context, collection string, ids []string) (map[string]bson.M, error) {
+	docs := map[string]bson.M{}
+	if len(ids) == 0 {
+		return docs, nil
+	}
+	cur, err := MongoDB.Collection(collection).Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs(ids)}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err == nil {
+			docs[hexID(doc["_id"])] = doc
+		}
+	}
+	return docs, nil
+}
+
+// watch snapshots the documents about to be changed. IDs of documents that don't exist
+// yet are recorded as creates.
+func (t *revisionTracker) watch(ctx context.Context, collection string, ids ...string) error {
+	docs, err := loadDocsRepo(ctx, collection, ids)
+	if err != nil {
+		return err
+	}
+	if t.before[collection] == nil {
+		t.before[collection] = map[string]bson.M{}
+	}
+	for _, id := range ids {
+		if _, ok := t.before[collection][id]; !ok {
+			t.before[collection][id] = docs[id]
+		}
+	}
+	return nil
+}
+
+// record stores a revision for each watched document that changed. Call it inside the
+// mutation's transaction, when there is one, so the history commits with the change.
+// Failures are logged rather than returned: the mutation itself has already succeeded.
+func (t *revisionTracker) record(ctx context.Context) {
+	defer startSpan(ctx, "store/recordRevisions").End()
+	now := time.Now()
+	actor := revisionActor(ctx)
+	docs := []interface{}{}
+	for collection, before := range t.before {
+		ids := make([]string, 0, len(before))
+		for id := range before {
+			ids = append(ids, id)
+		}
+		after, err := loadDocsRepo(ctx, collection, ids)
+		if err != nil {
+			logger(logHistory).ErrorContext(ctx, "failed to snapshot documents", "collection", collection, "error", err)
+			return
+		}
+		for _, id := range ids {
+			oid, _ := primitive.ObjectIDFromHex(id)
+			if doc := revisionDoc(collection, oid, before[id], after[id], t.reason, actor, now); doc != nil {
+				docs = append(docs, doc)
+			}
+		}
+	}
+	if len(docs) == 0 {
+		return
+	}
+	if _, err := MongoDB.Collection("revisions").InsertMany(ctx, docs); err != nil {
+		logger(logHistory).ErrorContext(ctx, "failed to record revisions", "count", len(docs), "error", err)
+	}
+}
+
+// revisionDoc builds the revisions entry for a document that went from before to after,
+// or returns nil when nothing changed.
+func revisionDoc(collection string, id primitive.ObjectID, before, after bson.M, reason string, actor interface{}, now time.Time) bson.M {
+	fields := changedFields(before, after)
+	if len(fields) == 0 {
+		return nil
+	}
+	doc := bson.M{
+		"collection": collection,
+		"documentId": id,
+		"action":     revisionAction(before, after),
+		"fields":     fields,
+		"before":     before,
+		"after":      after,
+		"changedBy":  actor,
+		"changedAt":  now,
+	}
+	if reason != "" {
+		doc["reason"] = reason
+	}
+	return doc
+}
+
+// revisionActor returns the ObjectID of the authenticated user behind ctx, or nil for
+// changes made by the server itself (e.g. the trash retention job).
+func revisionActor(ctx context.Context) interface{} {
+	if u, ok := ctx.Value("user").(*User); ok {
+		if oid, err := primitive.ObjectIDFromHex(u.ID); err == nil {
+			return oid
+		}
+	}
+	return nil
+}
+
+// changedFields lists the top-level fields that differ between two snapshots, sorted.
+func changedFields(before, after bson.M) []string {
+	fields := []string{}
+	for k, v := range before {
+		if w, ok := after[k]; k != "_id" && (!ok || !reflect.DeepEqual(v, w)) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range after {
+		if _, ok := before[k]; k != "_id" && !ok {
+			fields = append(fields, k)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// revisionAction classifies a change from its before/after snapshots.
+func revisionAction(before, after bson.M) string {
+	switch {
+	case before == nil:
+		return RevisionCreate
+	case after == nil:
+		return RevisionPurge
+	}
+	wasDeleted, _ := before["deleted"].(bool)
+	isDeleted, _ := after["deleted"].(bool)
+	switch {
+	case !wasDeleted && isDeleted:
+		return RevisionDelete
+	case wasDeleted && !isDeleted:
+		return RevisionRestore
+	}
+	return RevisionUpdate
+}
+
+func decodeRevision(doc bson.M) *Revision {
+	r := &Revision{
+		ID:         hexID(doc["_id"]),
+		DocumentID: hexID(doc["documentId"]),
+		ChangedBy:  hexID(doc["changedBy"]),
+		Fields:     []string{},
+	}
+	if v, ok := doc["collection"].(string); ok {
+		r.Collection = v
+	}
+	if v, ok := doc["action"].(string); ok {
+		r.Action = v
+	}
+	if v, ok := doc["reason"].(string); ok {
+		r.Reason = v
+	}
+	if v, ok := doc["fields"].(primitive.A); ok {
+		for _, f := range v {
+			if s, ok := f.(string); ok {
+				r.Fields = append(r.Fields, s)
+			}
+		}
+	}
+	if v, ok := doc["before"].(bson.M); ok {
+		r.Before = v
+	}
+	if v, ok := doc["after"].(bson.M); ok {
+		r.After = v
+	}
+	if v, ok := doc["changedAt"].(primitive.DateTime); ok {
+		r.ChangedAt = v.Time()
+	}
+	return r
+}
+
+// getRevisionsRepo returns the revisions of a document, newest first.
+func getRevisionsRepo(ctx context.Context, collection, id string) ([]*Revision, error) {
+	defer startSpan(ctx, "store/getRevisionsRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "changedAt", Value: -1}, {Key: "_id", Value: -1}})
+	cur, err := MongoDB.Collection("revisions").Find(ctx, bson.M{"collection": collection, "documentId": oid}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	revs := []*Revision{}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err == nil {
+			revs = append(revs, decodeRevision(doc))
+		}
+	}
+	return revs, nil
+}
+
+// getRevisionRepo fetches a single revision by ID.
+func getRevisionRepo(ctx context.Context, id string) (*Revision, error) {
+	defer startSpan(ctx, "store/getRevisionRepo").End()
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var doc bson.M
+	if err := MongoDB.Collection("revisions").FindOne(ctx, bson.M{"_id": oid}).Decode(&doc); err != nil {
+		return nil, err
+	}
+	return decodeRevision(doc), nil
+}
+
+// revertToRevisionRepo replaces a live document at version with the state recorded after
+// rev, keeping revertKeptFields. Reverting a relationship also gives its live inverse the
+// shared fields of that state, in the same transaction.
+func revertToRevisionRepo(ctx context.Context, rev *Revision, version int) error {
+	defer startSpan(ctx, "store/revertToRevisionRepo").End()
+	oid, err := primitive.ObjectIDFromHex(rev.DocumentID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	tracker := newRevisionTracker("revert:" + rev.ID)
+	if err := tracker.watch(ctx, rev.Collection, rev.DocumentID); err != nil {
+		return err
+	}
+	current := tracker.before[rev.Collection][rev.DocumentID]
+	if err := checkRevertable(current, version); err != nil {
+		return err
+	}
+	doc := revertedDoc(rev.After, current)
+	if rev.Collection == "people" {
+		doc["searchKeys"] = searchKeysOf(doc)
+	}
+	var inverse interface{} // _id of the live inverse edge, if there is one
+	if rev.Collection == "relationships" {
+		typ, _ := current["type"].(string)
+		if invType := inverseRelationshipType(typ); invType != "" {
+			var inv bson.M
+			err := MongoDB.Collection("relationships").FindOne(ctx, bson.M{
+				"from":    bson.M{"$in": idForms(hexID(current["to"]))},
+				"to":      bson.M{"$in": idForms(hexID(current["from"]))},
+				"type":    invType,
+				"deleted": bson.M{"$ne": true},
+			}).Decode(&inv)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return err
+			}
+			if inv != nil {
+				inverse = inv["_id"]
+				if err := tracker.watch(ctx, "relationships", hexID(inverse)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	err = withTransaction(ctx, func(ctx context.Context) error {
+		res, err := MongoDB.Collection(rev.Collection).ReplaceOne(ctx, bson.M{"_id": oid, "deleted": bson.M{"$ne": true}, "version": versionMatch(version)}, doc)
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errVersionConflict
+		}
+		if inverse != nil {
+			set, unset := inverseRevertFields(rev.After)
+			update := bson.M{"$set": set, "$inc": bson.M{"version": 1}}
+			if len(unset) > 0 {
+				fields := bson.M{}
+				for _, k := range unset {
+					fields[k] = ""
+				}
+				update["$unset"] = fields
+			}
+			if _, err := MongoDB.Collection("relationships").UpdateOne(ctx, bson.M{"_id": inverse, "deleted": bson.M{"$ne": true}}, update); err != nil {
+				return err
+			}
+		}
+		tracker.record(ctx)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	invalidateGraphCaches(ctx)
+	return nil
+}
+
+// stateBeforeChangesRepo returns, for every document of collection changed after at, its
+// state before the first of those changes: what it was at that time, or nil when it
+// didn't exist yet. Only the revisions since at are read.
+func stateBeforeChangesRepo(ctx context.Context, collection string, at time.Time) (map[string]bson.M, error) {
+	defer startSpan(ctx, "store/stateBeforeChangesRepo").End()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	opts := options.Find().
+		SetSort(bson.D{{Key: "changedAt", Value: 1}, {Key: "_id", Value: 1}}).
+		SetProjection(bson.M{"documentId": 1, "before": 1})
+	cur, err := MongoDB.Collection("revisions").Find(ctx, bson.M{"collection": collection, "changedAt": bson.M{"$gt": at}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	states := map[string]bson.M{}
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		id := hexID(doc["documentId"])
+		if _, seen := states[id]; !seen {
+			before, _ := doc["before"].(bson.M)
+			states[id] = before
+		}
+	}
+	return states, cur.Err()
+}
+
+// insertSecurityEventRepo appends an entry to the security log.
+func insertSecurityEventRepo(ctx context.Context, ev *SecurityEvent) error {
+	res, err := MongoDB.Collection("securityLog").InsertOne(ctx, securityEventDoc(ev))
+	if err != nil {
+		return err
+	}
+	ev.ID = res.InsertedID.(primitive.ObjectID).Hex()
+	return nil
+}
+
+// securityEventDoc encodes an entry of the security log.
+func securityEventDoc(ev *SecurityEvent) bson.M {
+	doc := bson.M{
+		"action":  ev.Action,
+		"target":  ev.Target,
+		"ip":      ev.IP,
+		"outcome": ev.Outcome,
+		"status":  ev.Status,
+		"at":      ev.At,
+	}
+	if oid, err := primitive.ObjectIDFromHex(ev.Actor); err == nil {
+		doc["actor"] = oid
+		doc["actorUsername"] = ev.ActorUsername
+	}
+	if ev.Detail != "" {
+		doc["detail"] = ev.Detail
+	}
+	return doc
+}
+
+// eachSecurityEventRepo streams the security log entries matching f, newest first, to fn.
+// limit <= 0 means no limit.
+func eachSecurityEventRepo(ctx context.Context, f SecurityLogFilter, limit int, fn func(*SecurityEvent) error) error {
+	defer startSpan(ctx, "store/eachSecurityEventRepo").End()
+	opts := options.Find().SetSort(bson.D{{Key: "at", Value: -1}, {Key: "_id", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := MongoDB.Collection("securityLog").Find(ctx, securityLogQuery(f), opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc bson.M
+		if err := cur.Decode(&doc); err != nil {
+			continue
+		}
+		if err := fn(decodeSecurityEvent(doc)); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// securityLogQuery turns a security log filter into a query on the securityLog collection.
+func securityLogQuery(f SecurityLogFilter) bson.M {
+	filter := bson.M{}
+	if f.Actor != "" {
+		filter["actor"] = bson.M{"$in": idForms(f.Actor)}
+	}
+	for key, v := range map[string]string{"action": f.Action, "target": f.Target, "ip": f.IP, "outcome": f.Outcome} {
+		if v != "" {
+			filter[key] = v
+		}
+	}
+	at := bson.M{}
+	if !f.From.IsZero() {
+		at["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		at["$lte"] = f.To
+	}
+	if len(at) > 0 {
+		filter["at"] = at
+	}
+	return filter
+}
+
+// decodeSecurityEvent converts a document of the securityLog collection.
+func decodeSecurityEvent(doc bson.M) *SecurityEvent {
+	ev := &SecurityEvent{ID: hexID(doc["_id"]), Actor: hexID(doc["actor"])}
+	ev.ActorUsername, _ = doc["actorUsername"].(string)
+	ev.Action, _ = doc["action"].(string)
+	ev.Target, _ = doc["target"].(string)
+	ev.IP, _ = doc["ip"].(string)
+	ev.Outcome, _ = doc["outcome"].(string)
+	ev.Detail, _ = doc["detail"].(string)
+	if v, ok := doc["status"].(int32); ok {
+		ev.Status = int(v)
+	}
+	if v, ok := doc["at"].(primitive.DateTime); ok {
+		ev.At = v.Time()
+	}
+	return ev
+}
@@ -0,0 +1,372 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// UserStore persists user accounts. Lookups skip soft-deleted users.
+type UserStore interface {
+	Get(ctx context.Context, id string) (*User, error)
+	// GetByUsername includes the password hash, for sign-in.
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	Create(ctx context.Context, u *User) (*User, error)
+	// SetPassword replaces the password hash of the user with id.
+	SetPassword(ctx context.Context, id, hash string) error
+	ListByRole(ctx context.Context, role UserRole) ([]User, error)
+	Page(ctx context.Context, q *listQuery) ([]User, string, error)
+}
+
+// PersonStore persists people. ownedBy limits a listing to people owned by one of those
+// users; nil lists everyone. Soft-deleted people are never returned.
+type PersonStore interface {
+	Get(ctx context.Context, id string) (*Person, error)
+	List(ctx context.Context, ownedBy []string) ([]*Person, error)
+	Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Person, string, error)
+	Search(ctx context.Context, ownedBy []string, text string, keys []string, minHits int) ([]searchCandidate, error)
+	Create(ctx context.Context, p *Person) (*Person, error)
+	// Update is conditional on p.Version and returns errVersionConflict when it moved on.
+	Update(ctx context.Context, p *Person) (*Person, error)
+	// Delete soft-deletes the person together with the given relationships and families.
+	Delete(ctx context.Context, id string, relationshipIDs, familyIDs []string) error
+	// OwnedIDs lists the IDs of the people owned by one of ownedBy, soft-deleted ones
+	// included.
+	OwnedIDs(ctx context.Context, ownedBy []string) ([]string, error)
+}
+
+// FamilyStore persists families, with the same ownership and soft-delete rules as people.
+type FamilyStore interface {
+	Get(ctx context.Context, id string) (*Family, error)
+	List(ctx context.Context, ownedBy []string) ([]*Family, error)
+	Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Family, string, error)
+	// ListByPerson lists the families personID founds, co-founds as a spouse or is an
+	// explicit member of.
+	ListByPerson(ctx context.Context, personID string) ([]*Family, error)
+	Create(ctx context.Context, f *Family) (*Family, error)
+	// Update is conditional on f.Version and returns errVersionConflict when it moved on.
+	Update(ctx context.Context, f *Family) (*Family, error)
+	Delete(ctx context.Context, id string) (*Family, error)
+}
+
+// RelationshipStore persists relationship edges.
+type RelationshipStore interface {
+	// ListByPerson lists the live edges from or to personID, with both ends populated.
+	ListByPerson(ctx context.Context, personID string) ([]*Relationship, error)
+	// Apply writes a batch atomically. Updates and deletes are conditional on each edge's
+	// version; if any moved on, nothing is written and errVersionConflict is returned.
+	// Without transactions an edge can still change between that check and the write,
+	// and the other edges are then written and errRelationshipsPartlyWritten is returned.
+	Apply(ctx context.Context, inserts, updates, deletes []Relationship) ([]RelationshipChange, error)
+}
+
+// MergeStore persists person merges and the logs that let them be reverted.
+type MergeStore interface {
+	// Apply carries out a planned merge atomically and sets log.ID.
+	Apply(ctx context.Context, log *MergeLog, ownedBy []string) error
+	Get(ctx context.Context, id string) (*MergeLog, error)
+	// Revert undoes a merge and marks its log reverted.
+	Revert(ctx context.Context, log *MergeLog) error
+}
+
+// TrashStore lists, restores and purges soft-deleted people, families and relationships.
+// ownedBy limits it to documents owned by one of those users, relationships through
+// either end; nil means everything.
+type TrashStore interface {
+	List(ctx context.Context, ownedBy []string) (*Trash, error)
+	// Restore returns errTrashDependencyDeleted when a person the document needs is still
+	// deleted.
+	Restore(ctx context.Context, kind, id string, ownedBy []string) (*TrashRestore, error)
+	// Purge returns errTrashMergeHeld for what unreverted merges still need; a person's
+	// edges that they need are kept.
+	Purge(ctx context.Context, kind, id string) error
+	// PurgeExpired purges what was deleted before the cutoff, except what unreverted
+	// merges still need, and returns the count per collection.
+	PurgeExpired(ctx context.Context, before time.Time) (map[string]int64, error)
+}
+
+// HistoryStore reads and reverts the revisions recorded for people, families and
+// relationships.
+type HistoryStore interface {
+	// List returns the revisions of a document, newest first.
+	List(ctx context.Context, collection, id string) ([]*Revision, error)
+	Get(ctx context.Context, id string) (*Revision, error)
+	// Revert puts a document back into the state recorded after rev, except for
+	// revertKeptFields, together with a relationship's inverse edge. It returns
+	// errRevisionDeleted for a deleted or purged document and errVersionConflict when the
+	// document is no longer at version.
+	Revert(ctx context.Context, rev *Revision, version int) error
+	// PeopleBefore returns, for every person changed after at, how they were at that
+	// time: nil when they didn't exist yet or were deleted.
+	PeopleBefore(ctx context.Context, at time.Time) (map[string]*Person, error)
+	// RelationshipsBefore is PeopleBefore for relationship edges.
+	RelationshipsBefore(ctx context.Context, at time.Time) (map[string]*Relationship, error)
+}
+
+// AuditStore reads the whole graph for the data-quality audit.
+type AuditStore interface {
+	// Scan loads every person (deleted included), live relationship and live family.
+	Scan(ctx context.Context) ([]auditPerson, []auditRelationship, []auditFamily, error)
+	// ConvertIDs rewrites legacy string from/to values of rels as ObjectIDs.
+	ConvertIDs(ctx context.Context, rels []Relationship) error
+}
+
+// SecurityLogStore appends to and queries the security log.
+type SecurityLogStore interface {
+	Insert(ctx context.Context, ev *SecurityEvent) error
+	// Each streams the entries matching f to fn, newest first. limit <= 0 means no limit.
+	Each(ctx context.Context, f SecurityLogFilter, limit int, fn func(*SecurityEvent) error) error
+}
+
+// Stores is the persistence the handlers work against. RegisterRoutes puts it on every
+// request; code further down finds it on the context with storesFrom.
+type Stores struct {
+	Users         UserStore
+	People        PersonStore
+	Families      FamilyStore
+	Relationships RelationshipStore
+	Merges        MergeStore
+	Trash         TrashStore
+	History       HistoryStore
+	Audit         AuditStore
+	SecurityLog   SecurityLogStore
+}
+
+// NewMongoStores returns the stores backed by MongoDB (and the Redis cache, if configured).
+func NewMongoStores() *Stores {
+	return &Stores{
+		Users:         mongoUserStore{},
+		People:        mongoPersonStore{},
+		Families:      mongoFamilyStore{},
+		Relationships: mongoRelationshipStore{},
+		Merges:        mongoMergeStore{},
+		Trash:         mongoTrashStore{},
+		History:       mongoHistoryStore{},
+		Audit:         mongoAuditStore{},
+		SecurityLog:   mongoSecurityLogStore{},
+	}
+}
+
+// storesKey is the context key the stores of a request are kept under.
+type storesKey struct{}
+
+// WithStores returns a copy of ctx carrying s, for work that doesn't come through the
+// router, like subcommands and background jobs.
+func WithStores(ctx context.Context, s *Stores) context.Context {
+	return context.WithValue(ctx, storesKey{}, s)
+}
+
+// useStores makes s available to the handlers of a request.
+func useStores(s *Stores) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(storesKey{}, s)
+		c.Next()
+	}
+}
+
+// storesFrom returns the stores that RegisterRoutes or WithStores put on ctx. Contexts
+// derived from a gin context find the request's stores through it. It panics when ctx
+// carries none, since falling back to some other persistence would hide the bug.
+func storesFrom(ctx context.Context) *Stores {
+	if c, ok := ctx.Value(gin.ContextKey).(*gin.Context); ok {
+		if s, ok := c.Get(storesKey{}); ok {
+			return s.(*Stores)
+		}
+	}
+	if s, ok := ctx.Value(storesKey{}).(*Stores); ok {
+		return s
+	}
+	panic("app: no stores on the context; use RegisterRoutes or WithStores")
+}
+
+type mongoUserStore struct{}
+
+func (mongoUserStore) Get(ctx context.Context, id string) (*User, error) {
+	return findUserById(ctx, id)
+}
+
+func (mongoUserStore) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return findUserByUsername(ctx, username)
+}
+
+func (mongoUserStore) Create(ctx context.Context, u *User) (*User, error) {
+	return addUser(ctx, u)
+}
+
+func (mongoUserStore) SetPassword(ctx context.Context, id, hash string) error {
+	return setUserPasswordRepo(ctx, id, hash)
+}
+
+func (mongoUserStore) ListByRole(ctx context.Context, role UserRole) ([]User, error) {
+	return repoFindUsers(ctx, bson.M{"role": role})
+}
+
+func (mongoUserStore) Page(ctx context.Context, q *listQuery) ([]User, string, error) {
+	return listUsersRepo(ctx, q)
+}
+
+type mongoPersonStore struct{}
+
+func (mongoPersonStore) Get(ctx context.Context, id string) (*Person, error) {
+	return getPersonByIdRepo(ctx, id)
+}
+
+func (mongoPersonStore) List(ctx context.Context, ownedBy []string) ([]*Person, error) {
+	return repoGetAllPeople(ctx, ownedBy)
+}
+
+func (mongoPersonStore) Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Person, string, error) {
+	return listPeopleRepo(ctx, ownedBy, q)
+}
+
+func (mongoPersonStore) Search(ctx context.Context, ownedBy []string, text string, keys []string, minHits int) ([]searchCandidate, error) {
+	return searchPeopleRepo(ctx, ownedBy, text, keys, minHits)
+}
+
+func (mongoPersonStore) Create(ctx context.Context, p *Person) (*Person, error) {
+	return createPersonRepo(ctx, p)
+}
+
+func (mongoPersonStore) Update(ctx context.Context, p *Person) (*Person, error) {
+	return updatePersonRepo(ctx, p)
+}
+
+func (mongoPersonStore) Delete(ctx context.Context, id string, relationshipIDs, familyIDs []string) error {
+	return deletePersonCascadeRepo(ctx, id, relationshipIDs, familyIDs)
+}
+
+func (mongoPersonStore) OwnedIDs(ctx context.Context, ownedBy []string) ([]string, error) {
+	return ownedPersonIDsRepo(ctx, ownedBy)
+}
+
+type mongoFamilyStore struct{}
+
+func (mongoFamilyStore) Get(ctx context.Context, id string) (*Family, error) {
+	return getFamilyByIdRepo(ctx, id)
+}
+
+func (mongoFamilyStore) List(ctx context.Context, ownedBy []string) ([]*Family, error) {
+	return getAllFamiliesRepo(ctx, ownedBy)
+}
+
+func (mongoFamilyStore) Page(ctx context.Context, ownedBy []string, q *listQuery) ([]*Family, string, error) {
+	return listFamiliesRepo(ctx, ownedBy, q)
+}
+
+func (mongoFamilyStore) ListByPerson(ctx context.Context, personID string) ([]*Family, error) {
+	return getFamiliesByPersonRepo(ctx, personID)
+}
+
+func (mongoFamilyStore) Create(ctx context.Context, f *Family) (*Family, error) {
+	return createFamilyRepo(ctx, f)
+}
+
+func (mongoFamilyStore) Update(ctx context.Context, f *Family) (*Family, error) {
+	return updateFamilyRepo(ctx, f)
+}
+
+func (mongoFamilyStore) Delete(ctx context.Context, id string) (*Family, error) {
+	return deleteFamilyRepo(ctx, id)
+}
+
+type mongoRelationshipStore struct{}
+
+func (mongoRelationshipStore) ListByPerson(ctx context.Context, personID string) ([]*Relationship, error) {
+	return getRelationshipsByPersonIdRepo(ctx, personID)
+}
+
+func (mongoRelationshipStore) Apply(ctx context.Context, inserts, updates, deletes []Relationship) ([]RelationshipChange, error) {
+	return applyRelationshipChangesRepo(ctx, inserts, updates, deletes)
+}
+
+type mongoMergeStore struct{}
+
+func (mongoMergeStore) Apply(ctx context.Context, log *MergeLog, ownedBy []string) error {
+	return mergePeopleRepo(ctx, log, ownedBy)
+}
+
+func (mongoMergeStore) Get(ctx context.Context, id string) (*MergeLog, error) {
+	return getMergeLogRepo(ctx, id)
+}
+
+func (mongoMergeStore) Revert(ctx context.Context, log *MergeLog) error {
+	return revertMergeRepo(ctx, log)
+}
+
+type mongoTrashStore struct{}
+
+func (mongoTrashStore) List(ctx context.Context, ownedBy []string) (*Trash, error) {
+	return listTrashRepo(ctx, ownedBy)
+}
+
+func (mongoTrashStore) Restore(ctx context.Context, kind, id string, ownedBy []string) (*TrashRestore, error) {
+	switch kind {
+	case "people":
+		return restorePersonRepo(ctx, id, ownedBy)
+	case "families":
+		return restoreFamilyRepo(ctx, id, ownedBy)
+	case "relationships":
+		return restoreRelationshipRepo(ctx, id, ownedBy)
+	}
+	return nil, errUnknownTrashKind
+}
+
+func (mongoTrashStore) Purge(ctx context.Context, kind, id string) error {
+	return purgeTrashRepo(ctx, kind, id)
+}
+
+func (mongoTrashStore) PurgeExpired(ctx context.Context, before time.Time) (map[string]int64, error) {
+	return purgeExpiredTrashRepo(ctx, before)
+}
+
+type mongoHistoryStore struct{}
+
+func (mongoHistoryStore) List(ctx context.Context, collection, id string) ([]*Revision, error) {
+	return getRevisionsRepo(ctx, collection, id)
+}
+
+func (mongoHistoryStore) Get(ctx context.Context, id string) (*Revision, error) {
+	return getRevisionRepo(ctx, id)
+}
+
+func (mongoHistoryStore) Revert(ctx context.Context, rev *Revision, version int) error {
+	return revertToRevisionRepo(ctx, rev, version)
+}
+
+func (mongoHistoryStore) PeopleBefore(ctx context.Context, at time.Time) (map[string]*Person, error) {
+	docs, err := stateBeforeChangesRepo(ctx, "people", at)
+	if err != nil {
+		return nil, err
+	}
+	return statesBefore(docs, decodePersonListDoc), nil
+}
+
+func (mongoHistoryStore) RelationshipsBefore(ctx context.Context, at time.Time) (map[string]*Relationship, error) {
+	docs, err := stateBeforeChangesRepo(ctx, "relationships", at)
+	if err != nil {
+		return nil, err
+	}
+	return statesBefore(docs, decodeRelationshipDoc), nil
+}
+
+type mongoAuditStore struct{}
+
+func (mongoAuditStore) Scan(ctx context.Context) ([]auditPerson, []auditRelationship, []auditFamily, error) {
+	return scanAuditDataRepo(ctx)
+}
+
+func (mongoAuditStore) ConvertIDs(ctx context.Context, rels []Relationship) error {
+	return convertRelationshipIDsRepo(ctx, rels)
+}
+
+type mongoSecurityLogStore struct{}
+
+func (mongoSecurityLogStore) Insert(ctx context.Context, ev *SecurityEvent) error {
+	return insertSecurityEventRepo(ctx, ev)
+}
+
+func (mongoSecurityLogStore) Each(ctx context.Context, f SecurityLogFilter, limit int, fn func(*SecurityEvent) error) error {
+	return eachSecurityEventRepo(ctx, f, limit, fn)
+}
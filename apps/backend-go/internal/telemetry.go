@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Tracing and metrics backends, selected by TelemetryConfig.
+var (
+	tracingBackends = []string{"newrelic", "otlp", "none"}
+	metricsBackends = []string{"prometheus", "none"}
+)
+
+// span is a timed unit of work of the tracing backend. Handlers and stores open one with
+// startSpan and end it when they return.
+type span interface {
+	End()
+}
+
+// tracer is a tracing backend.
+type tracer interface {
+	// start opens a span named name under the span of ctx, if any.
+	start(ctx context.Context, name string) span
+	// startExternal opens a span for a call to an outside service.
+	startExternal(ctx context.Context, service, procedure, url string) span
+	// startDatastore opens a span for one MongoDB command.
+	startDatastore(ctx context.Context, database, collection, command string) span
+	// middleware opens the span of each request.
+	middleware() gin.HandlerFunc
+	// shutdown flushes what hasn't been sent yet.
+	shutdown(ctx context.Context) error
+}
+
+// Telemetry is the tracing and metrics backends the app reports to. InitTelemetry
+// replaces the package-level one at startup; until then nothing is recorded.
+type Telemetry struct {
+	tracer  tracer
+	metrics *metrics // nil when metrics are off
+}
+
+var telemetry = &Telemetry{tracer: noopTracer{}}
+
+// InitTelemetry starts the backends chosen in cfg.Telemetry. LoadConfig has already
+// checked the backend names. When the tracing backend fails to start, the error comes
+// back with a Telemetry that doesn't trace but still has its metrics.
+func InitTelemetry(ctx context.Context, cfg *Config) (*Telemetry, error) {
+	t := &Telemetry{tracer: noopTracer{}}
+	if cfg.Telemetry.Metrics == "prometheus" {
+		t.metrics = newMetrics()
+	}
+	telemetry = t
+	switch cfg.Telemetry.Tracing {
+	case "newrelic":
+		nr, err := InitNewRelic(cfg.NewRelic)
+		if err != nil {
+			return t, err
+		}
+		if nr != nil {
+			t.tracer = newRelicTracer{app: nr}
+		}
+	case "otlp":
+		ot, err := newOTelTracer(ctx, cfg.Telemetry)
+		if err != nil {
+			return t, err
+		}
+		t.tracer = ot
+	}
+	return t, nil
+}
+
+// Tracing reports whether spans are recorded anywhere.
+func (t *Telemetry) Tracing() bool {
+	_, off := t.tracer.(noopTracer)
+	return !off
+}
+
+// Middleware opens the span of each request and records its latency by route.
+func (t *Telemetry) Middleware() gin.HandlerFunc {
+	trace := t.tracer.middleware()
+	return func(c *gin.Context) {
+		start := time.Now()
+		trace(c)
+		t.metrics.observeRequest(c, time.Since(start))
+	}
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format, or is nil when
+// metrics are off.
+func (t *Telemetry) MetricsHandler() http.Handler {
+	if t.metrics == nil {
+		return nil
+	}
+	return t.metrics.handler()
+}
+
+// Shutdown sends the spans still buffered, giving up when ctx is done.
+func (t *Telemetry) Shutdown(ctx context.Context) error {
+	return t.tracer.shutdown(ctx)
+}
+
+// startSpan opens a span named name, like "handler/getPerson" or "store/people.get".
+func startSpan(ctx context.Context, name string) span {
+	return telemetry.tracer.start(ctx, name)
+}
+
+// startExternalSpan opens a span for a call to an outside service, like an upload to
+// Cloudinary.
+func startExternalSpan(ctx context.Context, service, procedure, url string) span {
+	return telemetry.tracer.startExternal(ctx, service, procedure, url)
+}
+
+// observeCache counts a cache lookup of key as a hit or a miss.
+func observeCache(key string, hit bool) {
+	telemetry.metrics.observeCache(key, hit)
+}
+
+// observeTree records the size and build time of a family tree.
+func observeTree(root internalNode, took time.Duration) {
+	if telemetry.metrics == nil {
+		return
+	}
+	telemetry.metrics.observeTree(countTreeNodes(root), took)
+}
+
+func countTreeNodes(n internalNode) int {
+	count := 1
+	for _, list := range [][]internalNode{n.Children, n.Spouses, n.Parents} {
+		for _, c := range list {
+			count += countTreeNodes(c)
+		}
+	}
+	return count
+}
+
+// mongoMonitor times every MongoDB command and traces it with the current backend. The
+// backend is looked up per command, so the monitor can be installed before
+// InitTelemetry runs.
+func mongoMonitor() *event.CommandMonitor {
+	var spans sync.Map // request ID -> span
+	end := func(requestID int64, command string, took time.Duration, failed bool) {
+		if s, ok := spans.LoadAndDelete(requestID); ok {
+			s.(span).End()
+		}
+		telemetry.metrics.observeMongo(command, took, failed)
+	}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			var collection string
+			if v, err := e.Command.IndexErr(0); err == nil {
+				collection, _ = v.Value().StringValueOK()
+			}
+			spans.Store(e.RequestID, telemetry.tracer.startDatastore(ctx, e.DatabaseName, collection, e.CommandName))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			end(e.RequestID, e.CommandName, e.Duration, false)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			end(e.RequestID, e.CommandName, e.Duration, true)
+		},
+	}
+}
+
+// noopTracer records nothing.
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopSpan) End() {}
+
+func (noopTracer) start(context.Context, string) span { return noopSpan{} }
+
+func (noopTracer) startExternal(context.Context, string, string, string) span { return noopSpan{} }
+
+func (noopTracer) startDatastore(context.Context, string, string, string) span { return noopSpan{} }
+
+func (noopTracer) middleware() gin.HandlerFunc { return func(c *gin.Context) { c.Next() } }
+
+func (noopTracer) shutdown(context.Context) error { return nil }
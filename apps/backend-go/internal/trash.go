@@ -0,0 +1,96 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// trashCollections are the collections whose soft-deleted documents show up in the trash.
+var trashCollections = []string{"people", "families", "relationships"}
+
+// errTrashDependencyDeleted is returned when restoring a document whose person is still deleted.
+var errTrashDependencyDeleted = errors.New("restore the related person first")
+
+// errTrashMergeHeld is returned when purging a document that a merge not yet reverted
+// would need back to be reverted.
+var errTrashMergeHeld = errors.New("revert the merge first")
+
+// errUnknownTrashKind is returned for a kind that isn't one of trashCollections.
+var errUnknownTrashKind = errors.New("unknown trash kind")
+
+// Trash holds the soft-deleted documents visible to a user.
+type Trash struct {
+	People        []*Person       `json:"people"`
+	Families      []*Family       `json:"families"`
+	Relationships []*Relationship `json:"relationships"`
+}
+
+// TrashRestore lists the IDs brought back by a restore.
+type TrashRestore struct {
+	People        []string `json:"people"`
+	Families      []string `json:"families"`
+	Relationships []string `json:"relationships"`
+}
+
+// restoreTrashItem restores a soft-deleted person, family or relationship. ownedBy, when
+// set, limits the restore to documents owned by one of those users.
+func restoreTrashItem(ctx context.Context, kind, id string, ownedBy []string) (*TrashRestore, error) {
+	defer startSpan(ctx, "service/restoreTrashItem").End()
+	if !slices.Contains(trashCollections, kind) {
+		return nil, errUnknownTrashKind
+	}
+	return storesFrom(ctx).Trash.Restore(ctx, kind, id, ownedBy)
+}
+
+// purgeTrashItem permanently deletes a soft-deleted person, family or relationship.
+func purgeTrashItem(ctx context.Context, kind, id string) error {
+	defer startSpan(ctx, "service/purgeTrashItem").End()
+	if !slices.Contains(trashCollections, kind) {
+		return errUnknownTrashKind
+	}
+	return storesFrom(ctx).Trash.Purge(ctx, kind, id)
+}
+
+// isTrashNotFound reports whether err means the trash item doesn't exist or isn't visible.
+func isTrashNotFound(err error) bool {
+	return errors.Is(err, mongo.ErrNoDocuments)
+}
+
+// StartTrashRetention starts a background job that permanently deletes documents that have
+// been in the trash for longer than cfg.RetentionDays. A retention of 0 keeps soft-deleted
+// documents forever. The job stops when ctx is cancelled.
+func StartTrashRetention(ctx context.Context, stores *Stores, cfg TrashConfig) {
+	days := cfg.RetentionDays
+	if days == 0 {
+		logger(logTrash).InfoContext(ctx, "trash retention disabled")
+		return
+	}
+
+	purge := func() {
+		cutoff := time.Now().AddDate(0, 0, -days)
+		counts, err := stores.Trash.PurgeExpired(ctx, cutoff)
+		if err != nil {
+			logger(logTrash).ErrorContext(ctx, "retention purge failed", "error", err)
+			return
+		}
+		logger(logTrash).InfoContext(ctx, "retention purge done", "deleted_before", cutoff, "purged", counts)
+	}
+
+	go func() {
+		purge()
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trashOwnerScope returns the ownedBy filter for the current user: nil for admins, who see
+// everything, and the user's own ID otherwise.
+func trashOwnerScope(c *gin.Context) []string {
+	u, _ := c.Get("user")
+	user := u.(*User)
+	if user.Role == RoleAdmin {
+		return nil
+	}
+	return []string{user.ID}
+}
+
+func getTrash(c *gin.Context) {
+	defer startSpan(c, "handler/getTrash").End()
+	trash, err := storesFrom(c).Trash.List(c, trashOwnerScope(c))
+	if err != nil {
+		responseError(c, "Failed to fetch trash", 500)
+		return
+	}
+	responseSuccess(c, trash, 200)
+}
+
+func restoreTrash(c *gin.Context) {
+	defer startSpan(c, "handler/restoreTrash").End()
+	if !isObjectIDHex(c.Param("id")) {
+		responseError(c, "Invalid ID", 400)
+		return
+	}
+	restored, err := restoreTrashItem(c, c.Param("kind"), c.Param("id"), trashOwnerScope(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnknownTrashKind):
+			responseError(c, "Unknown trash kind", 400)
+		case errors.Is(err, errTrashDependencyDeleted):
+			responseError(c, "Restore the related person first", 409)
+		case isTrashNotFound(err):
+			responseError(c, "Item not found in trash", 404)
+		default:
+			responseError(c, "Failed to restore item", 500)
+		}
+		return
+	}
+	responseSuccess(c, restored, 200)
+}
+
+func purgeTrash(c *gin.Context) {
+	defer startSpan(c, "handler/purgeTrash").End()
+	defer securityLog(c, "trash.purge", c.Param("kind")+"/"+c.Param("id"), "")
+	if !isObjectIDHex(c.Param("id")) {
+		responseError(c, "Invalid ID", 400)
+		return
+	}
+	if err := purgeTrashItem(c, c.Param("kind"), c.Param("id")); err != nil {
+		switch {
+		case errors.Is(err, errUnknownTrashKind):
+			responseError(c, "Unknown trash kind", 400)
+		case errors.Is(err, errTrashMergeHeld):
+			responseError(c, "A merge that hasn't been reverted still needs this item", 409)
+		case isTrashNotFound(err):
+			responseError(c, "Item not found in trash", 404)
+		default:
+			responseError(c, "Failed to purge item", 500)
+		}
+		return
+	}
+	responseSuccess(c, true, 200)
+}
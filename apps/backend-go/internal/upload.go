@@ -3,7 +3,6 @@ package app
 import (
 	"fmt"
 	"mime/multipart"
-	"net/url"
 	"os"
 	"path/filepath"
 	"time"
@@ -11,96 +10,78 @@ import (
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/gin-gonic/gin"
-	"github.com/newrelic/go-agent/v3/newrelic"
 )
 
 func uploadImage(c *gin.Context, field string) (string, error) {
-	defer newrelic.StartSegment(newrelic.FromContext(c.Request.Context()), "upload/uploadImage").End()
+	defer startSpan(c, "upload/uploadImage").End()
+	log := logger(logUpload).With("field", field)
 	file, err := c.FormFile(field)
 	if err != nil {
-		fmt.Printf("[UPLOAD] Error getting form file '%s': %v\n", field, err)
+		log.WarnContext(c, "no upload in form", "error", err)
 		return "", err
 	}
 	// simple size check ~5MB
 	if file.Size > 5*1024*1024 {
-		fmt.Printf("[UPLOAD] File too large: %d bytes\n", file.Size)
+		log.WarnContext(c, "upload too large", "size", file.Size)
 		return "", fmt.Errorf("file too large")
 	}
 
-	fmt.Printf("[UPLOAD] Uploading file: %s, size: %d bytes\n", file.Filename, file.Size)
-
-	// If CLOUDINARY_URL provided, use cloudinary upload
-	cloudURL := os.Getenv("CLOUDINARY_URL")
-	if cloudURL != "" {
-		// validate URL
-		if _, err := url.ParseRequestURI(cloudURL); err == nil {
-			fmt.Printf("[UPLOAD] Using Cloudinary upload\n")
-			return uploadToCloudinary(c, file)
-		}
-		fmt.Printf("[UPLOAD] Invalid CLOUDINARY_URL, falling back to local storage\n")
-	} else {
-		fmt.Printf("[UPLOAD] CLOUDINARY_URL not set, using local storage\n")
+	// If a Cloudinary URL is configured, use cloudinary upload; LoadConfig has validated it
+	cfg := configFrom(c).Uploads
+	if cfg.CloudinaryURL != "" {
+		log.DebugContext(c, "uploading", "file", file.Filename, "size", file.Size, "backend", "cloudinary")
+		return uploadToCloudinary(c, file, cfg.CloudinaryURL)
 	}
+	log.DebugContext(c, "uploading", "file", file.Filename, "size", file.Size, "backend", "local")
 
-	// fallback: save locally to ./tmp (or UPLOAD_DIR env)
-	uploadDir := os.Getenv("UPLOAD_DIR")
-	if uploadDir == "" {
-		uploadDir = "./tmp"
-	}
+	// fallback: save locally to the upload directory
+	uploadDir := cfg.Dir
 
 	// Create upload directory if it doesn't exist
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		fmt.Printf("[UPLOAD] Error creating upload directory %s: %v\n", uploadDir, err)
+		log.ErrorContext(c, "failed to create upload directory", "dir", uploadDir, "error", err)
 		return "", err
 	}
 
 	dst := filepath.Join(uploadDir, fmt.Sprintf("upload-%d-%s", time.Now().UnixNano(), file.Filename))
 	if err := c.SaveUploadedFile(file, dst); err != nil {
-		fmt.Printf("[UPLOAD] Error saving file to %s: %v\n", dst, err)
+		log.ErrorContext(c, "failed to save upload", "path", dst, "error", err)
 		return "", err
 	}
-	fmt.Printf("[UPLOAD] File saved locally to %s\n", dst)
+	log.InfoContext(c, "upload saved", "path", dst)
 	return "/uploads/" + filepath.Base(dst), nil
 }
 
-func uploadToCloudinary(c *gin.Context, fileHeader *multipart.FileHeader) (string, error) {
-	// Instrument this as an external segment to Cloudinary's API
-	txn := newrelic.FromContext(c.Request.Context())
-	seg := &newrelic.ExternalSegment{
-		StartTime: txn.StartSegmentNow(),
-		URL:       "https://api.cloudinary.com",
-		Procedure: "Upload",
-		Library:   "cloudinary-go",
-	}
-	defer seg.End()
+func uploadToCloudinary(c *gin.Context, fileHeader *multipart.FileHeader, cloudURL string) (string, error) {
+	// Instrument this as an external call to Cloudinary's API
+	defer startExternalSpan(c, "cloudinary-go", "Upload", "https://api.cloudinary.com").End()
 
-	// create cloudinary client from env CLOUDINARY_URL
-	cld, err := cloudinary.NewFromURL(os.Getenv("CLOUDINARY_URL"))
+	log := logger(logUpload).With("backend", "cloudinary")
+	cld, err := cloudinary.NewFromURL(cloudURL)
 	if err != nil {
-		fmt.Printf("[CLOUDINARY] Error creating client: %v\n", err)
+		log.ErrorContext(c, "failed to create client", "error", err)
 		return "", err
 	}
 	f, err := fileHeader.Open()
 	if err != nil {
-		fmt.Printf("[CLOUDINARY] Error opening file: %v\n", err)
+		log.ErrorContext(c, "failed to open upload", "error", err)
 		return "", err
 	}
 	defer f.Close()
 	// uploader takes io.Reader
-	fmt.Printf("[CLOUDINARY] Uploading to Cloudinary folder: family-tree\n")
 	resp, err := cld.Upload.Upload(c, f, uploader.UploadParams{Folder: "family-tree"})
 	if err != nil {
-		fmt.Printf("[CLOUDINARY] Upload error: %v\n", err)
+		log.ErrorContext(c, "upload failed", "error", err)
 		return "", err
 	}
 	if resp.SecureURL == "" {
 		if resp.URL != "" {
-			fmt.Printf("[CLOUDINARY] Using non-secure URL: %s\n", resp.URL)
+			log.WarnContext(c, "upload saved without a secure URL", "url", resp.URL)
 			return resp.URL, nil
 		}
-		fmt.Printf("[CLOUDINARY] Empty URL from Cloudinary response\n")
+		log.ErrorContext(c, "upload response has no URL")
 		return "", fmt.Errorf("empty url from cloudinary")
 	}
-	fmt.Printf("[CLOUDINARY] Upload successful: %s\n", resp.SecureURL)
+	log.InfoContext(c, "upload saved", "url", resp.SecureURL)
 	return resp.SecureURL, nil
 }
@@ -2,8 +2,11 @@ package app
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func responseSuccess(c *gin.Context, data interface{}, statusCode ...int) {
@@ -20,3 +23,58 @@ func responseError(c *gin.Context, message string, status int) {
 	}
 	c.JSON(status, gin.H{"message": message, "status": status})
 }
+
+// responseErrorDetails is responseError with a machine-readable list of problems under "errors".
+func responseErrorDetails(c *gin.Context, message string, status int, details interface{}) {
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{"message": message, "status": status, "errors": details})
+}
+
+// isObjectIDHex reports whether id is a valid 24-character hex ObjectID.
+func isObjectIDHex(id string) bool {
+	return primitive.IsValidObjectID(id)
+}
+
+// etag formats a document version as a strong ETag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// checkIfMatch compares the If-Match header with the current version of the document. A
+// missing header or "*" always matches. On a mismatch it responds 412 with the current
+// ETag and returns false.
+func checkIfMatch(c *gin.Context, version int) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	c.Header("ETag", current)
+	responseError(c, "Document has changed; reload it and retry", http.StatusPreconditionFailed)
+	return false
+}
+
+// ifMatchVersion returns the version named by the If-Match header, for writes that must not
+// go ahead on a stale read. It responds 428 when the header is missing and 412 when it
+// isn't a single version ETag, and then returns false.
+func ifMatchVersion(c *gin.Context) (int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		responseError(c, "If-Match is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || header != etag(v) {
+		responseError(c, "If-Match must be the ETag of the current version", http.StatusPreconditionFailed)
+		return 0, false
+	}
+	return v, true
+}
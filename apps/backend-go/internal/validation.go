@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldError describes why one request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var errInvalidDate = errors.New("must be a date (YYYY-MM-DD or RFC 3339)")
+
+// parseDate accepts an RFC 3339 timestamp or a plain YYYY-MM-DD date.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, errInvalidDate
+	}
+	return t, nil
+}
+
+// validator collects field errors so a request reports every invalid field at once.
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(field, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: message})
+}
+
+// valid reports whether no field error has been recorded.
+func (v *validator) valid() bool {
+	return len(v.errs) == 0
+}
+
+// required rejects blank values.
+func (v *validator) required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.add(field, "is required")
+		return false
+	}
+	return true
+}
+
+// oneOf rejects values outside allowed.
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.add(field, "must be one of: "+strings.Join(allowed, ", "))
+}
+
+// objectID rejects values that aren't ObjectID hex strings.
+func (v *validator) objectID(field, value string) {
+	if !isObjectIDHex(value) {
+		v.add(field, "must be a valid ID")
+	}
+}
+
+// date parses an optional date; an empty value is the zero time.
+func (v *validator) date(field, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := parseDate(value)
+	if err != nil {
+		v.add(field, err.Error())
+	}
+	return t
+}
+
+// bindRequest decodes the body into dst from JSON or a (multipart) form, depending on the
+// Content-Type. Decoding failures come back as field errors.
+func bindRequest(c *gin.Context, dst interface{}) []FieldError {
+	err := c.ShouldBind(dst)
+	if err == nil {
+		return nil
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []FieldError{{Field: typeErr.Field, Message: "has the wrong type (expected " + typeErr.Type.String() + ")"}}
+	}
+	var timeErr *time.ParseError
+	if errors.As(err, &timeErr) {
+		return []FieldError{{Field: "body", Message: "dates must be RFC 3339 timestamps"}}
+	}
+	return []FieldError{{Field: "body", Message: "must be a valid JSON or form body"}}
+}
+
+// responseInvalid is the 400 response for a request with field errors.
+func responseInvalid(c *gin.Context, errs []FieldError) {
+	responseErrorDetails(c, "Invalid request body", 400, errs)
+}
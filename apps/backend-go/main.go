@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	app "github.com/fahmialfareza/family-tree/backend/internal"
@@ -11,55 +16,103 @@ import (
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"github.com/newrelic/go-agent/v3/integrations/nrgin"
 )
 
 func main() {
+	// log with the defaults until the configuration is loaded
+	app.InitLogging(app.DefaultConfig().Log)
+
 	// Load .env file if present — only attempt when the file exists.
 	if _, err := os.Stat(".env"); err == nil {
 		if err := godotenv.Load(); err != nil {
-			log.Printf("warning: failed to load .env: %v", err)
+			slog.Warn("failed to load .env", "error", err)
+		}
+	}
+
+	var cmd *command
+	if len(os.Args) > 1 {
+		c, ok := commands[os.Args[1]]
+		if !ok {
+			usage()
+			os.Exit(2)
 		}
+		cmd = &c
 	}
 
-	// graceful shutdown example
+	// Configuration is loaded and checked before anything connects, so every problem is
+	// reported at once
+	cfg, err := app.LoadConfig()
+	var problems app.ConfigError
+	if errors.As(err, &problems) {
+		fatal("invalid configuration", "problems", []string(problems))
+	}
+	app.InitLogging(cfg.Log)
+
+	// stop is cancelled by SIGINT or SIGTERM: the server drains, background jobs and
+	// subcommands give up
+	stop, cancelStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelStop()
+
+	// startup deadline for connecting and ensuring indexes
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	// Initialize MongoDB (required by repositories)
-	if err := app.InitMongo(ctx); err != nil {
-		log.Fatalf("failed to init mongo: %v", err)
+	if err := app.InitMongo(ctx, cfg); err != nil {
+		fatal("failed to init mongo", "error", err)
+	}
+
+	stores := app.NewMongoStores()
+
+	if cmd != nil {
+		err := cmd.run(stop, stores, os.Args[2:])
+		disconnect()
+		if err != nil {
+			fatal("command failed", "command", os.Args[1], "error", err)
+		}
+		return
 	}
 
 	// Ensure indexes exist (idempotent — safe to run on every startup)
 	if err := app.InitIndexes(ctx); err != nil {
-		log.Fatalf("failed to init indexes: %v", err)
+		fatal("failed to init indexes", "error", err)
+	}
+
+	// Legacy data is only normalized by the migrate command; say so when it hasn't run
+	if n, err := app.PendingMigrations(ctx); err != nil {
+		slog.Warn("failed to check migrations", "error", err)
+	} else if n > 0 {
+		slog.Warn("pending migrations, run `migrate` to apply them", "pending", n)
 	}
 
-	// initialize New Relic if configured (non-fatal)
-	nrApp, err := app.InitNewRelic()
+	// Give people created before person search their search keys
+	app.StartSearchKeyBackfill(stop)
+
+	// Permanently delete documents that have been in the trash past the retention period
+	app.StartTrashRetention(stop, stores, cfg.Trash)
+
+	// start the tracing and metrics backends (non-fatal: the server runs without them)
+	tel, err := app.InitTelemetry(ctx, cfg)
 	if err != nil {
-		log.Printf("warning: newrelic init error: %v", err)
+		slog.Warn("tracing init failed, running without tracing", "backend", cfg.Telemetry.Tracing, "error", err)
 	}
-	if nrApp != nil {
-		log.Printf("newrelic enabled")
+	if tel.Tracing() {
+		slog.Info("tracing enabled", "backend", cfg.Telemetry.Tracing)
 	}
 
-	// Initialize Gin router
-	r := gin.Default()
+	// Initialize Gin router; requests are logged by app.RequestLogger instead of gin's logger
+	r := gin.New()
+	r.Use(gin.Recovery(), app.RequestLogger(), tel.Middleware())
 
 	// CORS
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AllowHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization", "Cache-Control", "Referer", "x-requested-with", "ngrok-skip-browser-warning"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.ExposeHeaders = []string{"Content-Type", "Cache-Control"}
+	config.AllowHeaders = []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "Authorization", "Cache-Control", "Referer", "x-requested-with", "ngrok-skip-browser-warning", "X-Request-ID", "If-Match"}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	config.ExposeHeaders = []string{"Content-Type", "Cache-Control", "X-Request-ID", "ETag"}
 
 	r.Use(cors.New(config))
 
-	// New Relic middleware
-	r.Use(nrgin.Middleware(nrApp))
-
 	// Compression
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
 
@@ -70,21 +123,65 @@ func main() {
 	// Set upload limit (100 MB)
 	r.MaxMultipartMemory = 100 << 20 // 100 MB
 
-	app.RegisterRoutes(r)
+	app.RegisterRoutes(r, stores, cfg)
+
+	// Prometheus scrape endpoint, next to the health checks
+	if h := tel.MetricsHandler(); h != nil {
+		r.GET("/metrics", gin.WrapH(h))
+		slog.Info("metrics enabled", "path", "/metrics")
+	}
 
 	// catch-all for not found
 	r.NoRoute(func(c *gin.Context) {
 		c.JSON(404, gin.H{"message": "Not Found", "status": 404})
 	})
 
-	// Load envs from os
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "4000"
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
+		Handler:      r,
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+	slog.Info("listening", "addr", srv.Addr)
+
+	select {
+	case err := <-serveErr:
+		fatal("server error", "error", err)
+	case <-stop.Done():
+	}
+	// a second signal kills the process right away
+	cancelStop()
+
+	deadline := time.Duration(cfg.Server.ShutdownTimeout)
+	slog.Info("shutting down, draining requests", "deadline", deadline.String())
+	drain, cancelDrain := context.WithTimeout(context.Background(), deadline)
+	defer cancelDrain()
+	if err := srv.Shutdown(drain); err != nil {
+		slog.Warn("requests still running at the shutdown deadline were cut off", "error", err)
 	}
+	flush, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := tel.Shutdown(flush); err != nil {
+		slog.Warn("failed to flush traces", "error", err)
+	}
+	disconnect()
+	slog.Info("stopped")
+}
 
-	log.Printf("listening on :%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("server error: %v", err)
+// disconnect closes the MongoDB and Redis connections, giving up after a few seconds.
+func disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.Disconnect(ctx); err != nil {
+		slog.Warn("disconnect failed", "error", err)
 	}
 }
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
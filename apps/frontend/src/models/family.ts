@@ -1,7 +1,18 @@
 import { TPerson } from "./person";
 
+export type TFamilyCouple = {
+  person: string;
+  spouse?: string;
+};
+
 export type TFamily = {
   _id: string;
   name: string;
+  description?: string;
+  coverUrl?: string;
   person: string | TPerson;
+  founders?: TFamilyCouple[];
+  membership?: "descendants" | "explicit";
+  members?: string[];
+  version?: number;
 };
@@ -11,6 +11,7 @@ export type TPerson = {
   birthDate: Date;
   phone?: string;
   photoUrl?: string;
+  version?: number;
 
   relationships?: TRelationship[];
   owners?: TUser[];
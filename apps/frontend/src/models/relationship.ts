@@ -6,6 +6,20 @@ export type TRelationship = {
   to: string;
   order?: number;
   type: "parent" | "spouse" | "child";
+  subtype?:
+    | "biological"
+    | "adoptive"
+    | "step"
+    | "foster"
+    | "guardian"
+    | "unknown";
+  marriage?: {
+    status?: "married" | "divorced" | "widowed" | "partner";
+    startDate?: Date;
+    endDate?: Date;
+    place?: string;
+  };
+  version?: number;
 
   fromDetails?: TPerson;
   toDetails?: TPerson;